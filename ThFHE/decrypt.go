@@ -0,0 +1,190 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package thfhe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/sp301415/tfhe-go/math/csprng"
+	"github.com/sp301415/tfhe-go/math/vec"
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Threshold decryption, each party computes a decryption share <a, s_k> + e_k of each ciphertext (b, a) with its key share s_k
+// and smudging noise e_k, the phase b + sum_k <a, s_k> + e_k = m + e + sum_k e_k is only known when the shares of all parties are combined
+// the smudging noise hides the key share in the decryption shares, and is small enough to keep the bits of the result
+const (
+	DecryptionShare_Magic = "GVDS"
+	// standard deviation of the smudging noise of all parties together
+	Smudging_StdDev = 1.0 / (1 << 10)
+)
+
+// Decryption share of a party for a list of ciphertexts
+type DecryptionShare struct {
+	ID      int
+	Parties int
+	// sha256 digest of the ciphertexts, so that shares of different results are never mixed
+	Digest [sha256.Size]byte
+	Values []uint32
+}
+
+// Digest of a ciphertext
+func ciphertextDigest(ct tfhe.LWECiphertext[uint32]) [sha256.Size]byte {
+	return sha256.Sum256(appendUint32s(nil, ct.Value))
+}
+
+// Digest of a list of ciphertexts
+func CiphertextsDigest(cts []tfhe.LWECiphertext[uint32]) [sha256.Size]byte {
+	h := sha256.New()
+	for _, ct := range cts {
+		d := ciphertextDigest(ct)
+		h.Write(d[:])
+	}
+	var digest [sha256.Size]byte
+	h.Sum(digest[:0])
+	return digest
+}
+
+// Compute the decryption share of the party of share for cts
+func PartialDecrypt(share KeyShare, cts []tfhe.LWECiphertext[uint32]) DecryptionShare {
+	smudging := csprng.NewGaussianSamplerTorus[uint32](Smudging_StdDev / math.Sqrt(float64(share.Parties)))
+	res := DecryptionShare{ID: share.ID, Parties: share.Parties, Digest: CiphertextsDigest(cts), Values: make([]uint32, len(cts))}
+	for i, ct := range cts {
+		// ciphertexts under the LWE key or the LWE large key, the LWE key is the beginning of the large one
+		if len(ct.Value)-1 > len(share.Key) {
+			log.Fatalf("The ciphertext %d has dimension %d, larger than the key share", i, len(ct.Value)-1)
+		}
+		res.Values[i] = vec.Dot(ct.Value[1:], share.Key[:len(ct.Value)-1]) + smudging.Sample()
+	}
+	return res
+}
+
+// Encode a decryption share to send it to the combiner
+func (share DecryptionShare) MarshalBinary() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(DecryptionShare_Magic)
+	binary.Write(&buf, binary.LittleEndian, uint16(Storage_Version))
+	binary.Write(&buf, binary.LittleEndian, uint16(share.Parties))
+	binary.Write(&buf, binary.LittleEndian, uint16(share.ID))
+	buf.Write(share.Digest[:])
+	binary.Write(&buf, binary.LittleEndian, uint32(len(share.Values)))
+	buf.Write(appendUint32s(nil, share.Values))
+	return buf.Bytes()
+}
+
+// Decode a decryption share
+func UnmarshalDecryptionShare(data []byte) (DecryptionShare, error) {
+	var share DecryptionShare
+	head := len(DecryptionShare_Magic) + 6 + sha256.Size + 4
+	if len(data) < head || string(data[:len(DecryptionShare_Magic)]) != DecryptionShare_Magic {
+		return share, errors.New("not a decryption share")
+	}
+	data = data[len(DecryptionShare_Magic):]
+	if version := binary.LittleEndian.Uint16(data); version != Storage_Version {
+		return share, fmt.Errorf("unsupported version %d", version)
+	}
+	share.Parties = int(binary.LittleEndian.Uint16(data[2:]))
+	share.ID = int(binary.LittleEndian.Uint16(data[4:]))
+	copy(share.Digest[:], data[6:6+sha256.Size])
+	count := int(binary.LittleEndian.Uint32(data[6+sha256.Size:]))
+	data = data[10+sha256.Size:]
+	if len(data) != 4*count {
+		return share, errors.New("the decryption share is damaged")
+	}
+	share.Values = readUint32s(data)
+	return share, nil
+}
+
+// Decryptor of the ciphertexts whose decryption shares of all parties are combined
+type Decryptor struct {
+	encoder *tfhe.BinaryEncoder
+	phases  map[[sha256.Size]byte]uint32
+}
+
+// Combine the decryption shares of cts, fails unless there is exactly one share of every party for these ciphertexts
+func Combine(params tfhe.Parameters[uint32], cts []tfhe.LWECiphertext[uint32], shares []DecryptionShare) (*Decryptor, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no decryption shares")
+	}
+	parties := shares[0].Parties
+	digest := CiphertextsDigest(cts)
+	seen := make([]bool, parties+1)
+	for _, share := range shares {
+		if share.Parties != parties {
+			return nil, fmt.Errorf("decryption shares of %d and %d parties are mixed", parties, share.Parties)
+		}
+		if share.ID < 1 || share.ID > parties || seen[share.ID] {
+			return nil, fmt.Errorf("invalid or repeated decryption share of party %d", share.ID)
+		}
+		if share.Digest != digest || len(share.Values) != len(cts) {
+			return nil, fmt.Errorf("the decryption share of party %d is for other ciphertexts", share.ID)
+		}
+		seen[share.ID] = true
+	}
+	if len(shares) != parties {
+		return nil, fmt.Errorf("need the decryption shares of all %d parties, got %d", parties, len(shares))
+	}
+
+	d := &Decryptor{encoder: tfhe.NewBinaryEncoder(params), phases: make(map[[sha256.Size]byte]uint32, len(cts))}
+	for i, ct := range cts {
+		phase := ct.Value[0]
+		for _, share := range shares {
+			phase += share.Values[i]
+		}
+		d.phases[ciphertextDigest(ct)] = phase
+	}
+	return d, nil
+}
+
+// Decrypt a ciphertext of a bit, it must be one of the combined ciphertexts
+func (d *Decryptor) DecryptLWEBool(ct tfhe.LWECiphertext[uint32]) bool {
+	phase, ok := d.phases[ciphertextDigest(ct)]
+	if !ok {
+		log.Fatalf("The ciphertext is not decrypted by the key share holders")
+	}
+	return d.encoder.DecodeLWEBool(tfhe.LWEPlaintext[uint32]{Value: phase})
+}
+
+// Decrypt cts with the sealed key shares of all parties kept on this machine, for demos where the parties are simulated
+func DecryptWithKeyShares(params tfhe.Parameters[uint32], cts []tfhe.LWECiphertext[uint32]) *Decryptor {
+	first := ReadKeyShare(params, 1, KeySharePassphrase(1))
+	shares := []DecryptionShare{PartialDecrypt(first, cts)}
+	for id := 2; id <= first.Parties; id++ {
+		shares = append(shares, PartialDecrypt(ReadKeyShare(params, id, KeySharePassphrase(id)), cts))
+	}
+	d, err := Combine(params, cts, shares)
+	if err != nil {
+		log.Fatalf("can not decrypt, err is %+v", err)
+	}
+	return d
+}
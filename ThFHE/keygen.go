@@ -0,0 +1,561 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package thfhe
+
+import (
+	"Governome/auxiliary"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/sp301415/tfhe-go/math/csprng"
+	"github.com/sp301415/tfhe-go/math/poly"
+	"github.com/sp301415/tfhe-go/math/vec"
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Distributed generation of the TFHE keys among parties 1, 2, ..., k, secure against semi-honest parties
+// every coefficient of the LWE large key is the XOR of a random bit of each party, so it is binary and unknown to any k - 1 parties
+// conversion: the XOR is turned into additive shares modulo 2^32 by x_j = x_{j-1} + b_j (1 - 2 x_{j-1}) for the bit b_j of
+// party j, each party q != j turns its share of b_j (1 - 2 x_{j-1}) into additive shares with j by a 1-out-of-2 oblivious
+// transfer chosen by b_j, so the key share of every party is uniform and the key is their sum
+// the GGSW rows of the bootstrap key encrypt s_i * z_c for the key coefficient s_i and the GLWE key polynomial z_c, which are
+// shared the same way by X_j = X_{j-1} + b_j (z_c - 2 X_{j-1}) with the additive shares of z_c
+// seed: every party contributes randomness to a common reference string (CRS), all uniform masks are derived from it
+// public key: the rows of the LWE public key, the keyswitch key and the bootstrap key use CRS masks, so the bodies are
+// sums of the shares <a, s_p> + e_p of all parties, plus the share of the plaintext
+// the noise of each party has the standard deviation of params over sqrt(k), so the keys have the noise of params
+// a malformed message of another party, or a failure of the transport, stops the protocol with an error
+const (
+	round_Seed = iota
+	round_OTChoice
+	round_PublicKey
+	round_BootstrapKey
+	// rounds of the conversion, round_Convert + j for the key and round_Convert + k + j for the bootstrap key, for party j
+	round_Convert
+	crsSeedSize = 32
+	// degree up to which tfhe-go multiplies polynomials by its schoolbook method, which drops the terms of p0[0] * p1[j] for j > 0
+	schoolbookDegree = 64
+)
+
+// Key share of a party, an additive share modulo 2^32 of every coefficient of the LWE large key
+// the secret key is the sum of the key shares of all parties, and is never held by anyone
+type KeyShare struct {
+	ID      int
+	Parties int
+	Params  tfhe.Parameters[uint32]
+	Key     []uint32
+}
+
+// Keys jointly generated by the parties, the same for all of them
+type Keys struct {
+	PublicKey     auxiliary.PublicKey_tfheb
+	EvaluationKey tfhe.EvaluationKey[uint32]
+}
+
+// LWE part of a key share
+func (share KeyShare) LWEKey() []uint32 {
+	return share.Key[:share.Params.LWEDimension()]
+}
+
+// Check the number of parties against params, the XOR of bits is a binary key, so the key can not be block binary
+func CheckParties(params tfhe.Parameters[uint32], parties int) {
+	if params.BlockSize() != 1 {
+		log.Fatalf("The key of block size %d can not be generated jointly, only binary keys are supported", params.BlockSize())
+	}
+	if parties < 2 || parties > math.MaxUint16 {
+		log.Fatalf("Invalid number of parties %d, it must be at least 2", parties)
+	}
+}
+
+// Key generation state of a party
+type keygen struct {
+	params  tfhe.Parameters[uint32]
+	id      int
+	parties int
+	t       Transport
+
+	// bit of this party of every coefficient of the LWE large key, and the additive share of the coefficient
+	bits    []uint32
+	key     []uint32
+	glweKey []poly.Poly[uint32]
+	crs     []byte
+
+	pe        *poly.Evaluator[uint32]
+	lweNoise  csprng.GaussianSampler[uint32]
+	glweNoise csprng.GaussianSampler[uint32]
+
+	// shared points of the oblivious transfers of every coefficient, received[q-1] from sender q and sent[p-1] to receiver p
+	otSecret   *big.Int
+	otPoints   []bn254.G1Affine
+	otReceived [][]bn254.G1Affine
+	otSent     [][][2]bn254.G1Affine
+
+	// additive shares of the GGSW plaintexts of the bootstrap key, [i][j] for the LWE key coefficient i and the row j
+	ggsw [][]poly.Poly[uint32]
+	keys Keys
+}
+
+// Run the key generation as party id of parties over t, return the key share of the party and the joint keys
+// fails if the transport fails or another party sends a malformed message
+func GenKeys(params tfhe.Parameters[uint32], id, parties int, t Transport) (KeyShare, Keys, error) {
+	CheckParties(params, parties)
+	if id < 1 || id > parties {
+		log.Fatalf("Invalid party %d, it must be from 1 to %d", id, parties)
+	}
+	kg := &keygen{
+		params:    params,
+		id:        id,
+		parties:   parties,
+		t:         t,
+		pe:        poly.NewEvaluator[uint32](params.PolyDegree()),
+		lweNoise:  csprng.NewGaussianSamplerTorus[uint32](params.LWEStdDev() / math.Sqrt(float64(parties))),
+		glweNoise: csprng.NewGaussianSamplerTorus[uint32](params.GLWEStdDev() / math.Sqrt(float64(parties))),
+	}
+	kg.sampleBits()
+	for _, round := range []func() error{kg.seedRound, kg.otChoiceRound, kg.convertKey, kg.convertGGSW, kg.publicKeyRound, kg.bootstrapKeyRound} {
+		if err := round(); err != nil {
+			return KeyShare{}, Keys{}, err
+		}
+	}
+	return KeyShare{ID: id, Parties: parties, Params: params, Key: kg.key}, kg.keys, nil
+}
+
+// Run the key generation of all parties simulated in this process, return the key shares by party ID - 1 and the joint keys
+func SimulateGenKeys(params tfhe.Parameters[uint32], parties int) ([]KeyShare, Keys) {
+	CheckParties(params, parties)
+	transports := NewLocalTransports(parties)
+	shares := make([]KeyShare, parties)
+	var keys Keys
+	var wg sync.WaitGroup
+	for id := 1; id <= parties; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			share, k, err := GenKeys(params, id, parties, transports[id-1])
+			if err != nil {
+				log.Fatalf("can not generate the keys of party %d, err is %+v", id, err)
+			}
+			shares[id-1] = share
+			if id == 1 {
+				keys = k
+			}
+		}(id)
+	}
+	wg.Wait()
+	return shares, keys
+}
+
+// Sample the bit of this party of every coefficient of the key
+func (kg *keygen) sampleBits() {
+	kg.bits = make([]uint32, kg.params.LWELargeDimension())
+	csprng.NewBinarySampler[uint32]().SampleSliceAssign(kg.bits)
+}
+
+// Uniform sampler of the masks labelled label, the same for all parties
+func (kg *keygen) crsSampler(label string) csprng.UniformSampler[uint32] {
+	seed := sha256.Sum256(append(append([]byte{}, kg.crs...), label...))
+	return csprng.NewUniformSamplerWithSeed[uint32](seed[:])
+}
+
+// Gather the messages of a round, each must have the given length
+func (kg *keygen) gather(round, length int) ([][]byte, error) {
+	msgs, err := kg.t.Gather(round)
+	if err != nil {
+		return nil, err
+	}
+	for id := 1; id <= kg.parties; id++ {
+		if len(msgs[id-1]) != length {
+			return nil, fmt.Errorf("the message of party %d in round %d has a wrong length", id, round)
+		}
+	}
+	return msgs, nil
+}
+
+// Broadcast the message of a round and gather the messages of all parties, each must have the length of the own message
+func (kg *keygen) exchange(round int, msg []byte) ([][]byte, error) {
+	if err := kg.t.Broadcast(round, msg); err != nil {
+		return nil, err
+	}
+	return kg.gather(round, len(msg))
+}
+
+// Derive the CRS from the randomness of all parties, along with the oblivious transfer point of each party as a sender
+func (kg *keygen) seedRound() error {
+	var point bn254.G1Affine
+	kg.otSecret, point = otSenderSetup()
+	pointBytes := point.Bytes()
+	msgs, err := kg.exchange(round_Seed, append(auxiliary.RandomBytes(crsSeedSize), pointBytes[:]...))
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	kg.otPoints = make([]bn254.G1Affine, kg.parties)
+	for id, msg := range msgs {
+		h.Write(msg[:crsSeedSize])
+		if kg.otPoints[id], err = otPoint(msg[crsSeedSize:]); err != nil {
+			return fmt.Errorf("party %d sent an invalid seed, err is %w", id+1, err)
+		}
+	}
+	kg.crs = h.Sum(nil)
+	return nil
+}
+
+// As the receiver, choose by the own bit of every key coefficient in a transfer from every other party
+// the message holds for each sender q != id in order, a point for each coefficient
+// as the sender, derive the shared points of the transfers to every other party
+func (kg *keygen) otChoiceRound() error {
+	large := kg.params.LWELargeDimension()
+	kg.otReceived = make([][]bn254.G1Affine, kg.parties)
+	var msg []byte
+	for q := 1; q <= kg.parties; q++ {
+		if q == kg.id {
+			continue
+		}
+		kg.otReceived[q-1] = make([]bn254.G1Affine, large)
+		for i := 0; i < large; i++ {
+			b, B := otChoose(kg.otPoints[q-1], kg.bits[i])
+			kg.otReceived[q-1][i] = otReceiverPoint(kg.otPoints[q-1], b)
+			pointBytes := B.Bytes()
+			msg = append(msg, pointBytes[:]...)
+		}
+	}
+	choices, err := kg.exchange(round_OTChoice, msg)
+	if err != nil {
+		return err
+	}
+
+	kg.otSent = make([][][2]bn254.G1Affine, kg.parties)
+	for p := 1; p <= kg.parties; p++ {
+		if p == kg.id {
+			continue
+		}
+		offset := otherIndex(kg.id, p) * large * OT_PointSize
+		kg.otSent[p-1] = make([][2]bn254.G1Affine, large)
+		for i := 0; i < large; i++ {
+			B, err := otPoint(choices[p-1][offset+i*OT_PointSize : offset+(i+1)*OT_PointSize])
+			if err != nil {
+				return fmt.Errorf("party %d sent an invalid choice, err is %w", p, err)
+			}
+			kg.otSent[p-1][i] = otSenderPoints(kg.otSecret, kg.otPoints[kg.id-1], B)
+		}
+	}
+	return nil
+}
+
+// Index of party id among the parties other than skip
+func otherIndex(id, skip int) int {
+	if id < skip {
+		return id - 1
+	}
+	return id - 2
+}
+
+// Index of the transfer of coefficient i in lane l, lane 0 for the key and lane c + 1 for the GLWE key polynomial c
+func (kg *keygen) transferIndex(i, l int) int {
+	return i*(kg.params.GLWEDimension()+1) + l
+}
+
+// One step of the conversion for party j: X += b_j * (Z - 2X) for the additive shares X[i][l] and Z(i, l) of the coefficients i
+// in lanes l, party j adds its own term and the transfers of the other parties, which keep the opposite masks
+// the message of a party q != j holds for each coefficient and lane the two masked choices, party j sends nothing
+func (kg *keygen) convertStep(round, j int, X [][][]uint32, Z func(i, l int) []uint32, lane func(l int) int) error {
+	if kg.id != j {
+		mask := csprng.NewUniformSampler[uint32]()
+		msg := make([]byte, 0)
+		for i := range X {
+			for l := range X[i] {
+				Y := make([]uint32, len(X[i][l]))
+				vec.ScalarMulAssign(X[i][l], 2, Y)
+				vec.SubAssign(Z(i, l), Y, Y)
+				R := make([]uint32, len(Y))
+				mask.SampleSliceAssign(R)
+				vec.SubAssign(X[i][l], R, X[i][l])
+
+				index := kg.transferIndex(i, lane(l))
+				choice := otPad(kg.otSent[j-1][i][0], kg.id, j, index, len(Y))
+				vec.AddAssign(choice, R, choice)
+				msg = appendUint32s(msg, choice)
+				choice = otPad(kg.otSent[j-1][i][1], kg.id, j, index, len(Y))
+				vec.AddAssign(choice, R, choice)
+				vec.AddAssign(choice, Y, choice)
+				msg = appendUint32s(msg, choice)
+			}
+		}
+		if err := kg.t.Broadcast(round, msg); err != nil {
+			return err
+		}
+		_, err := kg.t.Gather(round)
+		return err
+	}
+
+	length := 0
+	for i := range X {
+		for l := range X[i] {
+			Y := make([]uint32, len(X[i][l]))
+			vec.ScalarMulAssign(X[i][l], 2, Y)
+			vec.SubAssign(Z(i, l), Y, Y)
+			vec.ScalarMulAddAssign(Y, kg.bits[i], X[i][l])
+			length += 2 * 4 * len(Y)
+		}
+	}
+	if err := kg.t.Broadcast(round, make([]byte, 0)); err != nil {
+		return err
+	}
+	msgs, err := kg.t.Gather(round)
+	if err != nil {
+		return err
+	}
+	for q := 1; q <= kg.parties; q++ {
+		if q == kg.id {
+			continue
+		}
+		if len(msgs[q-1]) != length {
+			return fmt.Errorf("the message of party %d in round %d has a wrong length", q, round)
+		}
+		offset := 0
+		for i := range X {
+			for l := range X[i] {
+				L := len(X[i][l])
+				start := offset + int(kg.bits[i])*L*4
+				choice := readUint32s(msgs[q-1][start : start+L*4])
+				vec.SubAssign(choice, otPad(kg.otReceived[q-1][i], q, kg.id, kg.transferIndex(i, lane(l)), L), choice)
+				vec.AddAssign(X[i][l], choice, X[i][l])
+				offset += 2 * L * 4
+			}
+		}
+	}
+	return nil
+}
+
+// Convert the bits of all key coefficients into additive shares of the key, x_1 = b_1 is held by party 1
+func (kg *keygen) convertKey() error {
+	large, N := kg.params.LWELargeDimension(), kg.params.PolyDegree()
+	X := make([][][]uint32, large)
+	one := []uint32{0}
+	if kg.id == 1 {
+		one[0] = 1
+	}
+	for i := 0; i < large; i++ {
+		X[i] = [][]uint32{{0}}
+		if kg.id == 1 {
+			X[i][0][0] = kg.bits[i]
+		}
+	}
+	for j := 2; j <= kg.parties; j++ {
+		if err := kg.convertStep(round_Convert+j, j, X, func(i, l int) []uint32 { return one }, func(l int) int { return 0 }); err != nil {
+			return err
+		}
+	}
+
+	kg.key = make([]uint32, large)
+	for i := 0; i < large; i++ {
+		kg.key[i] = X[i][0][0]
+	}
+	kg.glweKey = make([]poly.Poly[uint32], kg.params.GLWEDimension())
+	for c := 0; c < len(kg.glweKey); c++ {
+		kg.glweKey[c] = poly.Poly[uint32]{Coeffs: kg.key[c*N : (c+1)*N]}
+	}
+	return nil
+}
+
+// Convert the products s_i * z_c of the LWE key coefficients and the GLWE key polynomials into additive shares
+func (kg *keygen) convertGGSW() error {
+	n, k := kg.params.LWEDimension(), kg.params.GLWEDimension()
+	X := make([][][]uint32, n)
+	for i := 0; i < n; i++ {
+		X[i] = make([][]uint32, k)
+		for c := 0; c < k; c++ {
+			X[i][c] = make([]uint32, kg.params.PolyDegree())
+		}
+	}
+	for j := 1; j <= kg.parties; j++ {
+		if err := kg.convertStep(round_Convert+kg.parties+j, j, X, func(i, c int) []uint32 { return kg.glweKey[c].Coeffs }, func(c int) int { return c + 1 }); err != nil {
+			return err
+		}
+	}
+
+	kg.ggsw = make([][]poly.Poly[uint32], n)
+	for i := 0; i < n; i++ {
+		kg.ggsw[i] = make([]poly.Poly[uint32], k+1)
+		kg.ggsw[i][0] = kg.pe.NewPoly()
+		kg.ggsw[i][0].Coeffs[0] = kg.key[i]
+		for c := 0; c < k; c++ {
+			kg.ggsw[i][c+1] = poly.Poly[uint32]{Coeffs: X[i][c]}
+		}
+	}
+	kg.otReceived, kg.otSent = nil, nil
+	return nil
+}
+
+// Shares of the LWE public key and the keyswitch key, with CRS masks
+func (kg *keygen) publicKeyRound() error {
+	n, large := kg.params.LWEDimension(), kg.params.LWELargeDimension()
+	kskParams := kg.params.KeySwitchParameters()
+	s := kg.key[:n]
+
+	pk := &kg.keys.PublicKey
+	pk.Params = kg.params
+	pk.A = make([][]uint32, n)
+	pk.B = make([]uint32, n)
+	sampler := kg.crsSampler("public key")
+	for r := 0; r < n; r++ {
+		pk.A[r] = make([]uint32, n)
+		sampler.SampleSliceAssign(pk.A[r])
+		pk.B[r] = vec.Dot(pk.A[r], s) + kg.glweNoise.Sample()
+	}
+
+	ksk := tfhe.NewKeySwitchKeyForBootstrap(kg.params)
+	bodies := make([]uint32, 0, (large-n)*kskParams.Level())
+	sampler = kg.crsSampler("keyswitch key")
+	for i := 0; i < large-n; i++ {
+		for j := 0; j < kskParams.Level(); j++ {
+			ct := ksk.Value[i].Value[j].Value
+			sampler.SampleSliceAssign(ct[1:])
+			body := -vec.Dot(ct[1:], s) + kg.lweNoise.Sample() + kg.key[n+i]<<kskParams.ScaledBaseLog(j)
+			bodies = append(bodies, body)
+		}
+	}
+
+	msg := appendUint32s(appendUint32s(nil, pk.B), bodies)
+	msgs, err := kg.exchange(round_PublicKey, msg)
+	if err != nil {
+		return err
+	}
+
+	vec.Fill(pk.B, 0)
+	vec.Fill(bodies, 0)
+	for id := 1; id <= kg.parties; id++ {
+		m := msgs[id-1]
+		vec.AddAssign(pk.B, readUint32s(m[:4*n]), pk.B)
+		vec.AddAssign(bodies, readUint32s(m[4*n:]), bodies)
+	}
+	for i := 0; i < large-n; i++ {
+		for j := 0; j < kskParams.Level(); j++ {
+			ksk.Value[i].Value[j].Value[0] = bodies[i*kskParams.Level()+j]
+		}
+	}
+	kg.keys.EvaluationKey.KeySwitchKey = ksk
+	return nil
+}
+
+// Shares of the bodies of the GGSW rows of the bootstrap key, with CRS masks
+func (kg *keygen) bootstrapKeyRound() error {
+	n, N, k := kg.params.LWEDimension(), kg.params.PolyDegree(), kg.params.GLWEDimension()
+	bskParams := kg.params.BootstrapParameters()
+	rows := n * (k + 1) * bskParams.Level()
+
+	masks := make([]uint32, rows*k*N)
+	sampler := kg.crsSampler("bootstrap key")
+	sampler.SampleSliceAssign(masks)
+
+	body := kg.pe.NewPoly()
+	msg := make([]byte, 0, rows*N*4)
+	row := 0
+	for i := 0; i < n; i++ {
+		for j := 0; j < k+1; j++ {
+			for l := 0; l < bskParams.Level(); l++ {
+				kg.pe.ScalarMulAssign(kg.ggsw[i][j], bskParams.ScaledBase(l), body)
+				for c := 0; c < k; c++ {
+					a := poly.Poly[uint32]{Coeffs: masks[(row*k+c)*N : (row*k+c+1)*N]}
+					kg.mulSubAssign(a, kg.glweKey[c], body)
+				}
+				kg.glweNoise.SampleSliceAddAssign(body.Coeffs)
+				msg = appendUint32s(msg, body.Coeffs)
+				row++
+			}
+		}
+	}
+	kg.ggsw = nil
+	msgs, err := kg.exchange(round_BootstrapKey, msg)
+	if err != nil {
+		return err
+	}
+
+	eval := tfhe.NewEvaluatorWithoutKey(kg.params)
+	bsk := tfhe.NewBootstrapKey(kg.params)
+	ct := tfhe.NewGLWECiphertext(kg.params)
+	row = 0
+	for i := 0; i < n; i++ {
+		for j := 0; j < k+1; j++ {
+			for l := 0; l < bskParams.Level(); l++ {
+				ct.Value[0].Clear()
+				for _, m := range msgs {
+					vec.AddAssign(ct.Value[0].Coeffs, readUint32s(m[row*N*4:(row+1)*N*4]), ct.Value[0].Coeffs)
+				}
+				for c := 0; c < k; c++ {
+					copy(ct.Value[c+1].Coeffs, masks[(row*k+c)*N:(row*k+c+1)*N])
+				}
+				eval.ToFourierGLWECiphertextAssign(ct, bsk.Value[i].Value[j].Value[l])
+				row++
+			}
+		}
+	}
+	kg.keys.EvaluationKey.BootstrapKey = bsk
+	return nil
+}
+
+// Subtract the negacyclic product of a and b from out
+func (kg *keygen) mulSubAssign(a, b, out poly.Poly[uint32]) {
+	N := len(out.Coeffs)
+	if N > schoolbookDegree {
+		kg.pe.MulSubAssign(a, b, out)
+		return
+	}
+	for i := 0; i < N; i++ {
+		for j := 0; j < N; j++ {
+			if i+j < N {
+				out.Coeffs[i+j] -= a.Coeffs[i] * b.Coeffs[j]
+			} else {
+				out.Coeffs[i+j-N] += a.Coeffs[i] * b.Coeffs[j]
+			}
+		}
+	}
+}
+
+// Append uint32 values in little endian
+func appendUint32s(b []byte, v []uint32) []byte {
+	for _, x := range v {
+		b = binary.LittleEndian.AppendUint32(b, x)
+	}
+	return b
+}
+
+// Read uint32 values in little endian
+func readUint32s(b []byte) []uint32 {
+	res := make([]uint32, len(b)/4)
+	for i := range res {
+		res[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return res
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package thfhe
+
+import (
+	"Governome/auxiliary"
+	"math/rand"
+	"testing"
+
+	"github.com/sp301415/tfhe-go/math/vec"
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Encryptor with the sum of the key shares, which no party holds
+func combinedEncryptor(params tfhe.Parameters[uint32], shares []KeyShare) *tfhe.BinaryEncryptor {
+	enc := tfhe.NewBinaryEncryptor(params)
+	key := enc.BaseEncryptor.SecretKey.LWELargeKey.Value
+	vec.Fill(key, 0)
+	for _, share := range shares {
+		vec.AddAssign(key, share.Key, key)
+	}
+	return enc
+}
+
+func TestGenKeys(t *testing.T) {
+	params := auxiliary.ParamsToyBoolean.Compile()
+	for _, parties := range []int{2, 3} {
+		shares, keys := SimulateGenKeys(params, parties)
+		enc := combinedEncryptor(params, shares)
+		key := enc.BaseEncryptor.SecretKey.LWELargeKey.Value
+
+		for i, s := range key {
+			if s > 1 {
+				t.Fatalf("%d parties: coefficient %d of the key is %d, not binary", parties, i, s)
+			}
+		}
+		// every coefficient of a key share is uniform, so a share does not reveal any coefficient of the key
+		for _, share := range shares {
+			revealed := 0
+			for i, s := range share.Key {
+				if s == key[i] {
+					revealed++
+				}
+			}
+			if revealed > len(key)/4 {
+				t.Errorf("%d parties: the share of party %d equals the key in %d of %d coefficients", parties, share.ID, revealed, len(key))
+			}
+		}
+
+		// the public key is an encryption of zero under the key
+		for r := range keys.PublicKey.B {
+			e := keys.PublicKey.B[r] - vec.Dot(keys.PublicKey.A[r], key[:params.LWEDimension()])
+			if e > 1<<24 && -e > 1<<24 {
+				t.Errorf("%d parties: row %d of the public key has noise %d", parties, r, int32(e))
+			}
+		}
+
+		// the bootstrap key and the keyswitch key evaluate gates under the key
+		eval := tfhe.NewBinaryEvaluator(params, keys.EvaluationKey)
+		for trial := 0; trial < 20; trial++ {
+			a, b := rand.Intn(2) == 1, rand.Intn(2) == 1
+			ca, cb := enc.EncryptLWEBool(a), enc.EncryptLWEBool(b)
+			if got := enc.DecryptLWEBool(eval.AND(ca, cb)); got != (a && b) {
+				t.Fatalf("%d parties: %v AND %v is %v", parties, a, b, got)
+			}
+			if got := enc.DecryptLWEBool(eval.XOR(ca, cb)); got != (a != b) {
+				t.Fatalf("%d parties: %v XOR %v is %v", parties, a, b, got)
+			}
+		}
+
+		// threshold decryption with the shares
+		bits := make([]bool, 16)
+		cts := make([]tfhe.LWECiphertext[uint32], len(bits))
+		for i := range bits {
+			bits[i] = rand.Intn(2) == 1
+			cts[i] = eval.XOR(enc.EncryptLWEBool(bits[i]), enc.EncryptLWEBool(false))
+		}
+		var dshares []DecryptionShare
+		for _, share := range shares {
+			dshares = append(dshares, PartialDecrypt(share, cts))
+		}
+		d, err := Combine(params, cts, dshares)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range bits {
+			if d.DecryptLWEBool(cts[i]) != bits[i] {
+				t.Fatalf("%d parties: bit %d is not decrypted by the shares", parties, i)
+			}
+		}
+		if _, err := Combine(params, cts, dshares[1:]); err == nil {
+			t.Errorf("%d parties: decrypted without all shares", parties)
+		}
+	}
+}
+
+func TestObliviousTransfer(t *testing.T) {
+	a, A := otSenderSetup()
+	for _, choice := range []uint32{0, 1} {
+		b, B := otChoose(A, choice)
+		received := otPad(otReceiverPoint(A, b), 1, 2, 7, 8)
+		sent := otSenderPoints(a, A, B)
+		if !equalUint32s(received, otPad(sent[choice], 1, 2, 7, 8)) {
+			t.Errorf("choice %d: the receiver does not get the chosen pad", choice)
+		}
+		if equalUint32s(received, otPad(sent[1-choice], 1, 2, 7, 8)) {
+			t.Errorf("choice %d: the receiver gets the other pad", choice)
+		}
+		if equalUint32s(received, otPad(otReceiverPoint(A, b), 1, 2, 8, 8)) {
+			t.Errorf("choice %d: the pads of two transfers are equal", choice)
+		}
+	}
+}
+
+func equalUint32s(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package thfhe
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/sp301415/tfhe-go/math/csprng"
+)
+
+// 1-out-of-2 oblivious transfer of Chou and Orlandi on the G1 group of BN254
+// the sender publishes A = aG once, the receiver with choice c publishes B = bG + cA for each transfer,
+// the sender masks the two messages with H(aB) and H(a(B - A)), and the receiver can only unmask message c with H(bA)
+const OT_PointSize = bn254.SizeOfG1AffineCompressed
+
+// Sample a scalar of the group from the CSPRNG
+func otScalar() *big.Int {
+	s, err := rand.Int(rand.Reader, fr.Modulus())
+	if err != nil {
+		log.Fatalf("can not read the CSPRNG, err is %+v", err)
+	}
+	return s
+}
+
+// Get the point of a scalar
+func otBase(s *big.Int) bn254.G1Affine {
+	var p bn254.G1Affine
+	p.ScalarMultiplicationBase(s)
+	return p
+}
+
+// Read a point, fails if it is not in the group
+func otPoint(b []byte) (bn254.G1Affine, error) {
+	var p bn254.G1Affine
+	if _, err := p.SetBytes(b); err != nil {
+		return p, fmt.Errorf("an oblivious transfer point is damaged, err is %w", err)
+	}
+	return p, nil
+}
+
+// Sender: publish A = aG
+func otSenderSetup() (*big.Int, bn254.G1Affine) {
+	a := otScalar()
+	return a, otBase(a)
+}
+
+// Receiver: B = bG + cA for the choice c, the pad key is derived from bA
+func otChoose(A bn254.G1Affine, choice uint32) (*big.Int, bn254.G1Affine) {
+	b := otScalar()
+	B := otBase(b)
+	if choice == 1 {
+		B.Add(&B, &A)
+	}
+	return b, B
+}
+
+// Receiver: the shared point bA
+func otReceiverPoint(A bn254.G1Affine, b *big.Int) bn254.G1Affine {
+	var p bn254.G1Affine
+	p.ScalarMultiplication(&A, b)
+	return p
+}
+
+// Sender: the shared points aB and a(B - A) of choice 0 and 1
+func otSenderPoints(a *big.Int, A, B bn254.G1Affine) [2]bn254.G1Affine {
+	var res [2]bn254.G1Affine
+	res[0].ScalarMultiplication(&B, a)
+	var diff bn254.G1Affine
+	diff.Sub(&B, &A)
+	res[1].ScalarMultiplication(&diff, a)
+	return res
+}
+
+// Pad of a transfer from a shared point, bound to the sender, the receiver and the transfer index
+func otPad(p bn254.G1Affine, sender, receiver, index, length int) []uint32 {
+	var label [16]byte
+	copy(label[0:4], "GVOT")
+	binary.LittleEndian.PutUint32(label[4:8], uint32(sender))
+	binary.LittleEndian.PutUint32(label[8:12], uint32(receiver))
+	binary.LittleEndian.PutUint32(label[12:16], uint32(index))
+	point := p.Bytes()
+	seed := sha256.Sum256(append(label[:], point[:]...))
+
+	pad := make([]uint32, length)
+	csprng.NewUniformSamplerWithSeed[uint32](seed[:]).SampleSliceAssign(pad)
+	return pad
+}
@@ -0,0 +1,234 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package thfhe
+
+import (
+	"Governome/auxiliary"
+	"Governome/streamcipher/trivium"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/sp301415/tfhe-go/math/csprng"
+	"github.com/sp301415/tfhe-go/math/vec"
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Threshold re-encryption of results to the LWE key of a querier, for each ciphertext (b, a) each party computes its decryption share
+// d_k = <a, s_k> + e_k with smudging noise, like for the threshold decryption, and encrypts d_k under the public key of the querier
+// the sum of (b, 0) and the encryptions of all parties encrypts b + sum_k d_k = m + e + sum_k e_k under the key of the querier
+// the party computing on the results adds the re-encryption shares, and only the querier can decrypt the sum
+// no party ever encrypts its key share, so nothing given to the querier or the computing party depends on the key beyond d_k
+const ReEncryptionShare_Magic = "GVRS"
+
+// Re-encryption share of a party for a list of ciphertexts and a querier
+type ReEncryptionShare struct {
+	ID      int
+	Parties int
+	// sha256 digest of the ciphertexts, and of the public key of the querier
+	Digest  [sha256.Size]byte
+	Querier [sha256.Size]byte
+	// the decryption shares of the ciphertexts, encrypted under the public key of the querier
+	Values []tfhe.LWECiphertext[uint32]
+}
+
+// Digest of the public key of a querier
+func PublicKeyDigest(pk auxiliary.PublicKey_tfheb) [sha256.Size]byte {
+	return sha256.Sum256(trivium.MarshalPK(pk))
+}
+
+// Check the shape of the public key of a querier
+func checkPublicKey(pk auxiliary.PublicKey_tfheb) {
+	if len(pk.B) == 0 || len(pk.A) != len(pk.B) {
+		log.Fatalf("Invalid public key of the querier, %d rows of A and %d of B", len(pk.A), len(pk.B))
+	}
+	for i := range pk.A {
+		if len(pk.A[i]) != len(pk.B) {
+			log.Fatalf("Invalid public key of the querier, row %d of A has dimension %d", i, len(pk.A[i]))
+		}
+	}
+}
+
+// Encrypt the plaintext pt in the full domain with the public key of the querier, and write it to ct
+func encryptWithPublicKey(pt uint32, pk auxiliary.PublicKey_tfheb, ct tfhe.LWECiphertext[uint32], lweNoise, glweNoise csprng.GaussianSampler[uint32], u csprng.UniformSampler[uint64]) {
+	ct.Value[0] = pt + lweNoise.Sample()
+	glweNoise.SampleSliceAssign(ct.Value[1:])
+	var bits uint64
+	for i := range pk.B {
+		if i%64 == 0 {
+			bits = u.Sample()
+		}
+		if bits>>(i%64)&1 == 1 {
+			ct.Value[0] -= pk.B[i]
+			vec.AddAssign(ct.Value[1:], pk.A[i], ct.Value[1:])
+		}
+	}
+}
+
+// Compute the re-encryption share of the party of share for cts to the querier of pk
+func PartialReEncrypt(share KeyShare, pk auxiliary.PublicKey_tfheb, cts []tfhe.LWECiphertext[uint32]) ReEncryptionShare {
+	checkPublicKey(pk)
+	partial := PartialDecrypt(share, cts)
+	res := ReEncryptionShare{ID: share.ID, Parties: share.Parties, Digest: partial.Digest, Querier: PublicKeyDigest(pk), Values: make([]tfhe.LWECiphertext[uint32], len(cts))}
+
+	workers := runtime.NumCPU()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// samplers are not safe for concurrent use
+			lweNoise := csprng.NewGaussianSamplerTorus[uint32](share.Params.LWEStdDev() / math.Sqrt(float64(share.Parties)))
+			glweNoise := csprng.NewGaussianSamplerTorus[uint32](share.Params.GLWEStdDev())
+			u := csprng.NewUniformSampler[uint64]()
+			for i := w; i < len(cts); i += workers {
+				res.Values[i] = tfhe.NewLWECiphertextCustom[uint32](len(pk.B))
+				encryptWithPublicKey(partial.Values[i], pk, res.Values[i], lweNoise, glweNoise, u)
+			}
+		}(w)
+	}
+	wg.Wait()
+	return res
+}
+
+// Combine the re-encryption shares of cts to the querier of pk into ciphertexts under the key of the querier
+// fails unless there is exactly one share of every party for these ciphertexts and this querier
+func CombineReEncryption(pk auxiliary.PublicKey_tfheb, cts []tfhe.LWECiphertext[uint32], shares []ReEncryptionShare) ([]tfhe.LWECiphertext[uint32], error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no re-encryption shares")
+	}
+	parties := shares[0].Parties
+	digest, querier := CiphertextsDigest(cts), PublicKeyDigest(pk)
+	seen := make([]bool, parties+1)
+	for _, share := range shares {
+		if share.Parties != parties {
+			return nil, fmt.Errorf("re-encryption shares of %d and %d parties are mixed", parties, share.Parties)
+		}
+		if share.ID < 1 || share.ID > parties || seen[share.ID] {
+			return nil, fmt.Errorf("invalid or repeated re-encryption share of party %d", share.ID)
+		}
+		if share.Digest != digest || len(share.Values) != len(cts) {
+			return nil, fmt.Errorf("the re-encryption share of party %d is for other ciphertexts", share.ID)
+		}
+		if share.Querier != querier {
+			return nil, fmt.Errorf("the re-encryption share of party %d is for another querier", share.ID)
+		}
+		for _, ct := range share.Values {
+			if len(ct.Value) != len(pk.B)+1 {
+				return nil, fmt.Errorf("the re-encryption share of party %d has another dimension", share.ID)
+			}
+		}
+		seen[share.ID] = true
+	}
+	if len(shares) != parties {
+		return nil, fmt.Errorf("need the re-encryption shares of all %d parties, got %d", parties, len(shares))
+	}
+
+	res := make([]tfhe.LWECiphertext[uint32], len(cts))
+	for i, ct := range cts {
+		res[i] = tfhe.NewLWECiphertextCustom[uint32](len(pk.B))
+		res[i].Value[0] = ct.Value[0]
+		for _, share := range shares {
+			vec.AddAssign(res[i].Value, share.Values[i].Value, res[i].Value)
+		}
+	}
+	return res, nil
+}
+
+// Encode a re-encryption share to send it to the party computing on the results
+func (share ReEncryptionShare) MarshalBinary() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(ReEncryptionShare_Magic)
+	binary.Write(&buf, binary.LittleEndian, uint16(Storage_Version))
+	binary.Write(&buf, binary.LittleEndian, uint16(share.Parties))
+	binary.Write(&buf, binary.LittleEndian, uint16(share.ID))
+	buf.Write(share.Digest[:])
+	buf.Write(share.Querier[:])
+	dim := 0
+	if len(share.Values) > 0 {
+		dim = len(share.Values[0].Value) - 1
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(len(share.Values)))
+	binary.Write(&buf, binary.LittleEndian, uint32(dim))
+	for _, ct := range share.Values {
+		if len(ct.Value) != dim+1 {
+			log.Fatalf("can not write the re-encryption share, the ciphertexts have different dimensions")
+		}
+		buf.Write(appendUint32s(nil, ct.Value))
+	}
+	return buf.Bytes()
+}
+
+// Decode a re-encryption share
+func UnmarshalReEncryptionShare(data []byte) (ReEncryptionShare, error) {
+	var share ReEncryptionShare
+	head := len(ReEncryptionShare_Magic) + 6 + 2*sha256.Size + 8
+	if len(data) < head || string(data[:len(ReEncryptionShare_Magic)]) != ReEncryptionShare_Magic {
+		return share, errors.New("not a re-encryption share")
+	}
+	data = data[len(ReEncryptionShare_Magic):]
+	if version := binary.LittleEndian.Uint16(data); version != Storage_Version {
+		return share, fmt.Errorf("unsupported version %d", version)
+	}
+	share.Parties = int(binary.LittleEndian.Uint16(data[2:]))
+	share.ID = int(binary.LittleEndian.Uint16(data[4:]))
+	data = data[6:]
+	copy(share.Digest[:], data[:sha256.Size])
+	copy(share.Querier[:], data[sha256.Size:2*sha256.Size])
+	data = data[2*sha256.Size:]
+	count := uint64(binary.LittleEndian.Uint32(data))
+	size := 4 * (uint64(binary.LittleEndian.Uint32(data[4:])) + 1)
+	data = data[8:]
+	if uint64(len(data)) != count*size {
+		return share, errors.New("the re-encryption share is damaged")
+	}
+	share.Values = make([]tfhe.LWECiphertext[uint32], count)
+	for i := range share.Values {
+		share.Values[i] = tfhe.LWECiphertext[uint32]{Value: readUint32s(data[uint64(i)*size : uint64(i+1)*size])}
+	}
+	return share, nil
+}
+
+// Re-encrypt cts to the querier of pk with the sealed key shares of all parties kept on this machine, for demos where the parties are simulated
+func ReEncryptWithKeyShares(params tfhe.Parameters[uint32], pk auxiliary.PublicKey_tfheb, cts []tfhe.LWECiphertext[uint32]) []tfhe.LWECiphertext[uint32] {
+	first := ReadKeyShare(params, 1, KeySharePassphrase(1))
+	shares := []ReEncryptionShare{PartialReEncrypt(first, pk, cts)}
+	for id := 2; id <= first.Parties; id++ {
+		shares = append(shares, PartialReEncrypt(ReadKeyShare(params, id, KeySharePassphrase(id)), pk, cts))
+	}
+	res, err := CombineReEncryption(pk, cts, shares)
+	if err != nil {
+		log.Fatalf("can not re-encrypt, err is %+v", err)
+	}
+	return res
+}
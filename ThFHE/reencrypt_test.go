@@ -0,0 +1,81 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package thfhe
+
+import (
+	"Governome/auxiliary"
+	"math/rand"
+	"testing"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+func TestReEncrypt(t *testing.T) {
+	params := auxiliary.ParamsToyBoolean.Compile()
+	shares, keys := SimulateGenKeys(params, 3)
+	enc := combinedEncryptor(params, shares)
+	eval := tfhe.NewBinaryEvaluator(params, keys.EvaluationKey)
+
+	bits := make([]bool, 16)
+	cts := make([]tfhe.LWECiphertext[uint32], len(bits))
+	for i := range bits {
+		bits[i] = rand.Intn(2) == 1
+		cts[i] = eval.XOR(enc.EncryptLWEBool(bits[i]), enc.EncryptLWEBool(false))
+	}
+
+	// the public key of a querier can not be sampled with the noise of the toy parameters, the key of the querier is independent of ThFHE
+	querier := tfhe.NewBinaryEncryptor(tfhe.ParamsBinaryOriginal.Compile())
+	pk := auxiliary.GenLWEPublicKey_tfheb(querier)
+	var rshares []ReEncryptionShare
+	for _, share := range shares {
+		rshare, err := UnmarshalReEncryptionShare(PartialReEncrypt(share, pk, cts).MarshalBinary())
+		if err != nil {
+			t.Fatal(err)
+		}
+		rshares = append(rshares, rshare)
+	}
+	res, err := CombineReEncryption(pk, cts, rshares)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range bits {
+		if querier.DecryptLWEBool(res[i]) != bits[i] {
+			t.Fatalf("bit %d is not decrypted by the querier", i)
+		}
+	}
+
+	if _, err := CombineReEncryption(pk, cts, rshares[1:]); err == nil {
+		t.Errorf("re-encrypted without all shares")
+	}
+	if _, err := CombineReEncryption(pk, cts[1:], rshares); err == nil {
+		t.Errorf("re-encrypted other ciphertexts with the shares")
+	}
+	other := auxiliary.GenLWEPublicKey_tfheb(tfhe.NewBinaryEncryptor(tfhe.ParamsBinaryOriginal.Compile()))
+	if _, err := CombineReEncryption(other, cts, rshares); err == nil {
+		t.Errorf("re-encrypted to another querier with the shares")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package thfhe
+
+import (
+	"Governome/auxiliary"
+	"Governome/streamcipher/trivium"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Key share file of a party, all integers are little endian
+// header: magic, version, sha256 fingerprint of the TFHE parameters, uint16 number of parties, uint16 party ID, scrypt salt
+// body: the key share as uint32 values, sealed with the key derived from the passphrase of the party, the header is the associated data
+// the passphrase of party k is read from the environment variable GOVERNOME_SK_PASSPHRASE_k
+// Evaluation key file: magic, version, sha256 fingerprint of the TFHE parameters, the evaluation key as encoded by tfhe-go, sha256 checksum
+const (
+	KeyShare_Magic      = "GVTS"
+	EvaluationKey_Magic = "GVEK"
+	Storage_Version     = 1
+	keyShareFileMode    = 0600
+)
+
+// Environment variable of the pre-shared key of the socket transport
+const Transport_PSK_EnvVar = "GOVERNOME_THFHE_PSK"
+
+// Get the path of the key share of a party
+func KeySharePath(id int) string {
+	return auxiliary.ReadPath() + "/Key_Information/ThFHE_KeyShare_" + strconv.Itoa(id) + ".sealed"
+}
+
+// Get the path of the evaluation key
+func EvaluationKeyPath() string {
+	return auxiliary.ReadPath() + "/Key_Information/ThFHE_EvaluationKey.bin"
+}
+
+// Get the passphrase of the key share of a party from the environment
+func KeySharePassphrase(id int) string {
+	name := trivium.SK_Passphrase_EnvVar + "_" + strconv.Itoa(id)
+	passphrase := os.Getenv(name)
+	if passphrase == "" {
+		log.Fatalf("Please set the passphrase of the key share of party %d in %s", id, name)
+	}
+	return passphrase
+}
+
+// Get the pre-shared key authenticating the parties of the key generation to each other, hex in the environment
+func TransportPSK() []byte {
+	psk, err := hex.DecodeString(os.Getenv(Transport_PSK_EnvVar))
+	if err != nil || len(psk) < 16 {
+		log.Fatalf("Please set the pre-shared key of the parties, at least 16 bytes in hex, in %s", Transport_PSK_EnvVar)
+	}
+	return psk
+}
+
+// Get the session ID of a run of the key generation from a label the parties agree on, a fresh label for every run
+func SessionID(label string) []byte {
+	if label == "" {
+		log.Fatalf("Please give the label of the session")
+	}
+	id := sha256.Sum256([]byte("Governome ThFHE session\x00" + label))
+	return id[:]
+}
+
+// Header of a file, magic, version and the fingerprint of params
+func fileHeader(magic string, params tfhe.Parameters[uint32]) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	binary.Write(&buf, binary.LittleEndian, uint16(Storage_Version))
+	buf.Write(trivium.ParamsFingerprint(params))
+	return buf.Bytes()
+}
+
+// Check the header of a file against params, return the rest of the file
+func checkFileHeader(file []byte, magic string, params tfhe.Parameters[uint32]) ([]byte, error) {
+	header := fileHeader(magic, params)
+	if len(file) < len(header) || string(file[:len(magic)]) != magic {
+		return nil, errors.New("not a " + magic + " file")
+	}
+	if version := binary.LittleEndian.Uint16(file[len(magic):]); version != Storage_Version {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	if !bytes.Equal(file[:len(header)], header) {
+		return nil, errors.New("the key is generated under other TFHE parameters than the given ones")
+	}
+	return file[len(header):], nil
+}
+
+// Write a file through a temporary file, so a reader never sees a partial key
+func writeFileAtomic(path string, data []byte, perm os.FileMode) {
+	os.MkdirAll(auxiliary.ReadPath()+"/Key_Information/", os.ModePerm)
+	if err := os.WriteFile(path+".tmp", data, perm); err != nil {
+		log.Fatalf("can not write %s, err is %+v", path, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Fatalf("can not write %s, err is %+v", path, err)
+	}
+}
+
+// Save the key share of a party sealed with the passphrase
+func SaveKeyShare(share KeyShare, passphrase string) {
+	if passphrase == "" {
+		log.Fatalf("Please give a passphrase to seal the key share")
+	}
+	salt := auxiliary.RandomBytes(auxiliary.Seal_SaltSize)
+	header := fileHeader(KeyShare_Magic, share.Params)
+	header = binary.LittleEndian.AppendUint16(header, uint16(share.Parties))
+	header = binary.LittleEndian.AppendUint16(header, uint16(share.ID))
+	header = append(header, salt...)
+	sealed := auxiliary.Seal(auxiliary.DeriveSealKey(passphrase, salt), appendUint32s(nil, share.Key), header)
+	writeFileAtomic(KeySharePath(share.ID), append(header, sealed...), keyShareFileMode)
+}
+
+// Read the sealed key share of a party, generated under params
+func ReadKeyShare(params tfhe.Parameters[uint32], id int, passphrase string) KeyShare {
+	if passphrase == "" {
+		log.Fatalf("Please give the passphrase of the key share")
+	}
+	path := KeySharePath(id)
+	file, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	body, err := checkFileHeader(file, KeyShare_Magic, params)
+	if err == nil && len(body) < 4+auxiliary.Seal_SaltSize {
+		err = errors.New("the key share file is damaged")
+	}
+	if err != nil {
+		log.Fatalf("can not read %s, err is %+v", path, err)
+	}
+
+	share := KeyShare{ID: int(binary.LittleEndian.Uint16(body[2:4])), Parties: int(binary.LittleEndian.Uint16(body[0:2])), Params: params}
+	if share.ID != id {
+		log.Fatalf("can not read %s, it is the key share of party %d", path, share.ID)
+	}
+	salt := body[4 : 4+auxiliary.Seal_SaltSize]
+	header := file[:len(file)-len(body)+4+auxiliary.Seal_SaltSize]
+	key, err := auxiliary.Unseal(auxiliary.DeriveSealKey(passphrase, salt), body[4+auxiliary.Seal_SaltSize:], header)
+	if err != nil {
+		log.Fatalf("Wrong passphrase of the key share of party %d", id)
+	}
+	if len(key) != 4*params.LWELargeDimension() {
+		log.Fatalf("can not read %s, the key share does not match the given parameters", path)
+	}
+	share.Key = readUint32s(key)
+	return share
+}
+
+// Save the jointly generated evaluation key
+func SaveEvaluationKey(evk tfhe.EvaluationKey[uint32], params tfhe.Parameters[uint32]) {
+	buf := bytes.NewBuffer(fileHeader(EvaluationKey_Magic, params))
+	if _, err := evk.WriteTo(buf); err != nil {
+		log.Fatalf("can not write the evaluation key, err is %+v", err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:])
+	writeFileAtomic(EvaluationKeyPath(), buf.Bytes(), 0644)
+}
+
+// Read the jointly generated evaluation key, generated under params
+func ReadEvaluationKey(params tfhe.Parameters[uint32]) tfhe.EvaluationKey[uint32] {
+	path := EvaluationKeyPath()
+	file, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	if len(file) < sha256.Size {
+		log.Fatalf("can not read %s, the file is damaged", path)
+	}
+	data := file[:len(file)-sha256.Size]
+	sum := sha256.Sum256(data)
+	if !bytes.Equal(sum[:], file[len(data):]) {
+		log.Fatalf("can not read %s, checksum mismatch, the file is damaged", path)
+	}
+	body, err := checkFileHeader(data, EvaluationKey_Magic, params)
+	if err != nil {
+		log.Fatalf("can not read %s, err is %+v", path, err)
+	}
+
+	evk := tfhe.NewEvaluationKey(params)
+	if len(body) != evk.ByteSize() {
+		log.Fatalf("can not read %s, the evaluation key does not match the given parameters", path)
+	}
+	if _, err := evk.ReadFrom(bytes.NewReader(body)); err != nil {
+		log.Fatalf("can not read %s, err is %+v", path, err)
+	}
+	return evk
+}
@@ -0,0 +1,440 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package thfhe
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"Governome/auxiliary"
+)
+
+// Timeout of dialing a party that has not started listening yet, and of the handshake of a connection
+const (
+	Dial_Timeout      = 60 * time.Second
+	Handshake_Timeout = 10 * time.Second
+)
+
+// Limits of the socket transport, the largest message of the key generation is the bootstrap key round,
+// a party is at most one round ahead of the others, so a few pending rounds per sender are enough
+const (
+	Max_FrameSize   = 1 << 26
+	Max_PendingMsgs = 4
+	SessionID_Size  = 32
+	transportMagic  = "GVTT"
+)
+
+// Transport of the protocol messages, every message of a round is broadcast to all parties
+type Transport interface {
+	// Broadcast the message of this party in a round
+	Broadcast(round int, msg []byte) error
+	// Wait for the messages of all parties in a round, indexed by party ID - 1, including the own message
+	// fails if the messages can not be received any more, e.g. a peer sent a malformed frame
+	Gather(round int) ([][]byte, error)
+	// Release the connections
+	Close()
+}
+
+// Messages received by a party, by round and sender
+type mailbox struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	parties int
+	msgs    map[int][][]byte
+	err     error
+}
+
+func newMailbox(parties int) *mailbox {
+	m := &mailbox{parties: parties, msgs: make(map[int][][]byte)}
+	m.cond = sync.NewCond(&m.lock)
+	return m
+}
+
+// Keep the message of a sender in a round, a repeated message or too many pending rounds of a sender are refused
+func (m *mailbox) put(round, from int, msg []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.msgs[round] == nil {
+		pending := 0
+		for _, msgs := range m.msgs {
+			if msgs[from-1] != nil {
+				pending++
+			}
+		}
+		if pending >= Max_PendingMsgs {
+			return fmt.Errorf("party %d sent too many rounds ahead", from)
+		}
+		m.msgs[round] = make([][]byte, m.parties)
+	}
+	if m.msgs[round][from-1] != nil {
+		return fmt.Errorf("party %d sent round %d twice", from, round)
+	}
+	m.msgs[round][from-1] = msg
+	m.cond.Broadcast()
+	return nil
+}
+
+// Stop waiting for messages, all waiting and later Gather calls fail with err
+func (m *mailbox) fail(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.err == nil {
+		m.err = err
+	}
+	m.cond.Broadcast()
+}
+
+// Wait until the messages of all parties in a round are received, the round is then dropped
+func (m *mailbox) wait(round int) ([][]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for !m.complete(round) {
+		if m.err != nil {
+			return nil, m.err
+		}
+		m.cond.Wait()
+	}
+	res := m.msgs[round]
+	delete(m.msgs, round)
+	return res, nil
+}
+
+func (m *mailbox) complete(round int) bool {
+	if m.msgs[round] == nil {
+		return false
+	}
+	for _, msg := range m.msgs[round] {
+		if msg == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Transport among parties simulated in the same process
+type localTransport struct {
+	id    int
+	boxes []*mailbox
+}
+
+// Get the transports of parties simulated in the same process, the transport of party ID k is at k - 1
+func NewLocalTransports(parties int) []Transport {
+	boxes := make([]*mailbox, parties)
+	for i := 0; i < parties; i++ {
+		boxes[i] = newMailbox(parties)
+	}
+	res := make([]Transport, parties)
+	for i := 0; i < parties; i++ {
+		res[i] = &localTransport{id: i + 1, boxes: boxes}
+	}
+	return res
+}
+
+func (t *localTransport) Broadcast(round int, msg []byte) error {
+	for _, box := range t.boxes {
+		if err := box.put(round, t.id, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *localTransport) Gather(round int) ([][]byte, error) {
+	return t.boxes[t.id-1].wait(round)
+}
+
+func (t *localTransport) Close() {}
+
+// Transport among parties in separate processes over TCP sockets, authenticated by a pre-shared key of the parties
+// handshake: the dialing party sends the magic, the session ID, its own ID, the ID of the listening party and a nonce,
+// the listening party answers with its nonce and a tag, the dialing party with its tag, the tags are HMAC-SHA256 under
+// the connection key HMAC-SHA256(psk, magic | session ID | IDs | nonces), so both know the psk and agree on all of them
+// frame: the uint32 round, the uint64 sequence number and the uint64 length of the message, the message, then the tag of
+// all of them under the connection key, the sender is the party authenticated by the handshake, not a field of the frame
+type socketTransport struct {
+	id       int
+	addrs    []string
+	session  []byte
+	psk      []byte
+	box      *mailbox
+	listener net.Listener
+	lock     sync.Mutex
+	conns    []*peerConn
+}
+
+// Outgoing connection to a party
+type peerConn struct {
+	conn net.Conn
+	key  []byte
+	seq  uint64
+}
+
+// Listen on the address of party id and get its transport, addrs holds the address of party ID k at k - 1
+// session identifies this run of the protocol, all parties must use the same session and psk
+func NewSocketTransport(id int, addrs []string, session []byte, psk []byte) (Transport, error) {
+	if len(session) != SessionID_Size {
+		return nil, fmt.Errorf("the session ID must have %d bytes", SessionID_Size)
+	}
+	if len(psk) < 16 {
+		return nil, errors.New("the pre-shared key must have at least 16 bytes")
+	}
+	listener, err := net.Listen("tcp", addrs[id-1])
+	if err != nil {
+		return nil, fmt.Errorf("can not listen on %s, err is %w", addrs[id-1], err)
+	}
+	t := &socketTransport{id: id, addrs: addrs, session: session, psk: psk, box: newMailbox(len(addrs)), listener: listener, conns: make([]*peerConn, len(addrs))}
+	go t.accept()
+	return t, nil
+}
+
+// Key of a connection from party from to party to
+func (t *socketTransport) connKey(from, to int, nonces []byte) []byte {
+	mac := hmac.New(sha256.New, t.psk)
+	mac.Write([]byte(transportMagic))
+	mac.Write(t.session)
+	binary.Write(mac, binary.LittleEndian, uint32(from))
+	binary.Write(mac, binary.LittleEndian, uint32(to))
+	mac.Write(nonces)
+	return mac.Sum(nil)
+}
+
+// Tag of data under a key
+func transportTag(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// Accept the connections of the other parties and keep their messages
+func (t *socketTransport) accept() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.receive(conn)
+	}
+}
+
+// Authenticate an incoming connection and keep the messages received on it
+// a connection that fails the handshake is dropped, an authenticated party that sends a malformed frame stops the transport
+func (t *socketTransport) receive(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	from, key, err := t.acceptHandshake(conn, r)
+	if err != nil {
+		return
+	}
+	if err := t.readFrames(r, from, key); err != nil {
+		t.box.fail(fmt.Errorf("can not read the messages of party %d, err is %w", from, err))
+	}
+}
+
+// Listening side of the handshake, return the authenticated party and the connection key
+func (t *socketTransport) acceptHandshake(conn net.Conn, r *bufio.Reader) (int, []byte, error) {
+	conn.SetDeadline(time.Now().Add(Handshake_Timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := make([]byte, len(transportMagic)+SessionID_Size+8+32)
+	if _, err := io.ReadFull(r, hello); err != nil {
+		return 0, nil, err
+	}
+	if string(hello[:len(transportMagic)]) != transportMagic {
+		return 0, nil, errors.New("not a transport connection")
+	}
+	rest := hello[len(transportMagic):]
+	if !hmac.Equal(rest[:SessionID_Size], t.session) {
+		return 0, nil, errors.New("the connection is of another session")
+	}
+	from := int(binary.LittleEndian.Uint32(rest[SessionID_Size:]))
+	to := int(binary.LittleEndian.Uint32(rest[SessionID_Size+4:]))
+	if from < 1 || from > len(t.addrs) || from == t.id || to != t.id {
+		return 0, nil, fmt.Errorf("invalid parties %d to %d", from, to)
+	}
+
+	nonce := auxiliary.RandomBytes(32)
+	key := t.connKey(from, to, append(append([]byte{}, rest[SessionID_Size+8:]...), nonce...))
+	if _, err := conn.Write(append(nonce, transportTag(key, []byte("listener"))...)); err != nil {
+		return 0, nil, err
+	}
+	tag := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return 0, nil, err
+	}
+	if !hmac.Equal(tag, transportTag(key, []byte("dialer"))) {
+		return 0, nil, fmt.Errorf("party %d is not authenticated", from)
+	}
+	return from, key, nil
+}
+
+// Read the frames of an authenticated party until the connection is closed
+func (t *socketTransport) readFrames(r *bufio.Reader, from int, key []byte) error {
+	var header [20]byte
+	tag := make([]byte, sha256.Size)
+	for seq := uint64(0); ; seq++ {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		round := int(binary.LittleEndian.Uint32(header[0:4]))
+		if binary.LittleEndian.Uint64(header[4:12]) != seq {
+			return errors.New("a frame is out of sequence")
+		}
+		length := binary.LittleEndian.Uint64(header[12:20])
+		if length > Max_FrameSize {
+			return fmt.Errorf("a frame of %d bytes is larger than %d bytes", length, Max_FrameSize)
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, tag); err != nil {
+			return err
+		}
+		if !hmac.Equal(tag, transportTag(key, header[:], msg)) {
+			return errors.New("a frame is not authenticated")
+		}
+		if err := t.box.put(round, from, msg); err != nil {
+			return err
+		}
+	}
+}
+
+// Get the connection to a party, dialing it until it listens, then authenticating it
+func (t *socketTransport) conn(id int) (*peerConn, error) {
+	if t.conns[id-1] != nil {
+		return t.conns[id-1], nil
+	}
+	deadline := time.Now().Add(Dial_Timeout)
+	for {
+		conn, err := net.Dial("tcp", t.addrs[id-1])
+		if err == nil {
+			key, err := t.dialHandshake(conn, id)
+			if err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("can not authenticate party %d at %s, err is %w", id, t.addrs[id-1], err)
+			}
+			t.conns[id-1] = &peerConn{conn: conn, key: key}
+			return t.conns[id-1], nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("can not connect to party %d at %s, err is %w", id, t.addrs[id-1], err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// Dialing side of the handshake, return the connection key
+func (t *socketTransport) dialHandshake(conn net.Conn, to int) ([]byte, error) {
+	conn.SetDeadline(time.Now().Add(Handshake_Timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	nonce := auxiliary.RandomBytes(32)
+	var buf bytes.Buffer
+	buf.WriteString(transportMagic)
+	buf.Write(t.session)
+	binary.Write(&buf, binary.LittleEndian, uint32(t.id))
+	binary.Write(&buf, binary.LittleEndian, uint32(to))
+	buf.Write(nonce)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 32+sha256.Size)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	key := t.connKey(t.id, to, append(nonce, reply[:32]...))
+	if !hmac.Equal(reply[32:], transportTag(key, []byte("listener"))) {
+		return nil, errors.New("the party is not authenticated")
+	}
+	if _, err := conn.Write(transportTag(key, []byte("dialer"))); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (t *socketTransport) Broadcast(round int, msg []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(msg) > Max_FrameSize {
+		return fmt.Errorf("the message of %d bytes is larger than %d bytes", len(msg), Max_FrameSize)
+	}
+	if err := t.box.put(round, t.id, msg); err != nil {
+		return err
+	}
+
+	for id := 1; id <= len(t.addrs); id++ {
+		if id == t.id {
+			continue
+		}
+		pc, err := t.conn(id)
+		if err != nil {
+			return err
+		}
+		var header [20]byte
+		binary.LittleEndian.PutUint32(header[0:4], uint32(round))
+		binary.LittleEndian.PutUint64(header[4:12], pc.seq)
+		binary.LittleEndian.PutUint64(header[12:20], uint64(len(msg)))
+		pc.seq++
+		w := bufio.NewWriter(pc.conn)
+		w.Write(header[:])
+		w.Write(msg)
+		w.Write(transportTag(pc.key, header[:], msg))
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("can not send to party %d, err is %w", id, err)
+		}
+	}
+	return nil
+}
+
+func (t *socketTransport) Gather(round int) ([][]byte, error) {
+	return t.box.wait(round)
+}
+
+func (t *socketTransport) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.listener.Close()
+	for _, pc := range t.conns {
+		if pc != nil {
+			pc.conn.Close()
+		}
+	}
+}
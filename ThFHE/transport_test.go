@@ -0,0 +1,150 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package thfhe
+
+import (
+	"Governome/auxiliary"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Free local addresses for the parties
+func testAddrs(t *testing.T, parties int) []string {
+	addrs := make([]string, parties)
+	for k := range addrs {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs[k] = l.Addr().String()
+		l.Close()
+	}
+	return addrs
+}
+
+func newTestTransport(t *testing.T, id int, addrs []string, session, psk []byte) *socketTransport {
+	tr, err := NewSocketTransport(id, addrs, session, psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tr.Close)
+	return tr.(*socketTransport)
+}
+
+func TestSocketTransport(t *testing.T) {
+	addrs := testAddrs(t, 3)
+	session, psk := SessionID("test"), auxiliary.RandomBytes(32)
+	transports := make([]Transport, 3)
+	for id := 1; id <= 3; id++ {
+		transports[id-1] = newTestTransport(t, id, addrs, session, psk)
+	}
+
+	errs := make(chan error, 3)
+	for id := 1; id <= 3; id++ {
+		go func(id int) {
+			for round := 0; round < 3; round++ {
+				if err := transports[id-1].Broadcast(round, []byte{byte(id), byte(round)}); err != nil {
+					errs <- err
+					return
+				}
+				msgs, err := transports[id-1].Gather(round)
+				if err != nil {
+					errs <- err
+					return
+				}
+				for q, msg := range msgs {
+					if !bytes.Equal(msg, []byte{byte(q + 1), byte(round)}) {
+						t.Errorf("party %d got %v from party %d in round %d", id, msg, q+1, round)
+					}
+				}
+			}
+			errs <- nil
+		}(id)
+	}
+	for id := 1; id <= 3; id++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSocketTransportRejectsPeers(t *testing.T) {
+	session, psk := SessionID("test"), auxiliary.RandomBytes(32)
+
+	for name, other := range map[string]struct{ session, psk []byte }{
+		"pre-shared key": {session, auxiliary.RandomBytes(32)},
+		"session":        {SessionID("other"), psk},
+	} {
+		addrs := testAddrs(t, 2)
+		newTestTransport(t, 1, addrs, session, psk)
+		peer := newTestTransport(t, 2, addrs, other.session, other.psk)
+		if err := peer.Broadcast(0, []byte{1}); err == nil {
+			t.Errorf("a party with another %s is accepted", name)
+		}
+	}
+}
+
+func TestSocketTransportRejectsFrames(t *testing.T) {
+	session, psk := SessionID("test"), auxiliary.RandomBytes(32)
+	frames := map[string]func(key []byte) []byte{
+		"oversized": func(key []byte) []byte {
+			header := make([]byte, 20)
+			binary.LittleEndian.PutUint64(header[12:], Max_FrameSize+1)
+			return header
+		},
+		"forged": func(key []byte) []byte {
+			header := make([]byte, 20)
+			binary.LittleEndian.PutUint64(header[12:], 1)
+			return append(append(header, 1), transportTag(auxiliary.RandomBytes(32), header, []byte{1})...)
+		},
+		"out of sequence": func(key []byte) []byte {
+			header := make([]byte, 20)
+			binary.LittleEndian.PutUint64(header[4:], 1)
+			binary.LittleEndian.PutUint64(header[12:], 1)
+			return append(append(header, 1), transportTag(key, header, []byte{1})...)
+		},
+	}
+
+	for name, frame := range frames {
+		addrs := testAddrs(t, 2)
+		victim := newTestTransport(t, 1, addrs, session, psk)
+		peer := newTestTransport(t, 2, addrs, session, psk)
+		pc, err := peer.conn(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pc.conn.Write(frame(pc.key)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := victim.Gather(0); err == nil || !strings.Contains(err.Error(), "party 2") {
+			t.Errorf("a %s frame is accepted, err is %v", name, err)
+		}
+	}
+}
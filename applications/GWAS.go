@@ -36,7 +36,13 @@ import (
 	"strings"
 )
 
-const App_id_GWAS = auxiliary.Seg_num + 2
+var app_GWAS = RegisterApplication("GWAS", 2)
+
+// AppID of GWAS
+func App_id_GWAS() int {
+	CheckRegistry()
+	return app_GWAS
+}
 
 // Whether s in set
 func Match(s string, set []auxiliary.People) int {
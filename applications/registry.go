@@ -0,0 +1,226 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package applications
+
+import (
+	"Governome/auxiliary"
+	"bytes"
+	"encoding/csv"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry of applications, an application is registered once with a unique name and a unique index
+// its key domain is its tag, the MiMC hash of its name in the namespace of applications, which is hashed before the index
+// when the keys and IVs of the application are derived, segments hash no tag, so their keys never meet
+// the AppID auxiliary.App_Domain + index only tells an application apart from a segment ID where an ID is passed as an int
+// the registry is persisted in Applications.csv under the root, an index must never be given to another application
+const (
+	Max_App_Index = 1 << 20
+	appNamespace  = "Governome application\x00"
+)
+
+type Application struct {
+	Name  string
+	Index int
+	// MiMC hash of the name in the namespace of applications
+	Tag []byte
+}
+
+var (
+	registryLock sync.Mutex
+	registry     = make(map[int]Application)
+	// roots whose persisted registry is checked
+	persisted = make(map[string]bool)
+)
+
+// Tag of an application by its name
+func AppTag(name string) []byte {
+	tag, err := auxiliary.MimcHashChunks([]byte(appNamespace+name), auxiliary.Mimchashcurve)
+	if err != nil {
+		log.Fatalf("can not hash the name of application %q, err is %+v", name, err)
+	}
+	return tag
+}
+
+// Register an application and return its AppID, a registered name or index is fatal
+func RegisterApplication(name string, index int) int {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if name == "" || index < 1 || index > Max_App_Index {
+		log.Fatalf("Invalid application %q with index %d, the index must be from 1 to %d", name, index, Max_App_Index)
+	}
+	if old, ok := registry[index]; ok {
+		log.Fatalf("Index %d of application %q is registered by %q", index, name, old.Name)
+	}
+	for _, old := range registry {
+		if strings.EqualFold(old.Name, name) {
+			log.Fatalf("Application %q is already registered", name)
+		}
+	}
+	registry[index] = Application{Name: name, Index: index, Tag: AppTag(name)}
+	return auxiliary.App_Domain + index
+}
+
+// Whether id is the AppID of a registered application
+func IsAppID(id int) bool {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	_, ok := registry[id-auxiliary.App_Domain]
+	return ok
+}
+
+// Key domain of a segment or application ID, the tag of the application and its index, or nil and the segment ID itself
+func KeyDomain(id int) ([]byte, int) {
+	if id < auxiliary.App_Domain {
+		return nil, id
+	}
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	app, ok := registry[id-auxiliary.App_Domain]
+	if !ok {
+		log.Fatalf("Unknown AppID %d", id)
+	}
+	return append([]byte{}, app.Tag...), app.Index
+}
+
+// Get the AppID of a registered application by name, case insensitive
+func AppID(name string) int {
+	CheckRegistry()
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	for index, app := range registry {
+		if strings.EqualFold(app.Name, name) {
+			return auxiliary.App_Domain + index
+		}
+	}
+	log.Fatalf("Unknown application %q, registered ones are %v", name, registeredNames())
+	return 0
+}
+
+// All registered applications by index
+func RegisteredApplications() []Application {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	res := make([]Application, 0, len(registry))
+	for _, app := range registry {
+		res = append(res, app)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Index < res[j].Index })
+	return res
+}
+
+func registeredNames() []string {
+	names := make([]string, 0, len(registry))
+	for _, app := range registry {
+		names = append(names, app.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get the path of the persisted registry under the root folder
+func RegistryPath() string {
+	return auxiliary.ReadPath() + "/Applications.csv"
+}
+
+// Check the registered applications against the persisted registry of the dataset, and persist the new ones
+// an index persisted for another name, or a name persisted with another index or tag, is fatal
+// applications that are no longer registered stay in the file, so their indexes are never given again
+func CheckRegistry() {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	path := RegistryPath()
+	if persisted[path] {
+		return
+	}
+
+	var rows [][]string
+	if file, err := os.Open(path); err == nil {
+		rows, err = csv.NewReader(file).ReadAll()
+		file.Close()
+		if err != nil {
+			log.Fatalf("can not read, err is %+v", err)
+		}
+	}
+
+	known := make(map[int]bool)
+	for _, row := range rows {
+		if len(row) != 3 {
+			log.Fatalf("can not read, invalid row %v in %s", row, path)
+		}
+		index, err := strconv.Atoi(row[1])
+		tag, ok := big.NewInt(1).SetString(row[2], 10)
+		if err != nil || !ok {
+			log.Fatalf("can not read, invalid row %v in %s", row, path)
+		}
+		known[index] = true
+		for _, app := range registry {
+			if app.Index == index && app.Name != row[0] {
+				log.Fatalf("Index %d of application %q is persisted for %q in %s", index, app.Name, row[0], path)
+			}
+			if app.Name == row[0] && (app.Index != index || !bytes.Equal(auxiliary.PadBytes(tag.Bytes(), len(app.Tag)), app.Tag)) {
+				log.Fatalf("Application %q is persisted with index %d and another tag in %s", app.Name, index, path)
+			}
+		}
+	}
+
+	added := false
+	for _, app := range registry {
+		if !known[app.Index] {
+			rows = append(rows, []string{app.Name, strconv.Itoa(app.Index), big.NewInt(1).SetBytes(app.Tag).String()})
+			added = true
+		}
+	}
+	if added {
+		sort.SliceStable(rows, func(i, j int) bool {
+			a, _ := strconv.Atoi(rows[i][1])
+			b, _ := strconv.Atoi(rows[j][1])
+			return a < b
+		})
+		f, err := os.Create(path + ".tmp")
+		if err != nil {
+			log.Fatalf("can not write, err is %+v", err)
+		}
+		w := csv.NewWriter(f)
+		w.WriteAll(rows)
+		w.Flush()
+		f.Close()
+		if err := w.Error(); err != nil {
+			log.Fatalf("can not write, err is %+v", err)
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			log.Fatalf("can not write, err is %+v", err)
+		}
+	}
+	persisted[path] = true
+}
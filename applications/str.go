@@ -51,7 +51,13 @@ type CODIS struct {
 var STRMarkers = [13]string{"D3S1358", "vWA", "FGA", "D8S1179", "D21S11", "D18S51",
 	"D5S818", "D13S317", "D7S820", "D16S539", "THO1", "TPOX", "CSF1PO"}
 
-const App_id_SearchPerson = auxiliary.Seg_num + 1
+var app_SearchPerson = RegisterApplication("SearchPerson", 1)
+
+// AppID of searching a person
+func App_id_SearchPerson() int {
+	CheckRegistry()
+	return app_SearchPerson
+}
 
 // Show the string representations of the CODIS
 func (cod *CODIS) Decode2String() []string {
@@ -102,7 +108,7 @@ func GenAndSaveCODISData() {
 // Read the CODIS Data
 func ReadCODISData() []CODIS {
 	dicpath := auxiliary.ReadPath()
-	Indivs := auxiliary.ReadIndividuals()
+	Indivs := auxiliary.ReadAllIndividuals()
 
 	path, _ := filepath.Abs(dicpath + "/CODIS_Data/Random_CODIS_Data.csv")
 
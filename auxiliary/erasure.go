@@ -0,0 +1,224 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auxiliary
+
+import (
+	"crypto/ed25519"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+)
+
+// Receipt of an erased individual, Receipt is the MiMC hash of the other fields and Signature signs the Receipt by the signer
+// Keyhashes are those of all key holders in order, the data hash is the Merkle root of the erased segments
+// (0 if there were none), so the receipt can be checked against the datahash on chain, it is DataHashHosted if the
+// dataset uses the key modes of precomputed mode, DataHashDefault otherwise, the other one is 0
+type ErasureReceipt struct {
+	Name            string
+	ID              int
+	Time            int64
+	Keyhashes       []*big.Int
+	DataHashDefault *big.Int
+	DataHashHosted  *big.Int
+	Receipt         *big.Int
+	Signature       []byte
+}
+
+// Signature domain of the erasure receipts
+const erasureDomain = "erasure receipt"
+
+// Get the path of the erasure registry
+func ErasurePath() string {
+	return ReadPath() + "/Individuals/Erased.csv"
+}
+
+// Compute the receipt hash of an erasure
+func (e *ErasureReceipt) Hash() *big.Int {
+	fields := []*big.Int{new(big.Int).SetBytes([]byte(e.Name)), big.NewInt(int64(e.ID)), big.NewInt(e.Time)}
+	fields = append(fields, e.Keyhashes...)
+	fields = append(fields, e.DataHashDefault, e.DataHashHosted)
+	hashval, err := MimcHashBigValue(fields, Curve, Mimchashcurve)
+	if err != nil {
+		log.Fatalf("can not hash the receipt, err is %+v", err)
+	}
+	return new(big.Int).SetBytes(hashval)
+}
+
+// Compute the receipt hash and sign it
+func (e *ErasureReceipt) Sign() {
+	e.Receipt = e.Hash()
+	e.Signature = Sign(erasureDomain, e.Receipt.Bytes())
+}
+
+// Whether the receipt hash matches its fields and is signed by the signer
+func (e *ErasureReceipt) Verify() bool {
+	return e.Receipt != nil && e.Hash().Cmp(e.Receipt) == 0 && VerifySignature(erasureDomain, e.Receipt.Bytes(), e.Signature)
+}
+
+// Append an erasure receipt to the registry, a row is the name, ID, time, key hashes, data hashes, receipt and signature
+func SaveErasure(e ErasureReceipt) {
+	f, err := os.OpenFile(ErasurePath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write(e.row())
+	w.Flush()
+	f.Close()
+}
+
+// Row of a receipt in the registry
+func (e *ErasureReceipt) row() []string {
+	row := []string{e.Name, strconv.Itoa(e.ID), strconv.FormatInt(e.Time, 10)}
+	for _, keyhash := range e.Keyhashes {
+		row = append(row, keyhash.String())
+	}
+	row = append(row, e.DataHashDefault.String(), e.DataHashHosted.String(), e.Receipt.String())
+	if e.Signature != nil {
+		row = append(row, hex.EncodeToString(e.Signature))
+	}
+	return row
+}
+
+// Parse a row of the registry, the receipts written before they were signed have no signature
+func parseErasure(row []string) (ErasureReceipt, error) {
+	var e ErasureReceipt
+	e.Name = row[0]
+	if len(row[len(row)-1]) == 2*ed25519.SignatureSize {
+		sig, err := hex.DecodeString(row[len(row)-1])
+		if err != nil {
+			return e, fmt.Errorf("erasure receipt of %s is damaged", e.Name)
+		}
+		e.Signature = sig
+		row = row[:len(row)-1]
+	}
+	if len(row) < 7 {
+		return e, fmt.Errorf("erasure receipt of %s is damaged", e.Name)
+	}
+	e.ID, _ = strconv.Atoi(row[1])
+	e.Time, _ = strconv.ParseInt(row[2], 10, 64)
+	vals := make([]*big.Int, len(row)-3)
+	for i := 0; i < len(vals); i++ {
+		val, ok := new(big.Int).SetString(row[3+i], 10)
+		if !ok {
+			return e, fmt.Errorf("erasure receipt of %s is damaged", e.Name)
+		}
+		vals[i] = val
+	}
+	n := len(vals) - 3
+	e.Keyhashes = vals[:n]
+	e.DataHashDefault, e.DataHashHosted, e.Receipt = vals[n], vals[n+1], vals[n+2]
+	return e, nil
+}
+
+// Read all rows of the registry
+func readErasureRows() [][]string {
+	file, err := os.Open(ErasurePath())
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	return rows
+}
+
+// Read the erasure registry, map the name to the receipt, a receipt that fails verification is fatal
+// so a receipt can not be forged to hide an individual from the queries
+func ReadErasures() map[string]ErasureReceipt {
+	res := make(map[string]ErasureReceipt)
+	for _, row := range readErasureRows() {
+		e, err := parseErasure(row)
+		if err != nil {
+			log.Fatalf("can not read, err is %+v", err)
+		}
+		if e.Signature == nil {
+			log.Fatalf("Erasure receipt of %s is not signed, sign the registry with -convert", e.Name)
+		}
+		if !e.Verify() {
+			log.Fatalf("Erasure receipt of %s is invalid", e.Name)
+		}
+		res[e.Name] = e
+	}
+	return res
+}
+
+// Sign the receipts written before the receipts were signed, their receipt hash must match, the registry is replaced atomically
+func SignLegacyErasures() {
+	rows := readErasureRows()
+	signed := 0
+	for i, row := range rows {
+		e, err := parseErasure(row)
+		if err != nil {
+			log.Fatalf("can not read, err is %+v", err)
+		}
+		if e.Signature != nil {
+			continue
+		}
+		if e.Hash().Cmp(e.Receipt) != 0 {
+			log.Fatalf("Erasure receipt of %s is invalid", e.Name)
+		}
+		e.Sign()
+		rows[i] = e.row()
+		signed++
+	}
+	if signed == 0 {
+		return
+	}
+	tmp := ErasurePath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	w := csv.NewWriter(f)
+	w.WriteAll(rows)
+	if err := f.Close(); err != nil || w.Error() != nil {
+		log.Fatalf("can not write, err is %+v %+v", err, w.Error())
+	}
+	if err := os.Rename(tmp, ErasurePath()); err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	fmt.Println("Signed " + strconv.Itoa(signed) + " erasure receipts")
+}
+
+// Make sure that none of the individuals has been erased
+func AssertNotErased(Indiv []People) {
+	erased := ReadErasures()
+	for _, p := range Indiv {
+		if _, ok := erased[p.Name]; ok {
+			log.Fatalf("%s has been erased and can not be queried", p.Name)
+		}
+	}
+}
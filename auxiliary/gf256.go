@@ -0,0 +1,127 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auxiliary
+
+import "log"
+
+// Arithmetic in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1, and Shamir secret sharing over it byte-wise
+// the shares of key holder j are evaluated at x = j, so at most 255 key holders
+
+// Multiply in GF(2^8)
+func GF256_Mul(a, b byte) byte {
+	var res byte
+	for b > 0 {
+		if b&1 == 1 {
+			res ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return res
+}
+
+// Inverse in GF(2^8), a^254
+func GF256_Inv(a byte) byte {
+	if a == 0 {
+		log.Fatalf("0 has no inverse in GF(2^8)")
+	}
+	res := byte(1)
+	for i := 0; i < 254; i++ {
+		res = GF256_Mul(res, a)
+	}
+	return res
+}
+
+// The bit matrix of multiplying by c, bit r of c*a is the XOR of bit k of a over M[r][k] == 1
+// multiplying an encrypted byte by a public constant therefore only needs XORs
+func GF256_BitMatrix(c byte) (M [8][8]int) {
+	for k := 0; k < 8; k++ {
+		col := GF256_Mul(c, 1<<k)
+		for r := 0; r < 8; r++ {
+			M[r][k] = int(col>>r) & 1
+		}
+	}
+	return
+}
+
+// The sets of t-1 of the x = 1..n in lexicographic order, pseudo-random secret sharing deals a random value for each of them
+// to the x outside it, so any t of the x together know all values and any t-1 miss the value of their own set
+func ShamirUnqualifiedSets(t, n int) [][]int {
+	if t < 1 || t > n || n > 255 {
+		log.Fatalf("Invalid threshold %d of %d", t, n)
+	}
+	var res [][]int
+	set := make([]int, 0, t-1)
+	var walk func(x int)
+	walk = func(x int) {
+		if len(set) == t-1 {
+			res = append(res, append([]int{}, set...))
+			return
+		}
+		for ; x <= n; x++ {
+			set = append(set, x)
+			walk(x + 1)
+			set = set[:len(set)-1]
+		}
+	}
+	walk(1)
+	return res
+}
+
+// The polynomial of degree len(set) that is 1 at 0 and 0 at the x of the set, evaluated at x,
+// a value times it is a Shamir sharing of the value that the x of the set can not take part in
+func ShamirSetPoly(set []int, x int) byte {
+	res := byte(1)
+	for _, m := range set {
+		res = GF256_Mul(res, GF256_Mul(byte(x)^byte(m), GF256_Inv(byte(m))))
+	}
+	return res
+}
+
+// Lagrange coefficients at 0 for the shares at xs, the secret is the XOR of coefficient times share
+func ShamirLagrange(xs []int) []byte {
+	res := make([]byte, len(xs))
+	for j := 0; j < len(xs); j++ {
+		num, den := byte(1), byte(1)
+		for m := 0; m < len(xs); m++ {
+			if m == j {
+				continue
+			}
+			if xs[m] == xs[j] {
+				log.Fatalf("Duplicated share %d", xs[j])
+			}
+			num = GF256_Mul(num, byte(xs[m]))
+			den = GF256_Mul(den, byte(xs[m])^byte(xs[j]))
+		}
+		res[j] = GF256_Mul(num, GF256_Inv(den))
+	}
+	return res
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auxiliary
+
+import (
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/hash"
+)
+
+// Bytes of data packed into a single hash block, one byte less than the block so the value is always in fr
+const Mimc_ChunkSize = 31
+
+// Split data into 31-byte chunks, each left padded to a full hash block
+func FieldChunks(data []byte, MimcHashCurve hash.Hash) []byte {
+	res := make([]byte, 0, (len(data)/Mimc_ChunkSize+1)*MimcHashCurve.Size())
+	for i := 0; i < len(data); i += Mimc_ChunkSize {
+		end := i + Mimc_ChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		res = append(res, PadBytes(data[i:end], MimcHashCurve.Size())...)
+	}
+	return res
+}
+
+// MimcHash of arbitrary bytes, the bytes are split by FieldChunks so they never exceed fr
+func MimcHashChunks(data []byte, MimcHashCurve hash.Hash) ([]byte, error) {
+	return MimcHashRaw(FieldChunks(data, MimcHashCurve), MimcHashCurve)
+}
+
+// Leaf of a Merkle tree: mimc(0, index, length, chunks of data)
+func MerkleLeaf(index int, data []byte, MimcHashCurve hash.Hash) []byte {
+	size := MimcHashCurve.Size()
+	input := make([]byte, size)
+	input = append(input, PadBytes(big.NewInt(int64(index)).Bytes(), size)...)
+	input = append(input, PadBytes(big.NewInt(int64(len(data))).Bytes(), size)...)
+	input = append(input, FieldChunks(data, MimcHashCurve)...)
+	res, _ := MimcHashRaw(input, MimcHashCurve)
+	return res
+}
+
+// Inner node of a Merkle tree: mimc(1, left, right)
+func MerkleNode(left, right []byte, MimcHashCurve hash.Hash) []byte {
+	size := MimcHashCurve.Size()
+	input := PadBytes([]byte{1}, size)
+	input = append(input, PadBytes(left, size)...)
+	input = append(input, PadBytes(right, size)...)
+	res, _ := MimcHashRaw(input, MimcHashCurve)
+	return res
+}
+
+// Number of nodes in each level of a Merkle tree with n leaves, from the leaves to the root
+// the last node of a level with odd size is promoted to the next level unchanged
+func MerkleLevelSizes(n int) []int {
+	sizes := []int{n}
+	for n > 1 {
+		n = (n + 1) / 2
+		sizes = append(sizes, n)
+	}
+	return sizes
+}
+
+// Build all levels of a Merkle tree from the leaves, the last level holds the root
+func BuildMerkleTree(leaves [][]byte, MimcHashCurve hash.Hash) [][][]byte {
+	levels := [][][]byte{leaves}
+	for cur := leaves; len(cur) > 1; {
+		next := make([][]byte, (len(cur)+1)/2)
+		for i := 0; i < len(next); i++ {
+			if 2*i+1 < len(cur) {
+				next[i] = MerkleNode(cur[2*i], cur[2*i+1], MimcHashCurve)
+			} else {
+				next[i] = cur[2*i]
+			}
+		}
+		levels = append(levels, next)
+		cur = next
+	}
+	return levels
+}
+
+// Replace a leaf of a Merkle tree built by BuildMerkleTree, only the nodes on its path are computed again
+func UpdateMerkleLeaf(levels [][][]byte, index int, leaf []byte, MimcHashCurve hash.Hash) {
+	levels[0][index] = leaf
+	for level := 1; level < len(levels); level++ {
+		index /= 2
+		prev := levels[level-1]
+		if 2*index+1 < len(prev) {
+			levels[level][index] = MerkleNode(prev[2*index], prev[2*index+1], MimcHashCurve)
+		} else {
+			levels[level][index] = prev[2*index]
+		}
+	}
+}
+
+// Compute the root from a leaf and the siblings on its path, sibling(level, i) returns the i-th node of the level
+func MerkleRootFromPath(leaf []byte, index int, n int, sibling func(level, i int) ([]byte, error), MimcHashCurve hash.Hash) ([]byte, error) {
+	node := leaf
+	sizes := MerkleLevelSizes(n)
+	for level := 0; level < len(sizes)-1; level++ {
+		if index == sizes[level]-1 && sizes[level]%2 == 1 {
+			index /= 2
+			continue
+		}
+		s, err := sibling(level, index^1)
+		if err != nil {
+			return nil, err
+		}
+		if index%2 == 0 {
+			node = MerkleNode(node, s, MimcHashCurve)
+		} else {
+			node = MerkleNode(s, node, MimcHashCurve)
+		}
+		index /= 2
+	}
+	return node, nil
+}
+
+// Whether two hash values are equal as integers, empty values are never equal
+func HashEqual(a, b []byte) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	return new(big.Int).SetBytes(a).Cmp(new(big.Int).SetBytes(b)) == 0
+}
@@ -27,11 +27,475 @@
 package auxiliary
 
 import (
+	"encoding/csv"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
 	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark-crypto/hash"
 )
 
 const Curve = ecc.BN254
 const Mimchashcurve = hash.MIMC_BN254
-const Seg_num = 96000
-const Minimal_Blocksize = 20
+
+// Segmentation parameters of a dataset, Seg_num segments per individual, each padded to at least Minimal_Blocksize variants
+type SegParams struct {
+	Seg_num           int
+	Minimal_Blocksize int
+}
+
+// Parameters of the whole-genome dataset, used when a dataset has no recorded parameters
+var Default_SegParams = SegParams{Seg_num: 96000, Minimal_Blocksize: 20}
+
+// Segment IDs of every dataset are below App_Domain, and the IDs of applications are App_Domain + their registered index
+// the keys of an application are derived from its tag and index, see applications/registry.go, not from its ID
+const App_Domain = 1 << 40
+
+// Get the path of the segmentation parameters of the dataset under the root folder
+func SegParamsPath() string {
+	return ReadPath() + "/Segments_Enc_Data/SegParams.csv"
+}
+
+// Record the segmentation parameters of the dataset, a dataset that is already recorded with other parameters is fatal
+func SaveSegParams(params SegParams) {
+	if params.Seg_num <= 1 || params.Seg_num > App_Domain || params.Minimal_Blocksize < 1 {
+		log.Fatalf("Invalid segmentation parameters %+v", params)
+	}
+	if _, err := os.Stat(SegParamsPath()); err == nil {
+		if old := ReadSegParams(); old != params {
+			log.Fatalf("The dataset is recorded with %+v, encrypt the data with %+v under another root folder", old, params)
+		}
+		return
+	}
+
+	os.MkdirAll(ReadPath()+"/Segments_Enc_Data", os.ModePerm)
+	f, err := os.Create(SegParamsPath())
+	if err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"Seg_num", strconv.Itoa(params.Seg_num)})
+	w.Write([]string{"Minimal_Blocksize", strconv.Itoa(params.Minimal_Blocksize)})
+	w.Flush()
+	f.Close()
+}
+
+// Read the segmentation parameters of the dataset, Default_SegParams if they are not recorded
+func ReadSegParams() SegParams {
+	params := Default_SegParams
+	file, err := os.Open(SegParamsPath())
+	if err != nil {
+		return params
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	for {
+		row, err := r.Read()
+		if err != nil && err != io.EOF {
+			log.Fatalf("can not read, err is %+v", err)
+		}
+		if err == io.EOF {
+			break
+		}
+		val, err := strconv.Atoi(row[1])
+		if err != nil {
+			log.Fatalf("can not read, err is %+v", err)
+		}
+		switch row[0] {
+		case "Seg_num":
+			params.Seg_num = val
+		case "Minimal_Blocksize":
+			params.Minimal_Blocksize = val
+		}
+	}
+	return params
+}
+
+// Key sharing of a dataset, the Trivium key of an individual is the XOR of the key shares of Holders key holders,
+// or with Threshold > 0, any Threshold of the Holders key holders reconstruct the key of a segment from Shamir shares
+type KeySharing struct {
+	Holders   int
+	Threshold int
+}
+
+// Two key holders, the data owner and the hospital, both needed
+var Default_KeySharing = KeySharing{Holders: 2, Threshold: 0}
+
+// Whether the key is reconstructed from Shamir shares
+func (s KeySharing) IsThreshold() bool {
+	return s.Threshold > 0
+}
+
+// Number of key holders needed to recover the key
+func (s KeySharing) Required() int {
+	if s.IsThreshold() {
+		return s.Threshold
+	}
+	return s.Holders
+}
+
+// Most values of pseudo-random secret sharing a key holder keeps in threshold mode, each is a MiMC block of its keyinfo
+// and its key shares cost a hash per byte and value, see HolderValues
+const Max_HolderValues = 20
+
+// Number of values of pseudo-random secret sharing of a key holder in threshold mode, one for each set of Threshold-1
+// other key holders, see ShamirUnqualifiedSets
+func (s KeySharing) HolderValues() int {
+	res := 1
+	for i := 1; i < s.Threshold; i++ {
+		res = res * (s.Holders - i) / i
+	}
+	return res
+}
+
+// Get the path of the key sharing of the dataset under the root folder
+func KeySharingPath() string {
+	return ReadPath() + "/Keystore/Sharing.csv"
+}
+
+// Record the key sharing of the dataset, a dataset that is already recorded with another sharing is fatal
+func SaveKeySharing(sharing KeySharing) {
+	if sharing.Holders < 2 || sharing.Holders > 255 || sharing.Threshold < 0 || sharing.Threshold > sharing.Holders {
+		log.Fatalf("Invalid key sharing %+v", sharing)
+	}
+	if sharing.IsThreshold() && sharing.HolderValues() > Max_HolderValues {
+		log.Fatalf("With %+v a key holder keeps %d values, at most %d are supported", sharing, sharing.HolderValues(), Max_HolderValues)
+	}
+	if _, err := os.Stat(KeySharingPath()); err == nil {
+		if old := ReadKeySharing(); old != sharing {
+			log.Fatalf("The dataset is recorded with %+v, encrypt the data with %+v under another root folder", old, sharing)
+		}
+		return
+	}
+
+	os.MkdirAll(ReadPath()+"/Keystore", 0700)
+	f, err := os.Create(KeySharingPath())
+	if err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"Holders", strconv.Itoa(sharing.Holders)})
+	w.Write([]string{"Threshold", strconv.Itoa(sharing.Threshold)})
+	w.Flush()
+	f.Close()
+}
+
+// Read the key sharing of the dataset, Default_KeySharing if it is not recorded
+func ReadKeySharing() KeySharing {
+	sharing := Default_KeySharing
+	file, err := os.Open(KeySharingPath())
+	if err != nil {
+		return sharing
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	for {
+		row, err := r.Read()
+		if err != nil && err != io.EOF {
+			log.Fatalf("can not read, err is %+v", err)
+		}
+		if err == io.EOF {
+			break
+		}
+		val, err := strconv.Atoi(row[1])
+		if err != nil {
+			log.Fatalf("can not read, err is %+v", err)
+		}
+		switch row[0] {
+		case "Holders":
+			sharing.Holders = val
+		case "Threshold":
+			sharing.Threshold = val
+		}
+	}
+	return sharing
+}
+
+// Key mode of a key holder in n-of-n sharing, how its key share is derived from its keyinfo
+// a segment share is derived per segment (or application) ID, a hosted share once for all segments,
+// the Trivium key of a segment is the XOR of the shares of all key holders, so any mix of modes gives a per-segment key
+// as long as one key holder derives per segment, the modes are not used in threshold mode
+type KeyMode int
+
+const (
+	KeyMode_Segment KeyMode = iota // GenSegmentKey, proven by SegCircuit
+	KeyMode_Hosted                 // GenKeyHostedMode, proven by HostedCircuit
+)
+
+// Name of the key mode in the dataset metadata and on the command line
+func (m KeyMode) String() string {
+	switch m {
+	case KeyMode_Segment:
+		return "segment"
+	case KeyMode_Hosted:
+		return "hosted"
+	}
+	return "KeyMode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// Parse the name of a key mode
+func ParseKeyMode(s string) (KeyMode, bool) {
+	for _, m := range []KeyMode{KeyMode_Segment, KeyMode_Hosted} {
+		if strings.EqualFold(strings.TrimSpace(s), m.String()) {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+// Key modes of the key holders of a dataset, KeyModes[k-1] is that of key holder k
+type KeyModes []KeyMode
+
+// Comma separated names of the key modes, e.g. "segment,hosted"
+func (ms KeyModes) String() string {
+	names := make([]string, len(ms))
+	for i, m := range ms {
+		names[i] = m.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// Whether two datasets use the same key modes
+func (ms KeyModes) Equal(other KeyModes) bool {
+	if len(ms) != len(other) {
+		return false
+	}
+	for i := range ms {
+		if ms[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether a key holder derives its share per segment, otherwise all segments would share a key
+func (ms KeyModes) HasSegment() bool {
+	for _, m := range ms {
+		if m == KeyMode_Segment {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse comma separated key mode names, fatal on an unknown name or if every key holder is hosted
+func ParseKeyModes(s string) KeyModes {
+	var res KeyModes
+	for _, name := range strings.Split(s, ",") {
+		m, ok := ParseKeyMode(name)
+		if !ok {
+			log.Fatalf("Unknown key mode %q, use segment or hosted", name)
+		}
+		res = append(res, m)
+	}
+	if !res.HasSegment() {
+		log.Fatalf("Key modes %s give every segment the same key, at least one key holder must use segment", res)
+	}
+	return res
+}
+
+// The key modes of the original key schedule: key holder 1, the data owner, derives per segment and the others once for
+// all segments, with precomputed the other way around, so the segment keys can be precomputed by the other key holders
+func DefaultKeyModes(holders int, precomputed bool) KeyModes {
+	res := make(KeyModes, holders)
+	for k := 0; k < holders; k++ {
+		if (k == 0) == precomputed {
+			res[k] = KeyMode_Hosted
+		}
+	}
+	return res
+}
+
+// Get the path of the key modes of the dataset under the root folder
+func KeyModesPath() string {
+	return ReadPath() + "/Keystore/KeyModes.csv"
+}
+
+// Record the key modes of the dataset, one per key holder of the key sharing,
+// a dataset that is already recorded with other key modes is fatal
+func SaveKeyModes(modes KeyModes) {
+	if holders := ReadKeySharing().Holders; len(modes) != holders {
+		log.Fatalf("%d key modes are given for %d key holders", len(modes), holders)
+	}
+	if !modes.HasSegment() {
+		log.Fatalf("Key modes %s give every segment the same key, at least one key holder must use segment", modes)
+	}
+	if _, err := os.Stat(KeyModesPath()); err == nil {
+		if old := ReadKeyModes(); !old.Equal(modes) {
+			log.Fatalf("The dataset is recorded with key modes %s, encrypt the data with %s under another root folder", old, modes)
+		}
+		return
+	}
+
+	os.MkdirAll(ReadPath()+"/Keystore", 0700)
+	f, err := os.Create(KeyModesPath())
+	if err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	w := csv.NewWriter(f)
+	for k, m := range modes {
+		w.Write([]string{"Keyholder_" + strconv.Itoa(k+1), m.String()})
+	}
+	w.Flush()
+	f.Close()
+}
+
+// Read the key modes of the dataset, DefaultKeyModes of the key sharing if they are not recorded
+func ReadKeyModes() KeyModes {
+	holders := ReadKeySharing().Holders
+	file, err := os.Open(KeyModesPath())
+	if err != nil {
+		return DefaultKeyModes(holders, false)
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	if len(rows) != holders {
+		log.Fatalf("%d key modes are recorded for %d key holders", len(rows), holders)
+	}
+	res := make(KeyModes, holders)
+	for i, row := range rows {
+		m, ok := ParseKeyMode(row[1])
+		if !ok || row[0] != "Keyholder_"+strconv.Itoa(i+1) {
+			log.Fatalf("Key modes of the dataset are damaged at %v", row)
+		}
+		res[i] = m
+	}
+	if !res.HasSegment() {
+		log.Fatalf("Key modes of the dataset %s give every segment the same key, at least one key holder must use segment", res)
+	}
+	return res
+}
+
+// Stream cipher of a dataset, Trivium with an 80-bit key and IV, Kreyvium with a 128-bit key and IV for 128-bit
+// security, or the filter permutator FiLIP with a large key and no warm-up, the key shares, SegKeys and proofs of the
+// key holders have as many bits as the key
+type Cipher int
+
+const (
+	Cipher_Trivium Cipher = iota
+	Cipher_Kreyvium
+	Cipher_FiLIP
+)
+
+// Key bits of the FiLIP instance of the datasets, FiLIP-1216, see trivium.FiLIP_Dataset
+var FiLIP_Key_Bits = 1 << 14
+
+// All ciphers, in the order of the benchmarks
+var Ciphers = []Cipher{Cipher_Trivium, Cipher_Kreyvium, Cipher_FiLIP}
+
+// Trivium, the cipher of the datasets recorded before the cipher could be chosen
+var Default_Cipher = Cipher_Trivium
+
+// Name of the cipher in the dataset metadata and on the command line
+func (c Cipher) String() string {
+	switch c {
+	case Cipher_Trivium:
+		return "trivium"
+	case Cipher_Kreyvium:
+		return "kreyvium"
+	case Cipher_FiLIP:
+		return "filip"
+	}
+	return "Cipher(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Parse the name of a cipher
+func ParseCipher(s string) (Cipher, bool) {
+	for _, c := range Ciphers {
+		if strings.EqualFold(strings.TrimSpace(s), c.String()) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// Number of bits of a key of the cipher
+func (c Cipher) KeyBits() int {
+	switch c {
+	case Cipher_Kreyvium:
+		return 128
+	case Cipher_FiLIP:
+		return FiLIP_Key_Bits
+	}
+	return 80
+}
+
+// Number of bits of an IV of the cipher, the IV of FiLIP seeds its PRNG
+func (c Cipher) IVBits() int {
+	switch c {
+	case Cipher_Kreyvium, Cipher_FiLIP:
+		return 128
+	}
+	return 80
+}
+
+// Bits of security claimed by the designers of the cipher
+func (c Cipher) SecurityBits() int {
+	switch c {
+	case Cipher_Kreyvium, Cipher_FiLIP:
+		return 128
+	}
+	return 80
+}
+
+// Get the path of the cipher of the dataset under the root folder
+func CipherPath() string {
+	return ReadPath() + "/Keystore/Cipher.csv"
+}
+
+// Record the cipher of the dataset, a dataset that is already recorded with another cipher is fatal
+func SaveCipher(c Cipher) {
+	if _, ok := ParseCipher(c.String()); !ok {
+		log.Fatalf("Invalid cipher %s", c)
+	}
+	if _, err := os.Stat(CipherPath()); err == nil {
+		if old := ReadCipher(); old != c {
+			log.Fatalf("The dataset is recorded with %s, encrypt the data with %s under another root folder", old, c)
+		}
+		return
+	}
+
+	os.MkdirAll(ReadPath()+"/Keystore", 0700)
+	f, err := os.Create(CipherPath())
+	if err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"Cipher", c.String()})
+	w.Flush()
+	f.Close()
+}
+
+// Read the cipher of the dataset, Default_Cipher if it is not recorded
+func ReadCipher() Cipher {
+	file, err := os.Open(CipherPath())
+	if err != nil {
+		return Default_Cipher
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	for _, row := range rows {
+		if row[0] != "Cipher" {
+			continue
+		}
+		c, ok := ParseCipher(row[1])
+		if !ok {
+			log.Fatalf("Unknown cipher %q of the dataset", row[1])
+		}
+		return c
+	}
+	return Default_Cipher
+}
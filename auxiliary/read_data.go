@@ -36,6 +36,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 type People struct {
@@ -48,21 +49,110 @@ func MappingPeopletoFolder(people People) string {
 	return strconv.Itoa(int(math.Floor(float64(people.ID)/100))*100) + "-" + strconv.Itoa(int(math.Floor(float64(people.ID)/100))*100+99)
 }
 
-// genotype string -> Int
+// Genotype encoding: allele2 in the lowest Allele_Bits bits, allele1 in the next Allele_Bits bits, then the flags
+const (
+	Allele_Bits      = 6
+	Allele_Missing   = 1<<Allele_Bits - 1
+	Max_Allele       = Allele_Missing - 1
+	Genotype_Phased  = 1 << (2 * Allele_Bits)
+	Genotype_Missing = 1 << (2*Allele_Bits + 1)
+	Genotype_Haploid = 1 << (2*Allele_Bits + 2)
+	Genotype_Bits    = 2*Allele_Bits + 4
+)
+
+// allele string -> Int, "." is the missing allele, -1 if invalid
+func allele_s2i(s string) int {
+	if s == "." {
+		return Allele_Missing
+	}
+	if len(s) == 0 || strings.Trim(s, "0123456789") != "" {
+		return -1
+	}
+	val, err := strconv.Atoi(s)
+	if err != nil || val > Max_Allele {
+		return -1
+	}
+	return val
+}
+
+// genotype string -> Int, like 0|1, 1/2, ./., 1 or ., -1 if invalid or not haploid/diploid
 func Genotype_s2i(s string) int {
-	val1, _ := strconv.Atoi(s[0:1])
-	val2, _ := strconv.Atoi(s[2:3])
-	return val1*4 + val2
+	sep := strings.IndexAny(s, "|/")
+	if sep == -1 {
+		val := allele_s2i(s)
+		if val == -1 {
+			return -1
+		}
+		res := val<<Allele_Bits | Genotype_Haploid
+		if val == Allele_Missing {
+			res |= Genotype_Missing
+		}
+		return res
+	}
+
+	val1 := allele_s2i(s[:sep])
+	val2 := allele_s2i(s[sep+1:])
+	if val1 == -1 || val2 == -1 {
+		return -1
+	}
+	res := val1<<Allele_Bits | val2
+	if s[sep] == '|' {
+		res |= Genotype_Phased
+	}
+	if val1 == Allele_Missing || val2 == Allele_Missing {
+		res |= Genotype_Missing
+	}
+	return res
+}
+
+// allele int -> string
+func allele_i2s(val int) string {
+	if val == Allele_Missing {
+		return "."
+	}
+	return strconv.Itoa(val)
 }
 
 // genotype int -> string
 func Genotype_i2s(val int) string {
-	val2 := val % 4
-	val1 := (val - val2) / 4
-	s1 := strconv.Itoa(val1)
-	s2 := strconv.Itoa(val2)
-	s := s1 + "|" + s2
-	return s
+	val1, val2 := Genotype_Alleles(val)
+	if val&Genotype_Haploid != 0 {
+		return allele_i2s(val1)
+	}
+	sep := "/"
+	if val&Genotype_Phased != 0 {
+		sep = "|"
+	}
+	return allele_i2s(val1) + sep + allele_i2s(val2)
+}
+
+// Get the two allele indices of a genotype, allele2 is 0 for haploid calls
+func Genotype_Alleles(val int) (allele1, allele2 int) {
+	allele2 = val & Allele_Missing
+	allele1 = (val >> Allele_Bits) & Allele_Missing
+	return
+}
+
+// Whether a genotype is a hom-ref call, which is not stored in segments
+func Genotype_IsHomRef(val int) bool {
+	allele1, allele2 := Genotype_Alleles(val)
+	return val&Genotype_Missing == 0 && allele1 == 0 && allele2 == 0
+}
+
+// Number of non-reference alleles of a genotype, -1 if missing
+func Genotype_Dosage(val int) int {
+	if val&Genotype_Missing != 0 {
+		return -1
+	}
+	allele1, allele2 := Genotype_Alleles(val)
+	res := 0
+	if allele1 != 0 {
+		res++
+	}
+	if allele2 != 0 {
+		res++
+	}
+	return res
 }
 
 // rsID -> select and remove "rs"
@@ -122,8 +212,20 @@ func ReadPath() string {
 	return dicpath
 }
 
-// Read all Individual names
+// Read all Individual names, the erased individuals are excluded
 func ReadIndividuals() []People {
+	erased := ReadErasures()
+	Individuals := make([]People, 0)
+	for _, p := range ReadAllIndividuals() {
+		if _, ok := erased[p.Name]; !ok {
+			Individuals = append(Individuals, p)
+		}
+	}
+	return Individuals
+}
+
+// Read all individuals in Individuals.csv including the erased ones, the row order is kept for the STR data
+func ReadAllIndividuals() []People {
 	dicpath := ReadPath()
 	Individuals := make([]People, 0)
 	path, _ := filepath.Abs(dicpath + "/Individuals/Individuals.csv")
@@ -152,10 +254,15 @@ func ReadIndividuals() []People {
 // Read All plaintext data
 func ReadPlaintext_data(people People) ([]int, []int) {
 	dicpath := ReadPath()
+	path, _ := filepath.Abs(dicpath + "/Plaintext_Data/" + MappingPeopletoFolder(people) + "/" + people.Name + ".csv")
+	return ReadGenotypeCSV(path)
+}
+
+// Read a csv file of variant key and genotype rows, like the plaintext data or a delta for UpdateIndividual
+func ReadGenotypeCSV(path string) ([]int, []int) {
 	rsID := []int{}
 	genotype := []int{}
 
-	path, _ := filepath.Abs(dicpath + "/Plaintext_Data/" + MappingPeopletoFolder(people) + "/" + people.Name + ".csv")
 	file, _ := os.Open(path)
 	defer file.Close()
 
@@ -169,10 +276,10 @@ func ReadPlaintext_data(people People) ([]int, []int) {
 			break
 		}
 
-		if RsID_s2i(row[0]) == -1 {
+		if VariantKey_s2i(row[0]) == -1 || Genotype_s2i(row[1]) == -1 {
 			continue
 		}
-		rsID = append(rsID, RsID_s2i(row[0]))
+		rsID = append(rsID, VariantKey_s2i(row[0]))
 		genotype = append(genotype, Genotype_s2i(row[1]))
 
 	}
@@ -201,7 +308,7 @@ func QueryPlaintextByrsID(rsid int, genotype int, DataLen int) int {
 
 	}
 
-	fmt.Println(strconv.Itoa(count) + " Individuals of " + strconv.Itoa(DataLen) + " have Variant " + RsID_i2s(rsid) + " " + Genotype_i2s(genotype))
+	fmt.Println(strconv.Itoa(count) + " Individuals of " + strconv.Itoa(DataLen) + " have Variant " + VariantKey_i2s(rsid) + " " + Genotype_i2s(genotype))
 
 	return count
 }
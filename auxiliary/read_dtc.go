@@ -0,0 +1,165 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auxiliary
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	Reject_NotInTable    = "not in ref/alt table"
+	Reject_UnknownAllele = "allele not in ref/alt table"
+)
+
+// Read a ref/alt allele table, each line is ID, ref and alt separated by tabs, multiple alts are separated by ","
+// the result maps the ID to the alleles, where the ref is the first one
+func ReadRefAltTable(path string) map[string][]string {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	defer file.Close()
+
+	table := make(map[string][]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			continue
+		}
+		alleles := append([]string{strings.ToUpper(fields[1])}, strings.Split(strings.ToUpper(fields[2]), ",")...)
+		table[fields[0]] = alleles
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	return table
+}
+
+// Transfer allele letters to a genotype string by the allele list, e.g. AG -> 0/1 when A is ref and G is alt
+// a single letter is a haploid call, "--" and "00" are missing calls
+func dtcGenotype(letters []string, alleles []string) (string, bool) {
+	indices := make([]string, len(letters))
+	for i, l := range letters {
+		if l == "-" || l == "0" {
+			indices[i] = "."
+			continue
+		}
+		found := false
+		for j, a := range alleles {
+			if a == l {
+				indices[i] = strconv.Itoa(j)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	return strings.Join(indices, "/"), true
+}
+
+// Read a direct-to-consumer genotype file (23andMe or AncestryDNA) of a single individual
+// 23andMe rows are rsid, chromosome, position and genotype like AG, AncestryDNA rows have the two alleles in separated columns
+func ReadDTCSample(path string, name string, table map[string][]string) (SampleGenotypes, []VCFReject) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	defer file.Close()
+
+	sample := SampleGenotypes{Name: name}
+	rejects := []VCFReject{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || strings.HasPrefix(line, "rsid") {
+			continue
+		}
+		fields := strings.Fields(line)
+		reject := VCFReject{ID: line, Sample: name}
+		var letters []string
+		switch len(fields) {
+		case 4:
+			letters = strings.Split(strings.ToUpper(fields[3]), "")
+		case 5:
+			letters = []string{strings.ToUpper(fields[3]), strings.ToUpper(fields[4])}
+		default:
+			reject.Reason = Reject_MalformedRow
+			rejects = append(rejects, reject)
+			continue
+		}
+		pos, _ := strconv.Atoi(fields[2])
+		chrom := plinkChrom(fields[1])
+		reject = VCFReject{Chrom: chrom, Pos: pos, ID: fields[0], Sample: name}
+
+		alleles, ok := table[fields[0]]
+		if !ok {
+			reject.Reason = Reject_NotInTable
+			rejects = append(rejects, reject)
+			continue
+		}
+		keys, alts := SiteKeys(RsID_s2i(fields[0]), chrom, pos, alleles[0], alleles[1:])
+		if len(keys) == 0 {
+			reject.Reason = Reject_NoKey
+			rejects = append(rejects, reject)
+			continue
+		}
+
+		gt, ok := dtcGenotype(letters, alleles)
+		if !ok {
+			reject.Reason = Reject_UnknownAllele + " " + strings.Join(letters, "")
+			rejects = append(rejects, reject)
+			continue
+		}
+		genotype, _, reason := VCFGenotype(gt)
+		if reason != "" {
+			reject.Reason = reason + " " + gt
+			rejects = append(rejects, reject)
+			continue
+		}
+		for k := range keys {
+			if g := Genotype_ForAlt(genotype, alts[k]); !Genotype_IsHomRef(g) {
+				sample.RsID = append(sample.RsID, keys[k])
+				sample.Genotype = append(sample.Genotype, g)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	return sample, rejects
+}
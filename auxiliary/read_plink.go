@@ -0,0 +1,285 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auxiliary
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Magic number of a SNP-major PLINK 1.9 bed file
+var bedMagic = []byte{0x6c, 0x1b, 0x01}
+
+// 2-bit genotype codes of a bed file, A1 is the alternate allele and A2 is the reference allele
+const (
+	bed_HomA1   = 0
+	bed_Missing = 1
+	bed_Het     = 2
+	bed_HomA2   = 3
+)
+
+// PLINK chromosome code -> chromosome name, 25 (XY, pseudo-autosomal) is diploid chrX
+func plinkChrom(s string) string {
+	switch s {
+	case "23", "25", "XY":
+		return "X"
+	case "24":
+		return "Y"
+	case "26", "M":
+		return "MT"
+	}
+	return s
+}
+
+// Whether the calls on the chromosome are haploid for an individual of the PLINK sex code
+func plinkHaploid(chrom string, sex string) bool {
+	switch chrom {
+	case "24", "Y", "26", "MT", "M":
+		return true
+	case "23", "X":
+		return sex == "1"
+	}
+	return false
+}
+
+// Transfer a 2-bit bed code to a genotype string
+func bedGenotype(code byte, haploid bool) string {
+	switch code {
+	case bed_HomA1:
+		if haploid {
+			return "1"
+		}
+		return "1/1"
+	case bed_Het:
+		return "0/1"
+	case bed_HomA2:
+		if haploid {
+			return "0"
+		}
+		return "0/0"
+	}
+	if haploid {
+		return "."
+	}
+	return "./."
+}
+
+// Read all samples from a PLINK 1.9 bed/bim/fam trio with the common prefix, together with the rejected records
+func ReadPLINKSamples(prefix string) ([]SampleGenotypes, []VCFReject) {
+	fam, err := os.ReadFile(prefix + ".fam")
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	samples := []SampleGenotypes{}
+	sexes := []string{}
+	for _, line := range strings.Split(string(fam), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		samples = append(samples, SampleGenotypes{Name: fields[1]})
+		sexes = append(sexes, fields[4])
+	}
+
+	bim, err := os.Open(prefix + ".bim")
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	defer bim.Close()
+	bed, err := os.Open(prefix + ".bed")
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	defer bed.Close()
+
+	br := bufio.NewReaderSize(bed, 1<<20)
+	magic := make([]byte, len(bedMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != string(bedMagic) {
+		log.Fatalf("%s.bed is not a SNP-major PLINK 1.9 bed file", prefix)
+	}
+
+	rejects := []VCFReject{}
+	row := make([]byte, (len(samples)+3)/4)
+	scanner := bufio.NewScanner(bim)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := io.ReadFull(br, row); err != nil {
+			log.Fatalf("%s.bed is shorter than %s.bim, err is %+v", prefix, prefix, err)
+		}
+
+		reject := VCFReject{ID: strings.Join(fields, " ")}
+		if len(fields) != 6 {
+			reject.Reason = Reject_MalformedRow
+			rejects = append(rejects, reject)
+			continue
+		}
+		pos, _ := strconv.Atoi(fields[3])
+		chrom := plinkChrom(fields[0])
+		reject = VCFReject{Chrom: chrom, Pos: pos, ID: fields[1]}
+
+		// the ID may be an rsID or a variant key written by SavePLINK
+		rsid := VariantKey_s2i(fields[1])
+		if rsid == -1 {
+			rsid = PositionKey(chrom, pos, fields[5], fields[4])
+		}
+		if rsid == -1 {
+			reject.Reason = Reject_NoKey
+			rejects = append(rejects, reject)
+			continue
+		}
+
+		for i := range samples {
+			code := row[i/4] >> (2 * (i % 4)) & 3
+			genotype, stored, _ := VCFGenotype(bedGenotype(code, plinkHaploid(fields[0], sexes[i])))
+			if stored {
+				samples[i].RsID = append(samples[i].RsID, rsid)
+				samples[i].Genotype = append(samples[i].Genotype, genotype)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	if _, err := br.ReadByte(); !errors.Is(err, io.EOF) {
+		log.Fatalf("%s.bed is longer than %s.bim", prefix, prefix)
+	}
+
+	return samples, rejects
+}
+
+// Transfer a genotype to a 2-bit bed code, every non-reference allele is counted as A1
+func genotypeBed(genotype int) byte {
+	dosage := Genotype_Dosage(genotype)
+	switch {
+	case dosage == -1:
+		return bed_Missing
+	case dosage == 0:
+		return bed_HomA2
+	case dosage == 2 || genotype&Genotype_Haploid != 0:
+		return bed_HomA1
+	}
+	return bed_Het
+}
+
+// Get the bim columns of a variant key: chromosome, position, A1 and A2
+// rsIDs are unplaced, alleles that are not packed in the key are written as ALT and REF
+func bimColumns(key int) (chrom, pos, a1, a2 string) {
+	chrom, pos, a1, a2 = "0", "0", "ALT", "REF"
+	if !IsPositionKey(key) {
+		return
+	}
+	fields := strings.Split(VariantKey_i2s(key), ":")
+	chrom = strings.TrimPrefix(fields[0], "chr")
+	pos = fields[1]
+	if len(fields) == 4 {
+		a1, a2 = fields[3], fields[2]
+	}
+	return
+}
+
+// Save the genotypes of the samples to a PLINK 1.9 bed/bim/fam trio with the common prefix
+// variants not carried by a sample are hom-ref, so the counts of QueryPlaintextByrsID can be checked by plink --freqx
+func SavePLINK(samples []SampleGenotypes, prefix string) {
+	lookup := make([]map[int]int, len(samples))
+	keyset := map[int]bool{}
+	for i, s := range samples {
+		lookup[i] = make(map[int]int, len(s.RsID))
+		for j, key := range s.RsID {
+			lookup[i][key] = s.Genotype[j]
+			keyset[key] = true
+		}
+	}
+	keys := make([]int, 0, len(keyset))
+	for key := range keyset {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	fam, err := os.Create(prefix + ".fam")
+	if err != nil {
+		log.Fatalf("can not create %s.fam, err is %+v", prefix, err)
+	}
+	fw := bufio.NewWriter(fam)
+	for _, s := range samples {
+		fmt.Fprintf(fw, "%s %s 0 0 0 -9\n", s.Name, s.Name)
+	}
+	fw.Flush()
+	fam.Close()
+
+	bim, err := os.Create(prefix + ".bim")
+	if err != nil {
+		log.Fatalf("can not create %s.bim, err is %+v", prefix, err)
+	}
+	bed, err := os.Create(prefix + ".bed")
+	if err != nil {
+		log.Fatalf("can not create %s.bed, err is %+v", prefix, err)
+	}
+	mw := bufio.NewWriter(bim)
+	dw := bufio.NewWriter(bed)
+	dw.Write(bedMagic)
+	row := make([]byte, (len(samples)+3)/4)
+	for _, key := range keys {
+		chrom, pos, a1, a2 := bimColumns(key)
+		fmt.Fprintf(mw, "%s\t%s\t0\t%s\t%s\t%s\n", chrom, VariantKey_i2s(key), pos, a1, a2)
+
+		for i := range row {
+			row[i] = 0
+		}
+		for i := range samples {
+			code := byte(bed_HomA2)
+			if genotype, ok := lookup[i][key]; ok {
+				code = genotypeBed(genotype)
+			}
+			row[i/4] |= code << (2 * (i % 4))
+		}
+		dw.Write(row)
+	}
+	mw.Flush()
+	dw.Flush()
+	bim.Close()
+	bed.Close()
+}
+
+// Read the plaintext reference data of the individuals as samples
+func ReadPlaintextSamples(Indiv []People) []SampleGenotypes {
+	samples := make([]SampleGenotypes, len(Indiv))
+	for i := 0; i < len(Indiv); i++ {
+		samples[i].Name = Indiv[i].Name
+		samples[i].RsID, samples[i].Genotype = ReadPlaintext_data(Indiv[i])
+	}
+	return samples
+}
@@ -0,0 +1,620 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auxiliary
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	Reject_NoKey        = "no rsID or position key"
+	Reject_Filtered     = "filtered"
+	Reject_MalformedGT  = "malformed GT"
+	Reject_UnsupportGT  = "unsupported GT"
+	Reject_NoGT         = "no GT field"
+	Reject_MalformedRow = "malformed record"
+)
+
+// A record that can not be parsed, the reader has skipped it and can go on with the next one
+var ErrMalformedRecord = errors.New(Reject_MalformedRow)
+
+var gtPattern = regexp.MustCompile(`^(\.|[0-9]+)([|/](\.|[0-9]+))*$`)
+
+// A record of a VCF/BCF file, GT holds one VCF style genotype string per sample
+type VCFRecord struct {
+	Chrom  string
+	Pos    int
+	ID     string
+	Ref    string
+	Alt    []string
+	Filter []string
+	GT     []string
+}
+
+// A record (or a sample of a record) that can not be loaded into Governome
+type VCFReject struct {
+	Chrom  string
+	Pos    int
+	ID     string
+	Sample string
+	Reason string
+}
+
+// The variants of a single sample, in the format of ReadPlaintext_data
+type SampleGenotypes struct {
+	Name     string
+	RsID     []int
+	Genotype []int
+}
+
+// Streaming reader over a VCF, VCF.gz or BCF file
+type VCFReader struct {
+	Samples []string
+	file    *os.File
+	r       *bufio.Reader
+	bcf     bool
+	lineno  int
+	contigs []string
+	dict    []string
+}
+
+// Open a VCF/BCF file, bgzipped files are detected by their magic number
+func OpenVCF(path string) (*VCFReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	vr := &VCFReader{file: file}
+	br := bufio.NewReader(file)
+	var r io.Reader = br
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		r = gz
+	}
+	vr.r = bufio.NewReaderSize(r, 1<<20)
+
+	magic, _ = vr.r.Peek(3)
+	if string(magic) == "BCF" {
+		vr.bcf = true
+		err = vr.readBCFHeader()
+	} else {
+		err = vr.readVCFHeader()
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return vr, nil
+}
+
+// Close the underlying file
+func (vr *VCFReader) Close() error {
+	return vr.file.Close()
+}
+
+// Read the next record, return io.EOF at the end of the file
+func (vr *VCFReader) Next() (VCFRecord, error) {
+	if vr.bcf {
+		return vr.nextBCF()
+	}
+	return vr.nextVCF()
+}
+
+// Read the meta lines and the #CHROM line of a text VCF
+func (vr *VCFReader) readVCFHeader() error {
+	for {
+		line, err := vr.r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("no #CHROM line in VCF header: %v", err)
+		}
+		vr.lineno++
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "##") {
+			continue
+		}
+		if !strings.HasPrefix(line, "#CHROM") {
+			return fmt.Errorf("unexpected VCF header line %d", vr.lineno)
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) > 9 {
+			vr.Samples = fields[9:]
+		}
+		return nil
+	}
+}
+
+// Parse a text VCF line
+func (vr *VCFReader) nextVCF() (rec VCFRecord, err error) {
+	var line string
+	for line == "" {
+		line, err = vr.r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return rec, err
+		}
+		vr.lineno++
+		line = strings.TrimRight(line, "\r\n")
+	}
+
+	fields := strings.Split(line, "\t")
+	if len(fields) < 8 {
+		return rec, fmt.Errorf("line %d: %w", vr.lineno, ErrMalformedRecord)
+	}
+	rec.Chrom = fields[0]
+	rec.Pos, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return rec, fmt.Errorf("line %d: %w", vr.lineno, ErrMalformedRecord)
+	}
+	rec.ID = fields[2]
+	rec.Ref = fields[3]
+	if fields[4] != "." {
+		rec.Alt = strings.Split(fields[4], ",")
+	}
+	if fields[6] != "." {
+		rec.Filter = strings.Split(fields[6], ";")
+	}
+
+	if len(fields) < 9 {
+		return rec, nil
+	}
+	if len(fields)-9 != len(vr.Samples) {
+		return rec, fmt.Errorf("line %d: %d samples, the header has %d: %w", vr.lineno, len(fields)-9, len(vr.Samples), ErrMalformedRecord)
+	}
+	gtIndex := -1
+	for i, key := range strings.Split(fields[8], ":") {
+		if key == "GT" {
+			gtIndex = i
+			break
+		}
+	}
+	if gtIndex == -1 {
+		return rec, nil
+	}
+	rec.GT = make([]string, len(fields)-9)
+	for i := 9; i < len(fields); i++ {
+		values := strings.Split(fields[i], ":")
+		if gtIndex < len(values) {
+			rec.GT[i-9] = values[gtIndex]
+		}
+	}
+	return rec, nil
+}
+
+// Read the magic, the text header and the dictionaries of a BCF file
+func (vr *VCFReader) readBCFHeader() error {
+	magic := make([]byte, 5)
+	if _, err := io.ReadFull(vr.r, magic); err != nil {
+		return err
+	}
+	if string(magic[0:3]) != "BCF" || magic[3] != 2 {
+		return fmt.Errorf("unsupported BCF version %d.%d", magic[3], magic[4])
+	}
+	var l_text uint32
+	if err := binary.Read(vr.r, binary.LittleEndian, &l_text); err != nil {
+		return err
+	}
+	text := make([]byte, l_text)
+	if _, err := io.ReadFull(vr.r, text); err != nil {
+		return err
+	}
+	text = bytes.TrimRight(text, "\x00")
+
+	// PASS is always the first entry of the string dictionary
+	vr.dict = []string{"PASS"}
+	seen := map[string]bool{"PASS": true}
+	for _, line := range strings.Split(string(text), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "##contig=<"):
+			id, idx := headerIDAndIdx(line)
+			vr.contigs = setDictEntry(vr.contigs, id, idx, len(vr.contigs))
+		case strings.HasPrefix(line, "##FILTER=<"), strings.HasPrefix(line, "##INFO=<"), strings.HasPrefix(line, "##FORMAT=<"):
+			id, idx := headerIDAndIdx(line)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			vr.dict = setDictEntry(vr.dict, id, idx, len(vr.dict))
+		case strings.HasPrefix(line, "#CHROM"):
+			fields := strings.Split(line, "\t")
+			if len(fields) > 9 {
+				vr.Samples = fields[9:]
+			}
+		}
+	}
+	return nil
+}
+
+// Get ID and IDX (or -1) from a structured header line
+func headerIDAndIdx(line string) (id string, idx int) {
+	idx = -1
+	body := strings.TrimSuffix(line[strings.Index(line, "<")+1:], ">")
+	for _, kv := range strings.Split(body, ",") {
+		if strings.HasPrefix(kv, "ID=") {
+			id = kv[3:]
+		}
+		if strings.HasPrefix(kv, "IDX=") {
+			idx, _ = strconv.Atoi(kv[4:])
+		}
+	}
+	return
+}
+
+// Put id at position idx of the dictionary, default position is used when idx is -1
+func setDictEntry(dict []string, id string, idx int, def int) []string {
+	if idx < 0 {
+		idx = def
+	}
+	for len(dict) <= idx {
+		dict = append(dict, "")
+	}
+	dict[idx] = id
+	return dict
+}
+
+// Parse a BCF record
+func (vr *VCFReader) nextBCF() (rec VCFRecord, err error) {
+	var lens [2]uint32
+	if err = binary.Read(vr.r, binary.LittleEndian, &lens); err != nil {
+		return
+	}
+	shared := make([]byte, lens[0])
+	if _, err = io.ReadFull(vr.r, shared); err != nil {
+		return
+	}
+	indiv := make([]byte, lens[1])
+	if _, err = io.ReadFull(vr.r, indiv); err != nil {
+		return
+	}
+	vr.lineno++
+
+	if len(shared) < 24 {
+		return rec, fmt.Errorf("record %d: %w", vr.lineno, ErrMalformedRecord)
+	}
+	chrom := int(int32(binary.LittleEndian.Uint32(shared[0:4])))
+	if chrom >= 0 && chrom < len(vr.contigs) {
+		rec.Chrom = vr.contigs[chrom]
+	} else {
+		rec.Chrom = strconv.Itoa(chrom)
+	}
+	rec.Pos = int(int32(binary.LittleEndian.Uint32(shared[4:8]))) + 1
+	n_allele := int(binary.LittleEndian.Uint32(shared[16:20]) >> 16)
+	n_fmt_sample := binary.LittleEndian.Uint32(shared[20:24])
+	n_sample := int(n_fmt_sample & 0xffffff)
+	n_fmt := int(n_fmt_sample >> 24)
+
+	b := &bcfBuffer{data: shared, pos: 24}
+	rec.ID = b.typedString()
+	if rec.ID == "" {
+		rec.ID = "."
+	}
+	for i := 0; i < n_allele; i++ {
+		allele := b.typedString()
+		if i == 0 {
+			rec.Ref = allele
+		} else {
+			rec.Alt = append(rec.Alt, allele)
+		}
+	}
+	for _, f := range b.typedInts() {
+		if f >= 0 && f < len(vr.dict) {
+			rec.Filter = append(rec.Filter, vr.dict[f])
+		}
+	}
+	if b.err != nil {
+		return rec, fmt.Errorf("record %d: %w", vr.lineno, ErrMalformedRecord)
+	}
+
+	if n_fmt > 0 && n_sample != len(vr.Samples) {
+		return rec, fmt.Errorf("record %d: %d samples, the header has %d: %w", vr.lineno, n_sample, len(vr.Samples), ErrMalformedRecord)
+	}
+
+	b = &bcfBuffer{data: indiv}
+	for k := 0; k < n_fmt; k++ {
+		keys := b.typedInts()
+		typ, count := b.descriptor()
+		size := bcfTypeSize(typ)
+		if b.err != nil || len(keys) != 1 || size == 0 || b.pos+size*count*n_sample > len(b.data) {
+			return rec, fmt.Errorf("record %d: %w", vr.lineno, ErrMalformedRecord)
+		}
+		if keys[0] < 0 || keys[0] >= len(vr.dict) || vr.dict[keys[0]] != "GT" {
+			b.pos += size * count * n_sample
+			continue
+		}
+		rec.GT = make([]string, n_sample)
+		for s := 0; s < n_sample; s++ {
+			rec.GT[s] = bcfGenotype(b.data[b.pos:b.pos+size*count], typ)
+			b.pos += size * count
+		}
+	}
+	return rec, nil
+}
+
+type bcfBuffer struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// Size in bytes of a BCF atomic type
+func bcfTypeSize(typ int) int {
+	switch typ {
+	case 1, 7:
+		return 1
+	case 2:
+		return 2
+	case 3, 5:
+		return 4
+	}
+	return 0
+}
+
+// Read a BCF type descriptor, the count may be given by a following typed int
+func (b *bcfBuffer) descriptor() (typ int, count int) {
+	if b.err != nil || b.pos >= len(b.data) {
+		b.err = io.ErrUnexpectedEOF
+		return
+	}
+	typ = int(b.data[b.pos] & 0x0f)
+	count = int(b.data[b.pos] >> 4)
+	b.pos++
+	if count == 15 {
+		counts := b.typedInts()
+		if len(counts) != 1 {
+			b.err = io.ErrUnexpectedEOF
+			return
+		}
+		count = counts[0]
+	}
+	return
+}
+
+// Read a typed vector of integers
+func (b *bcfBuffer) typedInts() []int {
+	typ, count := b.descriptor()
+	size := bcfTypeSize(typ)
+	if b.err != nil || (count > 0 && size == 0) || b.pos+size*count > len(b.data) {
+		b.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	res := make([]int, count)
+	for i := 0; i < count; i++ {
+		res[i] = bcfInt(b.data[b.pos:b.pos+size], typ)
+		b.pos += size
+	}
+	return res
+}
+
+// Read a typed string
+func (b *bcfBuffer) typedString() string {
+	typ, count := b.descriptor()
+	if b.err != nil || (count > 0 && typ != 7) || b.pos+count > len(b.data) {
+		b.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	s := string(bytes.TrimRight(b.data[b.pos:b.pos+count], "\x00"))
+	b.pos += count
+	return s
+}
+
+// Decode a little-endian signed integer of a BCF atomic type
+func bcfInt(data []byte, typ int) int {
+	switch typ {
+	case 1:
+		return int(int8(data[0]))
+	case 2:
+		return int(int16(binary.LittleEndian.Uint16(data)))
+	case 3:
+		return int(int32(binary.LittleEndian.Uint32(data)))
+	}
+	return 0
+}
+
+// Transfer a BCF encoded GT vector to the VCF string form, e.g. 0|1
+func bcfGenotype(data []byte, typ int) string {
+	size := bcfTypeSize(typ)
+	var missing, vectorEnd int
+	switch typ {
+	case 1:
+		missing, vectorEnd = math.MinInt8, math.MinInt8+1
+	case 2:
+		missing, vectorEnd = math.MinInt16, math.MinInt16+1
+	default:
+		missing, vectorEnd = math.MinInt32, math.MinInt32+1
+	}
+
+	var sb strings.Builder
+	for i := 0; i*size < len(data); i++ {
+		val := bcfInt(data[i*size:(i+1)*size], typ)
+		if val == vectorEnd {
+			break
+		}
+		if i > 0 {
+			if val&1 == 1 {
+				sb.WriteByte('|')
+			} else {
+				sb.WriteByte('/')
+			}
+		}
+		if val == missing || val>>1 == 0 {
+			sb.WriteByte('.')
+		} else {
+			sb.WriteString(strconv.Itoa(val>>1 - 1))
+		}
+	}
+	if sb.Len() == 0 {
+		return "."
+	}
+	return sb.String()
+}
+
+// Get the first rsID from the ID column, -1 if there is none
+func VCFRsID(id string) int {
+	for _, s := range strings.Split(id, ";") {
+		if val := RsID_s2i(s); val != -1 {
+			return val
+		}
+	}
+	return -1
+}
+
+// Get the variant keys of the record, the rsID if any, otherwise a position key of chrom:pos:ref:alt per alternate
+// allele, see SiteKeys
+func (rec *VCFRecord) VariantKeys() (keys []int, alts []int) {
+	return SiteKeys(VCFRsID(rec.ID), rec.Chrom, rec.Pos, rec.Ref, rec.Alt)
+}
+
+// Whether the record passes all filters
+func (rec *VCFRecord) Passed() bool {
+	for _, f := range rec.Filter {
+		if f != "PASS" && f != "." {
+			return false
+		}
+	}
+	return true
+}
+
+// Check a GT string and transfer it to the genotype encoding, hom-ref calls are not stored
+func VCFGenotype(gt string) (genotype int, stored bool, reason string) {
+	if !gtPattern.MatchString(gt) {
+		return 0, false, Reject_MalformedGT
+	}
+	genotype = Genotype_s2i(gt)
+	if genotype == -1 {
+		// polyploid calls or allele indices beyond Max_Allele
+		return 0, false, Reject_UnsupportGT
+	}
+	return genotype, !Genotype_IsHomRef(genotype), ""
+}
+
+// Stream the records of the file, visit is called with the sample index, the variant key and the genotype of every
+// stored call, reject with every record or call that can not be loaded, a malformed record is rejected and skipped
+// only an I/O error ends the scan early
+func (vr *VCFReader) Scan(visit func(sample int, key int, genotype int), reject func(VCFReject)) error {
+	for {
+		rec, err := vr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, ErrMalformedRecord) {
+			reject(VCFReject{Chrom: rec.Chrom, Pos: rec.Pos, ID: rec.ID, Reason: Reject_MalformedRow + " " + strings.TrimSuffix(err.Error(), ": "+Reject_MalformedRow)})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		rej := VCFReject{Chrom: rec.Chrom, Pos: rec.Pos, ID: rec.ID}
+		keys, alts := rec.VariantKeys()
+		switch {
+		case len(keys) == 0:
+			rej.Reason = Reject_NoKey
+		case !rec.Passed():
+			rej.Reason = Reject_Filtered
+		case rec.GT == nil:
+			rej.Reason = Reject_NoGT
+		}
+		if rej.Reason != "" {
+			reject(rej)
+			continue
+		}
+
+		for i, gt := range rec.GT {
+			genotype, _, reason := VCFGenotype(gt)
+			if reason != "" {
+				rej.Sample = vr.Samples[i]
+				rej.Reason = reason + " " + gt
+				reject(rej)
+				continue
+			}
+			for k := range keys {
+				if g := Genotype_ForAlt(genotype, alts[k]); !Genotype_IsHomRef(g) {
+					visit(i, keys[k], g)
+				}
+			}
+		}
+	}
+}
+
+// Read all samples from a VCF/BCF file, together with the rejected records
+func ReadVCFSamples(path string) ([]SampleGenotypes, []VCFReject) {
+	vr, err := OpenVCF(path)
+	if err != nil {
+		log.Fatalf("can not open %s, err is %+v", path, err)
+	}
+	defer vr.Close()
+
+	samples := make([]SampleGenotypes, len(vr.Samples))
+	for i := range samples {
+		samples[i].Name = vr.Samples[i]
+	}
+	rejects := []VCFReject{}
+
+	err = vr.Scan(func(i, key, genotype int) {
+		samples[i].RsID = append(samples[i].RsID, key)
+		samples[i].Genotype = append(samples[i].Genotype, genotype)
+	}, func(r VCFReject) {
+		rejects = append(rejects, r)
+	})
+	if err != nil {
+		log.Fatalf("can not read %s, err is %+v", path, err)
+	}
+
+	return samples, rejects
+}
+
+// Save the rejected records to a csv file
+func SaveVCFRejects(rejects []VCFReject, file_path string) {
+	f, err := os.Create(file_path)
+	if err != nil {
+		log.Fatalf("can not create %s, err is %+v", file_path, err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"CHROM", "POS", "ID", "Sample", "Reason"})
+	for _, r := range rejects {
+		w.Write([]string{r.Chrom, strconv.Itoa(r.Pos), r.ID, r.Sample, r.Reason})
+	}
+	w.Flush()
+	f.Close()
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auxiliary
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"log"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Parameters of the passphrase based sealing, scrypt for the key derivation and AES-256-GCM for the encryption
+const (
+	Seal_SaltSize = 16
+	Seal_KeySize  = 32
+	Seal_ScryptN  = 1 << 15
+	Seal_ScryptR  = 8
+	Seal_ScryptP  = 1
+)
+
+// Generate n bytes from the CSPRNG
+func RandomBytes(n int) []byte {
+	res := make([]byte, n)
+	if _, err := rand.Read(res); err != nil {
+		log.Fatalf("can not read the CSPRNG, err is %+v", err)
+	}
+	return res
+}
+
+// Derive a sealing key from a passphrase and a salt
+func DeriveSealKey(passphrase string, salt []byte) []byte {
+	key, err := scrypt.Key([]byte(passphrase), salt, Seal_ScryptN, Seal_ScryptR, Seal_ScryptP, Seal_KeySize)
+	if err != nil {
+		log.Fatalf("can not derive the sealing key, err is %+v", err)
+	}
+	return key
+}
+
+// Encrypt and authenticate plaintext with the sealing key, ad is authenticated but not encrypted, the nonce is prepended
+func Seal(key, plaintext, ad []byte) []byte {
+	aead := newSealAEAD(key)
+	nonce := RandomBytes(aead.NonceSize())
+	return aead.Seal(nonce, nonce, plaintext, ad)
+}
+
+// Decrypt a sealed message, fails if the key or ad is wrong or the message has been modified
+func Unseal(key, sealed, ad []byte) ([]byte, error) {
+	aead := newSealAEAD(key)
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed message too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], ad)
+}
+
+// AES-256-GCM with the sealing key
+func newSealAEAD(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatalf("invalid sealing key, err is %+v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Fatalf("invalid sealing key, err is %+v", err)
+	}
+	return aead
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auxiliary
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"log"
+	"os"
+	"sync"
+)
+
+// Signer of the dataset, signs the data hashes and the erasure receipts so that they can not be rewritten together with the data
+// the Ed25519 key is derived from the passphrase in GOVERNOME_SIGNER_PASSPHRASE and is never stored, only its scrypt salt is
+// verifiers pin the public key published by the data owner in GOVERNOME_SIGNER_PUBKEY (hex), without it the key derived
+// from the passphrase is used, so a signature is never checked against a key read from the data store
+const (
+	Signer_Passphrase_EnvVar = "GOVERNOME_SIGNER_PASSPHRASE"
+	Signer_PublicKey_EnvVar  = "GOVERNOME_SIGNER_PUBKEY"
+)
+
+var (
+	signerLock  sync.Mutex
+	signerCache = make(map[string]ed25519.PrivateKey)
+)
+
+// Get the path of the scrypt salt of the signer
+func SignerSaltPath() string {
+	return ReadPath() + "/Key_Information/Signer.salt"
+}
+
+// Get the signing key, derived from the passphrase once per process, the salt file is created by the first use
+func signerKey() ed25519.PrivateKey {
+	passphrase := os.Getenv(Signer_Passphrase_EnvVar)
+	if passphrase == "" {
+		log.Fatalf("Please set the passphrase of the signer in %s", Signer_Passphrase_EnvVar)
+	}
+	salt_path := SignerSaltPath()
+
+	signerLock.Lock()
+	defer signerLock.Unlock()
+	if key, ok := signerCache[salt_path+"\x00"+passphrase]; ok {
+		return key
+	}
+
+	salt, err := os.ReadFile(salt_path)
+	if os.IsNotExist(err) {
+		salt = RandomBytes(Seal_SaltSize)
+		os.MkdirAll(ReadPath()+"/Key_Information/", os.ModePerm)
+		if err := os.WriteFile(salt_path, salt, 0600); err != nil {
+			log.Fatalf("can not write, err is %+v", err)
+		}
+	} else if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	} else if len(salt) != Seal_SaltSize {
+		log.Fatalf("can not read, %s is damaged", salt_path)
+	}
+
+	key := ed25519.NewKeyFromSeed(DeriveSealKey(passphrase, salt))
+	if pinned, ok := pinnedSignerPublicKey(); ok && !bytes.Equal(pinned, key.Public().(ed25519.PublicKey)) {
+		log.Fatalf("The passphrase in %s does not match the public key in %s", Signer_Passphrase_EnvVar, Signer_PublicKey_EnvVar)
+	}
+	signerCache[salt_path+"\x00"+passphrase] = key
+	return key
+}
+
+// Get the public key pinned in the environment
+func pinnedSignerPublicKey() (ed25519.PublicKey, bool) {
+	s := os.Getenv(Signer_PublicKey_EnvVar)
+	if s == "" {
+		return nil, false
+	}
+	pub, err := hex.DecodeString(s)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		log.Fatalf("Invalid public key of the signer in %s", Signer_PublicKey_EnvVar)
+	}
+	return pub, true
+}
+
+// Get the public key of the signer, the pinned one if given, otherwise the one derived from the passphrase
+func SignerPublicKey() ed25519.PublicKey {
+	if pub, ok := pinnedSignerPublicKey(); ok {
+		return pub
+	}
+	return signerKey().Public().(ed25519.PublicKey)
+}
+
+// Sign a message, domain separates the kinds of signed records
+func Sign(domain string, msg []byte) []byte {
+	return ed25519.Sign(signerKey(), signedMessage(domain, msg))
+}
+
+// Whether sig is a signature of the message by the signer
+func VerifySignature(domain string, msg []byte, sig []byte) bool {
+	return len(sig) == ed25519.SignatureSize && ed25519.Verify(SignerPublicKey(), signedMessage(domain, msg), sig)
+}
+
+// The message actually signed, the domain followed by a zero byte and the message
+func signedMessage(domain string, msg []byte) []byte {
+	return append([]byte("Governome "+domain+"\x00"), msg...)
+}
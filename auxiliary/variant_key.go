@@ -0,0 +1,181 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package auxiliary
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// A variant key is a non-negative 63-bit integer, either an rsID or a packed chrom:pos:ref:alt
+// bit 62: position key flag, bits 57-61: chromosome, bits 29-56: position, bits 0-28: allele code
+// allele code: ref<<2 | alt for SNVs, or bit 28 set with a 28-bit hash of ref:alt for the others
+// a site with several alternate alleles gets a position key per alternate allele
+const (
+	Key_Bits      = 63
+	Key_Position  = 1 << 62
+	Chrom_Shift   = 57
+	Pos_Shift     = 29
+	Max_Pos       = 1<<(Chrom_Shift-Pos_Shift) - 1
+	Allele_Hashed = 1 << 28
+	Allele_Mask   = Allele_Hashed - 1
+)
+
+var chromNames = []string{"", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13", "14", "15", "16", "17", "18", "19", "20", "21", "22", "X", "Y", "MT"}
+
+var bases = "ACGT"
+
+// Chromosome name -> index in 1..25, -1 if it is not a primary chromosome
+func Chrom_s2i(s string) int {
+	s = strings.TrimPrefix(s, "chr")
+	if s == "M" {
+		s = "MT"
+	}
+	for i := 1; i < len(chromNames); i++ {
+		if chromNames[i] == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Chromosome index -> name with "chr"
+func Chrom_i2s(val int) string {
+	if val < 1 || val >= len(chromNames) {
+		return ""
+	}
+	return "chr" + chromNames[val]
+}
+
+// Encode ref and alt alleles into the allele code
+func allele_code(ref, alt string) int {
+	r := strings.IndexByte(bases, ref[0])
+	a := strings.IndexByte(bases, alt[0])
+	if len(ref) == 1 && len(alt) == 1 && r != -1 && a != -1 {
+		return r<<2 | a
+	}
+	hashval := new(big.Int).SetBytes(GenSHA3FromString(ref + ":" + alt))
+	return Allele_Hashed | int(hashval.Int64()&Allele_Mask)
+}
+
+// Pack chrom, pos, ref and alt into a position key, -1 if the site can not be keyed
+func PositionKey(chrom string, pos int, ref, alt string) int {
+	c := Chrom_s2i(chrom)
+	if c == -1 || pos < 1 || pos > Max_Pos || ref == "" || alt == "" {
+		return -1
+	}
+	ref = strings.ToUpper(ref)
+	alt = strings.ToUpper(alt)
+	return Key_Position | c<<Chrom_Shift | pos<<Pos_Shift | allele_code(ref, alt)
+}
+
+// The variant keys of a site: the rsID if it has one, otherwise a position key of ref and each alternate allele
+// alts[i] is the alternate allele (from 1) of keys[i], 0 for an rsID, which keeps the genotype as called
+// the alternate alleles that can not be keyed are left out
+func SiteKeys(rsid int, chrom string, pos int, ref string, alt []string) (keys []int, alts []int) {
+	if rsid != -1 {
+		return []int{rsid}, []int{0}
+	}
+	for j, a := range alt {
+		if key := PositionKey(chrom, pos, ref, a); key != -1 {
+			keys = append(keys, key)
+			alts = append(alts, j+1)
+		}
+	}
+	return
+}
+
+// Project a genotype onto alternate allele alt (from 1) of its site: the calls of alt become 1 and the other called
+// alleles 0, e.g. 1/2 is 1/0 for the first alternate allele and 0/1 for the second, alt 0 keeps the genotype
+func Genotype_ForAlt(val int, alt int) int {
+	if alt == 0 {
+		return val
+	}
+	project := func(a int) int {
+		switch a {
+		case Allele_Missing:
+			return a
+		case alt:
+			return 1
+		}
+		return 0
+	}
+	allele1, allele2 := Genotype_Alleles(val)
+	flags := val &^ (Allele_Missing<<Allele_Bits | Allele_Missing)
+	return flags | project(allele1)<<Allele_Bits | project(allele2)
+}
+
+// Whether the key is a position key
+func IsPositionKey(key int) bool {
+	return key&Key_Position != 0
+}
+
+// rsID ("rs123") or chrom:pos:ref:alt ("chr1:12345:A:G") -> variant key, -1 if invalid
+// a hashed allele code written by VariantKey_i2s ("chr1:12345:#abcdef") is accepted as well
+func VariantKey_s2i(s string) int {
+	if strings.HasPrefix(s, "rs") {
+		return RsID_s2i(s)
+	}
+	fields := strings.Split(s, ":")
+	if len(fields) < 3 || len(fields) > 4 {
+		return -1
+	}
+	pos, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return -1
+	}
+	if len(fields) == 4 {
+		return PositionKey(fields[0], pos, fields[2], fields[3])
+	}
+	if !strings.HasPrefix(fields[2], "#") {
+		return -1
+	}
+	code, err := strconv.ParseInt(fields[2][1:], 16, 64)
+	if err != nil || code < 0 || code > Allele_Mask {
+		return -1
+	}
+	key := PositionKey(fields[0], pos, "N", "N")
+	if key == -1 {
+		return -1
+	}
+	return key&^(Allele_Hashed|Allele_Mask) | Allele_Hashed | int(code)
+}
+
+// Variant key -> rsID or chrom:pos:ref:alt, hashed alleles are written as "#" and the hash in hex
+func VariantKey_i2s(key int) string {
+	if !IsPositionKey(key) {
+		return RsID_i2s(key)
+	}
+	chrom := Chrom_i2s(key >> Chrom_Shift & (1<<(Key_Bits-Chrom_Shift-1) - 1))
+	pos := strconv.Itoa(key >> Pos_Shift & Max_Pos)
+	code := key & (Allele_Hashed | Allele_Mask)
+	if code&Allele_Hashed != 0 {
+		return chrom + ":" + pos + ":#" + strconv.FormatInt(int64(code&Allele_Mask), 16)
+	}
+	return chrom + ":" + pos + ":" + string(bases[code>>2]) + ":" + string(bases[code&3])
+}
@@ -45,6 +45,7 @@ contract GenomeProcess{
     mapping(string => bool) public nicknameKey1NeedShare;
     mapping(string => bool) public nicknameKey2NeedShare;
     mapping(string => Genome_info) public nickname2genome;
+    mapping(address => bytes32) public dataSigner;
     string[] public nicknameList;
 
     address QueryInitiator;
@@ -66,10 +67,13 @@ contract GenomeProcess{
     uint256 public IndivKey2Submitted = 0;
     uint256 public ParticipantNum = 0;
     uint256 public ParticipantProvided = 0;
+    uint256 public SegNum = 96000;
 
     string public rsid;
 
     event DataStored(string _nickname, uint256 _val);
+    event DataUpdated(string _nickname, uint256 _datahash);
+    event SignerRegistered(address _dataowner, bytes32 _signer);
     event StateChanged(string _nickname, bool _newstate);
     event QueryInited(string rsid, uint256 _ParticipantNumber);
     event IndivRecommend(string _nickname, uint256 _hash, uint256 _boxid);
@@ -78,14 +82,16 @@ contract GenomeProcess{
     event ComputingFinished(uint256 _reshash);
 
     function BoxID(string memory _nickname) public view returns (uint256) {
-        return uint256(keccak256(abi.encodePacked(_nickname, rsid))) % 96000;
+        return uint256(keccak256(abi.encodePacked(_nickname, rsid))) % SegNum;
     }
 
-    function Init(address _Hospital, address _ComputingParty) public {
+    function Init(address _Hospital, address _ComputingParty, uint256 _SegNum) public {
         require(inited == false);
         require(ContractCreator == msg.sender);
+        require(_SegNum > 1);
         Hospital = _Hospital;
         ComputingParty = _ComputingParty;
+        SegNum = _SegNum;
         inited = true;
     }
     
@@ -109,6 +115,22 @@ contract GenomeProcess{
         emit DataStored(_nickname, IndivNum);  
     }
 
+    function updateDatahash(string calldata _nickname, uint256 _datahash) public {
+        require(nicknameExistance[_nickname]);
+        require(nickname2genome[_nickname].dataowner == msg.sender);
+        require(step1 == false);
+        require(step2 == false);
+        require(step3 == false);
+        require(step4 == false);
+        require(step5 == false);
+        nickname2genome[_nickname].datahash = _datahash;
+        emit DataUpdated(_nickname, _datahash);
+    }
+
+    function registerSigner(bytes32 _signer) public {
+        dataSigner[msg.sender] = _signer;
+        emit SignerRegistered(msg.sender, _signer);
+    }
 
     function QueryIndivNum() public view returns (uint256) {
         return IndivNum;
@@ -0,0 +1,70 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"Governome/auxiliary"
+	"Governome/streamcipher/trivium"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+func main() {
+
+	toy := flag.Bool("toy", true, "Whether using Toy Parameters")
+	name := flag.String("cipher", "", "Stream cipher (trivium, kreyvium or filip) to benchmark, all of them if not set")
+	bits := flag.Int("bits", 8, "Number of stream bits to time in TFHE ciphertext")
+
+	flag.Parse()
+
+	params := tfhe.ParamsBinaryOriginal.Compile()
+	if *toy {
+		params = auxiliary.ParamsToyBoolean.Compile()
+	}
+	enc := tfhe.NewBinaryEncryptor(params)
+	eval := tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+
+	ciphers := auxiliary.Ciphers
+	if *name != "" {
+		c, ok := auxiliary.ParseCipher(*name)
+		if !ok {
+			log.Fatalf("Invalid cipher %s, expect trivium, kreyvium or filip", *name)
+		}
+		ciphers = []auxiliary.Cipher{c}
+	}
+
+	fmt.Printf("%-9s %8s %8s %8s %8s %8s %10s %12s %12s %8s\n", "Cipher", "Security", "KeyBits", "Combine", "Init", "PerBit",
+		"Segment", "InitTime", "BitTime", "Mismatch")
+	for _, c := range ciphers {
+		b := trivium.BenchmarkCipher(c, *bits, enc, eval)
+		fmt.Printf("%-9s %8d %8d %8d %8d %8d %10d %12s %12s %8d\n", b.Cipher, b.Security_Bits, b.Key_Bits, b.Combine_Gates,
+			b.Init_Gates, b.Bit_Gates, b.Segment_Gates, b.Init_Time.Round(1e6), b.Bit_Time.Round(1e3), b.Mismatch)
+	}
+}
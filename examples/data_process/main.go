@@ -30,7 +30,11 @@ import (
 	"Governome/applications"
 	"Governome/auxiliary"
 	"Governome/streamcipher/trivium"
+	"encoding/hex"
 	"flag"
+	"fmt"
+	"log"
+	"os"
 
 	"github.com/sp301415/tfhe-go/tfhe"
 )
@@ -42,29 +46,165 @@ func main() {
 	codissymbol := flag.Bool("str", false, "Whether to generate the str data")
 	codisencsymbol := flag.Bool("strenc", false, "Whether to encrypt the str data")
 	keysymbol := flag.Bool("genkey", false, "Whether to generate the keys")
-	Hosted := flag.Bool("precomputed", false, "Whether owner choose to precompute the access token")
+	demosymbol := flag.Bool("demo", false, "Whether to also export the generated secret key unsealed for demos, only for toy params")
+	precomputed := flag.Bool("precomputed", false, "Whether owner choose to precompute the access token, the key modes hosted for the owner and segment for the others of a new dataset")
+	keymodes := flag.String("keymodes", "", "Comma separated key mode (segment or hosted) of each key holder of a new dataset, recorded with the keys (segment for the owner and hosted for the others if not set)")
 	Path := flag.String("path", "../../..", "Root FilePath")
+	vcfpath := flag.String("vcf", "", "Multi-sample VCF/VCF.gz/BCF file to preprocess to segments")
+	plinkpath := flag.String("plink", "", "Prefix of PLINK bed/bim/fam files to preprocess to segments")
+	plinkout := flag.String("plink_out", "", "Prefix of PLINK bed/bim/fam files to export the plaintext reference data of all individuals")
+	decsymbol := flag.Bool("decrypted", false, "Whether to export the data from decrypted segments instead of the plaintext data")
+	dtcpath := flag.String("dtc", "", "23andMe/AncestryDNA genotype file of a single individual to preprocess to segments")
+	tablepath := flag.String("table", "", "Ref/alt allele table (ID, ref, alt separated by tabs) for the -dtc file")
+	username := flag.String("user", "", "Name of the individual of the -dtc, -update, -rotate or -delete")
+	userid := flag.Int("id", 0, "ID of the individual of the -dtc file, decides the subfolder")
+	convertsymbol := flag.Bool("convert", false, "Whether to convert the legacy csv segments and public key to the binary formats, seal the legacy secret key and encrypt the legacy CODIS again for the application registry")
+	rotateholder := flag.Int("rotate", 0, "Key holder (1, 2, ...) of -user whose key is replaced, the data of -user is encrypted again")
+	deletesymbol := flag.Bool("delete", false, "Whether to erase all encrypted data of -user and record an erasure receipt")
+	segnum := flag.Int("seg_num", 0, "Number of segments per individual of a new dataset, recorded with the data (96000 if not set)")
+	blocksize := flag.Int("blocksize", 0, "Minimal number of variants per segment of a new dataset, recorded with the data (20 if not set)")
+	holders := flag.Int("holders", 0, "Number of key holders of each individual of a new dataset, recorded with the keys (2 if not set)")
+	threshold := flag.Int("threshold", 0, "Number of key holders needed to recover a key of a new dataset, 0 for all of them (t-of-n if set)")
+	updatepath := flag.String("update", "", "Csv file of variants (variant key, genotype) to update the data of -user, a hom-ref genotype removes the variant")
+	signersymbol := flag.Bool("signer", false, "Whether to print the public key of the signer of the data hashes, to be pinned by the verifiers")
+	cipher := flag.String("cipher", "", "Stream cipher (trivium, kreyvium or filip) of a new dataset, recorded with the keys (trivium if not set)")
 
 	auxiliary.SavePath(*Path)
 
 	flag.Parse() 
+	trivium.RecoverRotations()
+
+	if *segnum > 0 || *blocksize > 0 {
+		params := auxiliary.Default_SegParams
+		if *segnum > 0 {
+			params.Seg_num = *segnum
+		}
+		if *blocksize > 0 {
+			params.Minimal_Blocksize = *blocksize
+		}
+		auxiliary.SaveSegParams(params)
+	}
+
+	if *holders > 0 || *threshold > 0 {
+		sharing := auxiliary.Default_KeySharing
+		if *holders > 0 {
+			sharing.Holders = *holders
+		}
+		sharing.Threshold = *threshold
+		auxiliary.SaveKeySharing(sharing)
+	}
+
+	if *keymodes != "" || *precomputed {
+		modes := auxiliary.DefaultKeyModes(auxiliary.ReadKeySharing().Holders, *precomputed)
+		if *keymodes != "" {
+			modes = auxiliary.ParseKeyModes(*keymodes)
+		}
+		auxiliary.SaveKeyModes(modes)
+	}
+
+	if *cipher != "" {
+		c, ok := auxiliary.ParseCipher(*cipher)
+		if !ok {
+			log.Fatalf("Invalid cipher %s, expect trivium, kreyvium or filip", *cipher)
+		}
+		auxiliary.SaveCipher(c)
+	}
+
+	if *signersymbol {
+		fmt.Println(hex.EncodeToString(auxiliary.SignerPublicKey()))
+	}
 
 	if *codissymbol {
 		applications.GenAndSaveCODISData()
 	}
 
+	keyparams := tfhe.ParamsBinaryOriginal.Compile()
+	if *toy {
+		keyparams = auxiliary.ParamsToyBoolean.Compile()
+	}
 	if *keysymbol {
-		if *toy {
-			trivium.GenAndSaveKey(auxiliary.ParamsToyBoolean.Compile())
-		} else {
-			trivium.GenAndSaveKey(tfhe.ParamsBinaryOriginal.Compile())
+		enc := trivium.GenAndSaveKey(keyparams, trivium.SKPassphrase())
+		if *demosymbol {
+			trivium.ExportDemoSK(enc)
 		}
 	}
 	if *codisencsymbol {
-		trivium.EncAndSaveCODIS_Trivium(*Hosted)
+		trivium.EncAndSaveCODIS_Trivium()
 	}
 	if *segsymbol {
-		trivium.EncryptAndSaveData(*Hosted)
+		trivium.EncryptAndSaveData()
+	}
+	if *convertsymbol {
+		if *precomputed {
+			trivium.MigrateLegacyHostedFiles()
+		}
+		trivium.SignLegacyDataHash()
+		auxiliary.SignLegacyErasures()
+		trivium.MigrateLegacyCODIS()
+		trivium.ConvertAllSegmentsCSV()
+		if _, err := os.Stat(trivium.PublicKeyPath(".csv")); err == nil {
+			trivium.ConvertPKCSV(keyparams)
+		}
+		if _, err := os.Stat(trivium.SecretKeyPath("")); err == nil {
+			trivium.SealLegacySK(keyparams, trivium.SKPassphrase())
+		}
+	}
+	if *vcfpath != "" {
+		rejects := trivium.EncryptAndSaveVCF(*vcfpath)
+		auxiliary.SaveVCFRejects(rejects, auxiliary.ReadPath()+"/Segments_Enc_Data/VCF_Rejects.csv")
+	}
+	if *plinkpath != "" {
+		rejects := trivium.EncryptAndSavePLINK(*plinkpath)
+		auxiliary.SaveVCFRejects(rejects, auxiliary.ReadPath()+"/Segments_Enc_Data/PLINK_Rejects.csv")
+	}
+	if *dtcpath != "" {
+		if *tablepath == "" || *username == "" {
+			log.Fatalf("-dtc requires -table and -user")
+		}
+		people := auxiliary.People{Name: *username, ID: *userid}
+		rejects := trivium.EncryptAndSaveDTC(people, *dtcpath, *tablepath)
+		auxiliary.SaveVCFRejects(rejects, auxiliary.ReadPath()+"/Segments_Enc_Data/"+people.Name+"_DTC_Rejects.csv")
+	}
+	if *updatepath != "" {
+		people := auxiliary.People{Name: *username, ID: *userid}
+		for _, p := range auxiliary.ReadIndividuals() {
+			if p.Name == *username {
+				people = p
+			}
+		}
+		RSIDs, GTs := auxiliary.ReadGenotypeCSV(*updatepath)
+		trivium.UpdateIndividual(people, RSIDs, GTs)
+	}
+	if *rotateholder > 0 {
+		people := auxiliary.People{Name: *username, ID: *userid}
+		for _, p := range auxiliary.ReadIndividuals() {
+			if p.Name == *username {
+				people = p
+			}
+		}
+		trivium.RotateKey(people, *rotateholder)
+	}
+	if *deletesymbol {
+		people := auxiliary.People{Name: *username, ID: *userid}
+		for _, p := range auxiliary.ReadIndividuals() {
+			if p.Name == *username {
+				people = p
+			}
+		}
+		trivium.DeleteIndividual(people)
+	}
+	if *plinkout != "" {
+		Indiv := auxiliary.ReadIndividuals()
+		var samples []auxiliary.SampleGenotypes
+		if *decsymbol {
+			samples = make([]auxiliary.SampleGenotypes, len(Indiv))
+			for i := 0; i < len(Indiv); i++ {
+				samples[i] = trivium.DecryptSegmentsToSample(Indiv[i])
+			}
+		} else {
+			samples = auxiliary.ReadPlaintextSamples(Indiv)
+		}
+		auxiliary.SavePLINK(samples, *plinkout)
 	}
 
 }
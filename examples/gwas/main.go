@@ -27,29 +27,34 @@
 package main
 
 import (
+	thfhe "Governome/ThFHE"
 	"Governome/applications"
 	"Governome/auxiliary"
 	"Governome/snarks"
 	"Governome/streamcipher/trivium"
 	"flag"
 	"fmt"
-	"log"
 	"strconv"
 
-	"github.com/consensys/gnark/backend/groth16"
-	"github.com/consensys/gnark/backend/witness"
 	"github.com/sp301415/tfhe-go/tfhe"
 )
 
-func GWAS(Parameter tfhe.ParametersLiteral[uint32], rsid string, population string, Readsymbol bool, Verifysymbol bool, option bool) {
+func GWAS(Parameter tfhe.ParametersLiteral[uint32], rsid string, population string, Readsymbol bool, Thresholdsymbol bool, Verifysymbol bool) {
 	params := Parameter.Compile()
+	// the keys of ThFHE are generated by the key share holders, the data is read from file
+	Readsymbol = Readsymbol || Thresholdsymbol
 
 	enc := tfhe.NewBinaryEncryptor(params)
-	if Readsymbol {
-		trivium.ReadSK(enc)
+	if Readsymbol && !Thresholdsymbol {
+		trivium.LoadSK(enc)
 	}
 
-	eval := tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+	var eval *tfhe.BinaryEvaluator
+	if Thresholdsymbol {
+		eval = tfhe.NewBinaryEvaluator(params, thfhe.ReadEvaluationKey(params))
+	} else {
+		eval = tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+	}
 
 	pk := auxiliary.GenLWEPublicKey_tfheb(enc)
 	if Readsymbol {
@@ -66,54 +71,31 @@ func GWAS(Parameter tfhe.ParametersLiteral[uint32], rsid string, population stri
 
 	DataLen := len(Indiv)
 
-	segkey1 := make([][]tfhe.LWECiphertext[uint32], DataLen)
-	segkey2 := make([][]tfhe.LWECiphertext[uint32], DataLen)
+	segkeys := make([]trivium.SegKeys, DataLen)
 
 	if Readsymbol {
 		for i := 0; i < DataLen; i++ {
-			segkey1[i] = snarks.ReadSegKey(Indiv[i], 1, params)
-			segkey2[i] = snarks.ReadSegKey(Indiv[i], 2, params)
+			segkeys[i] = snarks.ReadSegKeys(Indiv[i], params)
 		}
 	} else {
 		pk := auxiliary.GenLWEPublicKey_tfheb(enc)
-		segkey1, segkey2 = trivium.GetSegKeyFromPK(pk, auxiliary.RsID_s2i(rsid), 1, Indiv, option)
+		segkeys = trivium.GetSegKeyFromPK(pk, auxiliary.VariantKey_s2i(rsid), 1, Indiv)
 	}
 
 	if Verifysymbol {
-		ccs := snarks.GenorReadR1CS(false, option)
-		_, VerifyKey := snarks.GenorReadSetup(ccs, false, option)
 		for i := 0; i < DataLen; i++ {
-			segID := auxiliary.SegmentID(Indiv[i], auxiliary.RsID_s2i(rsid), auxiliary.Seg_num)
-			keyhash1, keyhash2 := trivium.ReadKeyhash(Indiv[i], 1, option)
-			proof1 := snarks.ReadProof(Indiv[i], 1, 1)
-			proof2 := snarks.ReadProof(Indiv[i], 2, 1)
-			var publicWitness1, publicWitness2 []witness.Witness
-			if option {
-				publicWitness1 = snarks.ConstructpublicWitnessWithSegKeyHosted(keyhash1, segkey1[i])
-				publicWitness2 = snarks.ConstructpublicWitnessWithSegKeyDefault(segID, keyhash2, segkey2[i])
-			} else {
-				publicWitness1 = snarks.ConstructpublicWitnessWithSegKeyDefault(segID, keyhash1, segkey1[i])
-				publicWitness2 = snarks.ConstructpublicWitnessWithSegKeyHosted(keyhash2, segkey2[i])
-			}
-
-			for k := 0; k < len(proof1); k++ {
-				err := groth16.Verify(proof1[k], VerifyKey, publicWitness1[k])
-				if err != nil {
-					log.Fatalf("Verification failed, err is %+v", err)
-				}
-			}
-			for k := 0; k < len(proof2); k++ {
-				err := groth16.Verify(proof2[k], VerifyKey, publicWitness2[k])
-				if err != nil {
-					log.Fatalf("Verification failed, err is %+v", err)
-				}
-			}
+			segID := auxiliary.SegmentID(Indiv[i], auxiliary.VariantKey_s2i(rsid), auxiliary.ReadSegParams().Seg_num)
+			snarks.VerifySegKeys(Indiv[i], segID, segkeys[i], trivium.ReadKeyhashes(Indiv[i]))
 		}
 	}
 
-	bit_res, exp_res := trivium.GWASBool(auxiliary.RsID_s2i(rsid), segkey1, segkey2, eval, 1, Indiv, Phenotype_Ciphertext, option)
+	bit_res, exp_res := trivium.GWASBool(auxiliary.VariantKey_s2i(rsid), segkeys, eval, 1, Indiv, Phenotype_Ciphertext)
 
-	val := trivium.DecDivResult(bit_res, exp_res, enc)
+	var dec trivium.LWEBoolDecryptor = enc
+	if Thresholdsymbol {
+		dec = thfhe.DecryptWithKeyShares(params, append(bit_res.Ciphertexts(), exp_res.Ciphertexts()...))
+	}
+	val := trivium.DecDivResult(bit_res, exp_res, dec)
 	p := trivium.GWASResultToPValue(val, len(Indiv))
 
 	fmt.Printf("The P value is (%s)\n", strconv.FormatFloat(p, 'f', -1, 64))
@@ -121,18 +103,19 @@ func GWAS(Parameter tfhe.ParametersLiteral[uint32], rsid string, population stri
 
 func main() {
 
-	rsid := flag.String("rsid", "rs6053810", "Target Site in rsID")
+	rsid := flag.String("rsid", "rs6053810", "Target Site in rsID or chrom:pos:ref:alt")
 	population := flag.String("cohort", "EUR", "Population, in 'AFR', 'AMR', 'EAS', 'EUR', 'SAS'")
 	toy := flag.Bool("toy", true, "Whether using Toy Parameters")
 	readsymbol := flag.Bool("read", false, "Whether read Data from file, not suitable for toy params")
+	thresholdsymbol := flag.Bool("threshold", false, "Whether the keys are generated by the key share holders of ThFHE, who decrypt the result together, implies -read")
 	verifysymbol := flag.Bool("verify", false, "Whether verifying the proofs")
-	Hosted := flag.Bool("precomputed", false, "Whether owner choose to precompute the access token")
 	flag.Parse()
+	trivium.RecoverRotations()
 
 	if *toy {
-		GWAS(auxiliary.ParamsToyBoolean, *rsid, *population, *readsymbol, *verifysymbol, *Hosted)
+		GWAS(auxiliary.ParamsToyBoolean, *rsid, *population, *readsymbol, *thresholdsymbol, *verifysymbol)
 	} else {
-		GWAS(tfhe.ParamsBinaryOriginal, *rsid, *population, *readsymbol, *verifysymbol, *Hosted)
+		GWAS(tfhe.ParamsBinaryOriginal, *rsid, *population, *readsymbol, *thresholdsymbol, *verifysymbol)
 	}
 
 }
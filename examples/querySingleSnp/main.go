@@ -27,83 +27,79 @@
 package main
 
 import (
+	thfhe "Governome/ThFHE"
 	"Governome/applications"
 	"Governome/auxiliary"
 	"Governome/snarks"
 	"Governome/streamcipher/trivium"
 	"flag"
 	"fmt"
-	"log"
 	"strconv"
 
-	"github.com/consensys/gnark/backend/groth16"
-	"github.com/consensys/gnark/backend/witness"
 	"github.com/sp301415/tfhe-go/tfhe"
 )
 
-func QueryBoolean(Parameter tfhe.ParametersLiteral[uint32], rsid string, population string, Readsymbol bool, Verifysymbol bool, option bool) {
+func QueryBoolean(Parameter tfhe.ParametersLiteral[uint32], rsid string, population string, Readsymbol bool, Thresholdsymbol bool, Queriersymbol bool, Verifysymbol bool) {
 	params := Parameter.Compile()
+	// the keys of ThFHE are generated by the key share holders, the data is read from file
+	Thresholdsymbol = Thresholdsymbol || Queriersymbol
+	Readsymbol = Readsymbol || Thresholdsymbol
 
 	enc := tfhe.NewBinaryEncryptor(params)
-	if Readsymbol {
-		trivium.ReadSK(enc)
+	if Readsymbol && !Thresholdsymbol {
+		trivium.LoadSK(enc)
 	}
 
-	eval := tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+	var eval *tfhe.BinaryEvaluator
+	if Thresholdsymbol {
+		eval = tfhe.NewBinaryEvaluator(params, thfhe.ReadEvaluationKey(params))
+	} else {
+		eval = tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+	}
 
 	WholeIndivs := auxiliary.ReadIndividuals()
 	Indiv, _, _, _ := applications.ReadPhenotype(WholeIndivs, population)
 	DataLen := len(Indiv)
 
-	segkey1 := make([][]tfhe.LWECiphertext[uint32], DataLen)
-	segkey2 := make([][]tfhe.LWECiphertext[uint32], DataLen)
+	segkeys := make([]trivium.SegKeys, DataLen)
 
 	if Readsymbol {
 		for i := 0; i < DataLen; i++ {
-			segkey1[i] = snarks.ReadSegKey(Indiv[i], 1, params)
-			segkey2[i] = snarks.ReadSegKey(Indiv[i], 2, params)
+			segkeys[i] = snarks.ReadSegKeys(Indiv[i], params)
 		}
 	} else {
 		pk := auxiliary.GenLWEPublicKey_tfheb(enc)
-		segkey1, segkey2 = trivium.GetSegKeyFromPK(pk, auxiliary.RsID_s2i(rsid), 1, Indiv, option)
+		segkeys = trivium.GetSegKeyFromPK(pk, auxiliary.VariantKey_s2i(rsid), 1, Indiv)
 	}
 
 	if Verifysymbol {
-		ccs := snarks.GenorReadR1CS(false, option)
-		_, VerifyKey := snarks.GenorReadSetup(ccs, false, option)
 		for i := 0; i < DataLen; i++ {
-			segID := auxiliary.SegmentID(Indiv[i], auxiliary.RsID_s2i(rsid), auxiliary.Seg_num)
-			keyhash1, keyhash2 := trivium.ReadKeyhash(Indiv[i], 1, option)
-			proof1 := snarks.ReadProof(Indiv[i], 1, 1)
-			proof2 := snarks.ReadProof(Indiv[i], 2, 1)
-			var publicWitness1, publicWitness2 []witness.Witness
-			if option {
-				publicWitness1 = snarks.ConstructpublicWitnessWithSegKeyHosted(keyhash1, segkey1[i])
-				publicWitness2 = snarks.ConstructpublicWitnessWithSegKeyDefault(segID, keyhash2, segkey2[i])
-			} else {
-				publicWitness1 = snarks.ConstructpublicWitnessWithSegKeyDefault(segID, keyhash1, segkey1[i])
-				publicWitness2 = snarks.ConstructpublicWitnessWithSegKeyHosted(keyhash2, segkey2[i])
-			}
-
-			for k := 0; k < len(proof1); k++ {
-				err := groth16.Verify(proof1[k], VerifyKey, publicWitness1[k])
-				if err != nil {
-					log.Fatalf("Verification failed, err is %+v", err)
-				}
-			}
-			for k := 0; k < len(proof2); k++ {
-				err := groth16.Verify(proof2[k], VerifyKey, publicWitness2[k])
-				if err != nil {
-					log.Fatalf("Verification failed, err is %+v", err)
-				}
-			}
+			segID := auxiliary.SegmentID(Indiv[i], auxiliary.VariantKey_s2i(rsid), auxiliary.ReadSegParams().Seg_num)
+			snarks.VerifySegKeys(Indiv[i], segID, segkeys[i], trivium.ReadKeyhashes(Indiv[i]))
 		}
 	}
 
-	res := trivium.QueryCiphertext(auxiliary.RsID_s2i(rsid), segkey1, segkey2, eval, 1, Indiv, option)
-	count00 := trivium.BigValue2Int(trivium.Dec_BigValue(res[0], enc))
-	count01 := trivium.BigValue2Int(trivium.Dec_BigValue(res[1], enc))
-	count11 := trivium.BigValue2Int(trivium.Dec_BigValue(res[2], enc))
+	res := trivium.QueryCiphertext(auxiliary.VariantKey_s2i(rsid), segkeys, eval, 1, Indiv)
+
+	var dec trivium.LWEBoolDecryptor = enc
+	if Queriersymbol {
+		// the key share holders re-encrypt each result to the public key of the querier, the results are only readable by the querier
+		querier := tfhe.NewBinaryEncryptor(params)
+		querierPK := auxiliary.GenLWEPublicKey_tfheb(querier)
+		for i := 0; i < len(res); i++ {
+			res[i].Values = thfhe.ReEncryptWithKeyShares(params, querierPK, res[i].Values)
+		}
+		dec = querier
+	} else if Thresholdsymbol {
+		var cts []tfhe.LWECiphertext[uint32]
+		for i := 0; i < len(res); i++ {
+			cts = append(cts, res[i].Ciphertexts()...)
+		}
+		dec = thfhe.DecryptWithKeyShares(params, cts)
+	}
+	count00 := trivium.BigValue2Int(trivium.Dec_BigValue(res[0], dec))
+	count01 := trivium.BigValue2Int(trivium.Dec_BigValue(res[1], dec))
+	count11 := trivium.BigValue2Int(trivium.Dec_BigValue(res[2], dec))
 
 	fmt.Println(strconv.Itoa(int(count00)) + " Individuals has Variant " + rsid + " 0|0")
 	fmt.Println(strconv.Itoa(int(count01)) + " Individuals has Variant " + rsid + " 0|1")
@@ -112,19 +108,21 @@ func QueryBoolean(Parameter tfhe.ParametersLiteral[uint32], rsid string, populat
 
 func main() {
 
-	rsid := flag.String("rsid", "rs6053810", "Target Site in rsID")
+	rsid := flag.String("rsid", "rs6053810", "Target Site in rsID or chrom:pos:ref:alt")
 	population := flag.String("cohort", "ALL", "Population, in 'AFR', 'AMR', 'EAS', 'EUR', 'SAS', 'ALL'")
 	toy := flag.Bool("toy", true, "Whether using Toy Parameters")
 	readsymbol := flag.Bool("read", false, "Whether read Data from file, not suitable for toy params")
+	thresholdsymbol := flag.Bool("threshold", false, "Whether the keys are generated by the key share holders of ThFHE, who decrypt the result together, implies -read")
+	queriersymbol := flag.Bool("querier", false, "Whether the result is re-encrypted to a key of the querier instead of decrypted by the key share holders, implies -threshold")
 	verifysymbol := flag.Bool("verify", false, "Whether verifying the proofs")
-	Hosted := flag.Bool("precomputed", false, "Whether owner choose to precompute the access token")
 
 	flag.Parse()
+	trivium.RecoverRotations()
 
 	if *toy {
-		QueryBoolean(auxiliary.ParamsToyBoolean, *rsid, *population, *readsymbol, *verifysymbol, *Hosted)
+		QueryBoolean(auxiliary.ParamsToyBoolean, *rsid, *population, *readsymbol, *thresholdsymbol, *queriersymbol, *verifysymbol)
 	} else {
-		QueryBoolean(tfhe.ParamsBinaryOriginal, *rsid, *population, *readsymbol, *verifysymbol, *Hosted)
+		QueryBoolean(tfhe.ParamsBinaryOriginal, *rsid, *population, *readsymbol, *thresholdsymbol, *queriersymbol, *verifysymbol)
 	}
 
 }
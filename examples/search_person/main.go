@@ -27,30 +27,35 @@
 package main
 
 import (
+	thfhe "Governome/ThFHE"
 	"Governome/applications"
 	"Governome/auxiliary"
 	"Governome/snarks"
 	"Governome/streamcipher/trivium"
 	"flag"
 	"fmt"
-	"log"
 	"strconv"
 
-	"github.com/consensys/gnark/backend/groth16"
-	"github.com/consensys/gnark/backend/witness"
 	"github.com/sp301415/tfhe-go/tfhe"
 )
 
-func Boolean_Search(query_target applications.CODIS, Parameter tfhe.ParametersLiteral[uint32], Readsymbol bool, Verifysymbol bool, option bool) {
+func Boolean_Search(query_target applications.CODIS, Parameter tfhe.ParametersLiteral[uint32], Readsymbol bool, Queriersymbol bool, Verifysymbol bool) {
 	params := Parameter.Compile()
+	// the keys of ThFHE are generated by the key share holders, the data is read from file
+	Readsymbol = Readsymbol || Queriersymbol
 
 	enc := tfhe.NewBinaryEncryptor(params)
 
-	if Readsymbol {
-		trivium.ReadSK(enc)
+	if Readsymbol && !Queriersymbol {
+		trivium.LoadSK(enc)
 	}
 
-	eval := tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+	var eval *tfhe.BinaryEvaluator
+	if Queriersymbol {
+		eval = tfhe.NewBinaryEvaluator(params, thfhe.ReadEvaluationKey(params))
+	} else {
+		eval = tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+	}
 
 	pk := auxiliary.GenLWEPublicKey_tfheb(enc)
 	if Readsymbol {
@@ -61,55 +66,36 @@ func Boolean_Search(query_target applications.CODIS, Parameter tfhe.ParametersLi
 
 	DataLen := len(Indiv)
 
-	segkey1 := make([][]tfhe.LWECiphertext[uint32], DataLen)
-	segkey2 := make([][]tfhe.LWECiphertext[uint32], DataLen)
+	segkeys := make([]trivium.SegKeys, DataLen)
 
 	if Readsymbol {
 		for i := 0; i < DataLen; i++ {
-			segkey1[i] = snarks.ReadSegKey(Indiv[i], 1, params)
-			segkey2[i] = snarks.ReadSegKey(Indiv[i], 2, params)
+			segkeys[i] = snarks.ReadSegKeys(Indiv[i], params)
 		}
 	} else {
-		segkey1, segkey2 = trivium.GetSegKeyFromPKForAppID(pk, applications.App_id_SearchPerson, 1, Indiv, option)
+		segkeys = trivium.GetSegKeyFromPKForAppID(pk, applications.App_id_SearchPerson(), 1, Indiv)
 	}
 
 	if Verifysymbol {
-		ccs := snarks.GenorReadR1CS(false, option)
-		_, VerifyKey := snarks.GenorReadSetup(ccs, false, option)
 		for i := 0; i < DataLen; i++ {
-			keyhash1, keyhash2 := trivium.ReadKeyhash(Indiv[i], 1, option)
-			var publicWitness1, publicWitness2 []witness.Witness
-			proof1 := snarks.ReadProof(Indiv[i], 1, 1)
-			proof2 := snarks.ReadProof(Indiv[i], 2, 1)
-			if option {
-				publicWitness1 = snarks.ConstructpublicWitnessWithSegKeyHosted(keyhash1, segkey1[i])
-				publicWitness2 = snarks.ConstructpublicWitnessWithSegKeyHosted(keyhash2, segkey2[i])
-			} else {
-				publicWitness1 = snarks.ConstructpublicWitnessWithSegKeyDefault(applications.App_id_SearchPerson, keyhash1, segkey1[i])
-				publicWitness2 = snarks.ConstructpublicWitnessWithSegKeyDefault(applications.App_id_SearchPerson, keyhash2, segkey2[i])
-			}
-
-			for k := 0; k < len(proof1); k++ {
-				err := groth16.Verify(proof1[k], VerifyKey, publicWitness1[k])
-				if err != nil {
-					log.Fatalf("Verification failed, err is %+v", err)
-				}
-			}
-			for k := 0; k < len(proof2); k++ {
-				err := groth16.Verify(proof2[k], VerifyKey, publicWitness2[k])
-				if err != nil {
-					log.Fatalf("Verification failed, err is %+v", err)
-				}
-			}
+			snarks.VerifySegKeys(Indiv[i], applications.App_id_SearchPerson(), segkeys[i], trivium.ReadKeyhashes(Indiv[i]))
 		}
 	}
 
 	QueryCODIS := trivium.Enc_CODIS(trivium.Encode_Single_CODIS(query_target), pk)
 
-	res := trivium.SearchPerson(QueryCODIS, segkey1, segkey2, eval, DataLen, 1, option)
+	res := trivium.SearchPerson(QueryCODIS, segkeys, eval, DataLen, 1)
+
+	var dec trivium.LWEBoolDecryptor = enc
+	if Queriersymbol {
+		// the key share holders re-encrypt each result to the public key of the querier, the results are only readable by the querier
+		querier := tfhe.NewBinaryEncryptor(params)
+		res = thfhe.ReEncryptWithKeyShares(params, auxiliary.GenLWEPublicKey_tfheb(querier), res)
+		dec = querier
+	}
 	hitsymbol := false
 	for i := 0; i < len(res); i++ {
-		if enc.DecryptLWEBool(res[i]) {
+		if dec.DecryptLWEBool(res[i]) {
 			fmt.Println(strconv.Itoa(i), " Hit!")
 			hitsymbol = true
 		}
@@ -124,9 +110,10 @@ func main() {
 	toy := flag.Bool("toy", true, "Whether using Toy Parameters")
 	GroundTruthID := flag.Int("groundtruth", 0, "GroundTruthID in 1kGP")
 	readsymbol := flag.Bool("read", false, "Whether read Data from file, not suitable for toy params")
+	queriersymbol := flag.Bool("querier", false, "Whether the keys are generated by the key share holders of ThFHE, and the result is re-encrypted to a key of the querier, implies -read")
 	verifysymbol := flag.Bool("verify", false, "Whether verifying the proofs")
-	Hosted := flag.Bool("precomputed", false, "Whether owner choose to precompute the access token")
 	flag.Parse()
+	trivium.RecoverRotations()
 
 	var query_target applications.CODIS
 	if *GroundTruthID >= 0 && *GroundTruthID < 2504 {
@@ -136,9 +123,9 @@ func main() {
 	}
 
 	if *toy {
-		Boolean_Search(query_target, auxiliary.ParamsToyBoolean, *readsymbol, *verifysymbol, *Hosted)
+		Boolean_Search(query_target, auxiliary.ParamsToyBoolean, *readsymbol, *queriersymbol, *verifysymbol)
 	} else {
-		Boolean_Search(query_target, tfhe.ParamsBinaryOriginal, *readsymbol, *verifysymbol, *Hosted)
+		Boolean_Search(query_target, tfhe.ParamsBinaryOriginal, *readsymbol, *queriersymbol, *verifysymbol)
 	}
 
 }
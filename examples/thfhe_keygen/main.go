@@ -0,0 +1,102 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	thfhe "Governome/ThFHE"
+	"Governome/auxiliary"
+	"Governome/streamcipher/trivium"
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+func main() {
+
+	toy := flag.Bool("toy", true, "Whether using Toy Parameters")
+	parties := flag.Int("parties", 3, "Number of parties generating the keys together")
+	id := flag.Int("id", 0, "ID (1, 2, ...) of this party, 0 to simulate all parties in this process")
+	addrlist := flag.String("addrs", "", "Comma separated addresses of the parties by ID (127.0.0.1:7001, 127.0.0.1:7002, ... if not set)")
+	session := flag.String("session", "", "Label of this run agreed by all parties, fresh for every run")
+	Path := flag.String("path", "../../..", "Root FilePath")
+
+	flag.Parse()
+
+	auxiliary.SavePath(*Path)
+
+	params := tfhe.ParamsBinaryOriginal.Compile()
+	if *toy {
+		params = auxiliary.ParamsToyBoolean.Compile()
+	}
+
+	if *id == 0 {
+		shares, keys := thfhe.SimulateGenKeys(params, *parties)
+		for _, share := range shares {
+			thfhe.SaveKeyShare(share, thfhe.KeySharePassphrase(share.ID))
+		}
+		trivium.Save_PK(keys.PublicKey)
+		thfhe.SaveEvaluationKey(keys.EvaluationKey, params)
+		log.Printf("Generated the keys of %d simulated parties", *parties)
+		return
+	}
+
+	addrs := strings.Split(*addrlist, ",")
+	if *addrlist == "" {
+		addrs = make([]string, *parties)
+		for k := range addrs {
+			addrs[k] = "127.0.0.1:" + strconv.Itoa(7001+k)
+		}
+	}
+	if len(addrs) != *parties {
+		log.Fatalf("Got %d addresses for %d parties", len(addrs), *parties)
+	}
+	if *id < 1 || *id > *parties {
+		log.Fatalf("Invalid party %d, it must be from 1 to %d", *id, *parties)
+	}
+
+	passphrase := thfhe.KeySharePassphrase(*id)
+	t, err := thfhe.NewSocketTransport(*id, addrs, thfhe.SessionID(*session), thfhe.TransportPSK())
+	if err != nil {
+		log.Fatalf("can not start the transport, err is %+v", err)
+	}
+	share, keys, err := thfhe.GenKeys(params, *id, *parties, t)
+	t.Close()
+	if err != nil {
+		log.Fatalf("can not generate the keys, err is %+v", err)
+	}
+
+	thfhe.SaveKeyShare(share, passphrase)
+	// the joint keys are the same for all parties, the first one saves them
+	if *id == 1 {
+		trivium.Save_PK(keys.PublicKey)
+		thfhe.SaveEvaluationKey(keys.EvaluationKey, params)
+	}
+	log.Printf("Party %d of %d generated its key share", *id, *parties)
+}
@@ -27,12 +27,15 @@
 package main
 
 import (
+	"Governome/applications"
 	"Governome/auxiliary"
 	"Governome/snarks"
+	"Governome/streamcipher/trivium"
 	"flag"
+	"log"
 )
 
-func GenProof(rsid string, user_name string, keyholderID int, option bool) {
+func GenProof(rsid string, user_name string, keyholderID int) {
 	Indivs := auxiliary.ReadIndividuals()
 	var people auxiliary.People
 	for i := 0; i < len(Indivs); i++ {
@@ -41,43 +44,51 @@ func GenProof(rsid string, user_name string, keyholderID int, option bool) {
 			break
 		}
 	}
-	snarks.UserProof(false, people, keyholderID, auxiliary.SegmentID(people, auxiliary.RsID_s2i(rsid), auxiliary.Seg_num), option)
+	snarks.UserProof(false, people, keyholderID, auxiliary.SegmentID(people, auxiliary.VariantKey_s2i(rsid), auxiliary.ReadSegParams().Seg_num))
 }
 
-func GenAllProofForRSID(rsid string, begin, end int, option bool) {
+func GenAllProofForRSID(rsid string, begin, end int) {
 	Indiv := auxiliary.ReadIndividuals()
 	for i := begin; i < end; i++ {
-		snarks.UserProof(true, Indiv[i], 1, auxiliary.SegmentID(Indiv[i], auxiliary.RsID_s2i(rsid), auxiliary.Seg_num), option)
-		snarks.UserProof(true, Indiv[i], 2, auxiliary.SegmentID(Indiv[i], auxiliary.RsID_s2i(rsid), auxiliary.Seg_num), option)
+		for k := 1; k <= auxiliary.ReadKeySharing().Holders; k++ {
+			snarks.UserProof(true, Indiv[i], k, auxiliary.SegmentID(Indiv[i], auxiliary.VariantKey_s2i(rsid), auxiliary.ReadSegParams().Seg_num))
+		}
 	}
 }
 
-func GenAllProofForSpecificAPPID(appid int, begin, end int, option bool) {
+func GenAllProofForSpecificAPPID(appid int, begin, end int) {
 	Indiv := auxiliary.ReadIndividuals()
 	for i := begin; i < end; i++ {
-		snarks.UserProof(true, Indiv[i], 1, appid, option)
-		snarks.UserProof(true, Indiv[i], 2, appid, option)
+		for k := 1; k <= auxiliary.ReadKeySharing().Holders; k++ {
+			snarks.UserProof(true, Indiv[i], k, appid)
+		}
 	}
 }
 
 func main() {
-	rsid := flag.String("rsid", "rs6053810", "Target Site in rsID")
+	rsid := flag.String("rsid", "rs6053810", "Target Site in rsID or chrom:pos:ref:alt")
 	Username := flag.String("user", "HG00096", "User Name in 1kGP")
-	keyholderID := flag.Int("id", 1, "1 or 2, 1 for owners, 2 for hospitals")
+	keyholderID := flag.Int("id", 1, "Key holder 1, 2, ..., 1 for owners, 2 for hospitals")
 	appid := flag.Int("segID", -1, "AppID or SegID for all individual")
+	appname := flag.String("app", "", "Registered application (SearchPerson, GWAS) for all individual, instead of -segID")
 	begin := flag.Int("begin", 0, "Begin ID when generate all")
 	end := flag.Int("end", 2504, "Begin ID when generate all")
 	Genall := flag.Bool("all", false, "Whether include all Individuals")
-	Hosted := flag.Bool("precomputed", false, "Whether owner choose to precompute the access token")
 
 	flag.Parse()
+	trivium.RecoverRotations()
 	if *Genall {
-		if *appid > 0 {
-			GenAllProofForSpecificAPPID(*appid%auxiliary.Seg_num, *begin, *end, *Hosted)
+		if *appname != "" {
+			GenAllProofForSpecificAPPID(applications.AppID(*appname), *begin, *end)
+		} else if *appid >= 0 {
+			if *appid >= auxiliary.ReadSegParams().Seg_num && !applications.IsAppID(*appid) {
+				log.Fatalf("%d is neither a segment ID of the dataset nor a registered AppID", *appid)
+			}
+			GenAllProofForSpecificAPPID(*appid, *begin, *end)
 		} else {
-			GenAllProofForRSID(*rsid, *begin, *end, *Hosted)
+			GenAllProofForRSID(*rsid, *begin, *end)
 		}
 	} else {
-		GenProof(*rsid, *Username, *keyholderID, *Hosted)
+		GenProof(*rsid, *Username, *keyholderID)
 	}
 }
@@ -109,11 +109,12 @@ func EncStreamWithPublicKeyWithProveTFHE_Hosted(keyinfo []byte,
 
 	triv_params := tfhe.ParamsBinaryOriginal.Compile()
 	pk := trivium.ReadPK(triv_params)
-	batchnum := int(math.Ceil(float64(80 / Block_Size)))
+	key_bits := auxiliary.ReadCipher().KeyBits()
+	batchnum := int(math.Ceil(float64(key_bits / Block_Size)))
 	assignment := make([]HostedCircuit, batchnum)
 
-	seg_key, kq := trivium.GenKeyHostedModeWithQuo(keyinfo, Block_Size)
-	seg_key_ct := make([]tfhe.LWECiphertext[uint32], 80)
+	seg_key, kq := trivium.GenKeyHostedModeWithQuo(keyinfo, key_bits, Block_Size)
+	seg_key_ct := make([]tfhe.LWECiphertext[uint32], key_bits)
 	expecthash, _ := auxiliary.MimcHashRaw(keyinfo, auxiliary.Mimchashcurve)
 
 	for k := 0; k < batchnum; k++ {
@@ -121,7 +122,7 @@ func EncStreamWithPublicKeyWithProveTFHE_Hosted(keyinfo []byte,
 		assignment[k].Secret_KeyInfo = keyinfo
 		assignment[k].Secret_KeyQuo = kq[k]
 		assignment[k].ExpectedHash = expecthash
-		for i := 0; i < Block_Size && k*Block_Size+i < 80; i++ {
+		for i := 0; i < Block_Size && k*Block_Size+i < key_bits; i++ {
 			ct, spi := auxiliary.EncWithPublicKeyForZKSnarks_tfheb(uint32(seg_key[k*Block_Size+i]), pk)
 			assignment[k].Secret_TriviumKey[i] = seg_key[k*Block_Size+i]
 			assignment[k].Secret_Error0[i] = int32(spi.E0)
@@ -151,7 +152,8 @@ func EncStreamWithPublicKeyWithProveTFHE_Hosted(keyinfo []byte,
 
 // Reconstruct the publicWitness With the SegKey ciphertext and its hash in hosted mode
 func ConstructpublicWitnessWithSegKeyHosted(keyhash []byte, seg_key_ct []tfhe.LWECiphertext[uint32]) []witness.Witness {
-	batchnum := int(math.Ceil(float64(80 / Block_Size)))
+	key_bits := len(seg_key_ct)
+	batchnum := int(math.Ceil(float64(key_bits / Block_Size)))
 	assignment := make([]HostedCircuit, batchnum)
 
 	for k := 0; k < batchnum; k++ {
@@ -159,7 +161,7 @@ func ConstructpublicWitnessWithSegKeyHosted(keyhash []byte, seg_key_ct []tfhe.LW
 		assignment[k].Secret_KeyInfo = 0
 		assignment[k].Secret_KeyQuo = 0
 		assignment[k].ExpectedHash = keyhash
-		for i := 0; i < Block_Size && k*Block_Size+i < 80; i++ {
+		for i := 0; i < Block_Size && k*Block_Size+i < key_bits; i++ {
 			assignment[k].Secret_TriviumKey[i] = 0
 			assignment[k].Secret_Error0[i] = 0
 			for j := 0; j < RingSize_Boolean; j++ {
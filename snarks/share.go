@@ -0,0 +1,248 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package snarks
+
+import (
+	"Governome/applications"
+	"Governome/auxiliary"
+	"Governome/streamcipher/trivium"
+	"math/big"
+	"math/bits"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Proves that Ct encrypts a bit of byte Byte_ID of the key share of a key holder in threshold mode for a segment or application,
+// see trivium.ThresholdShare: the values of its keyinfo have the MiMC hash ExpectedHash, byte Byte_ID of value c is the low
+// byte of its hash for the segment or application, and the bit is the XOR of these bytes over Row, the row of the bit in
+// the bit matrices of the coefficients f_T(k) of the key holder, which the verifier computes itself
+type ShareCircuit struct {
+	Secret_KeyInfo    []frontend.Variable                                 `gnark:"kif"`
+	Secret_Byte       [][8]frontend.Variable                              `gnark:"byte"`
+	Secret_ByteQuo    []frontend.Variable                                 `gnark:"bq"`
+	Secret_TriviumKey [Block_Size]frontend.Variable                       `gnark:"sd"`
+	Secret_temp_Key   [Block_Size][RingSize_Boolean]frontend.Variable     `gnark:"tsk"`
+	Secret_Error0     [Block_Size]frontend.Variable                       `gnark:"ea"`
+	Secret_Error1     [Block_Size][RingSize_Boolean]frontend.Variable     `gnark:"eb"`
+	Secret_Quo        [Block_Size][RingSize_Boolean + 1]frontend.Variable `gnark:"quo"`
+	// tag of the application, 0 for a segment, and the segment ID or the index of the application, see applications/registry.go
+	App_Tag      frontend.Variable                                   `gnark:",public"`
+	Seg_ID       frontend.Variable                                   `gnark:",public"`
+	Byte_ID      frontend.Variable                                   `gnark:",public"`
+	Row          [][8]frontend.Variable                              `gnark:",public"`
+	ExpectedHash frontend.Variable                                   `gnark:",public"`
+	Ct           [Block_Size][RingSize_Boolean + 1]frontend.Variable `gnark:",public"`
+	Key_Bits     int                                                 `gnark:"-"`
+}
+
+// A ShareCircuit for the key holders of a key sharing, they all have HolderValues values
+func NewShareCircuit(sharing auxiliary.KeySharing, key_bits int) *ShareCircuit {
+	values := sharing.HolderValues()
+	return &ShareCircuit{
+		Secret_KeyInfo: make([]frontend.Variable, values),
+		Secret_Byte:    make([][8]frontend.Variable, values),
+		Secret_ByteQuo: make([]frontend.Variable, values),
+		Row:            make([][8]frontend.Variable, values),
+		Key_Bits:       key_bits,
+	}
+}
+
+// Byte byteid of value kif is the low byte of mimc(kif, segID * key_bits + byteid + 1), or mimc(kif, tag, index * key_bits + byteid + 1)
+// for an application as Boolean_CheckKey, the key bit is the XOR of the bits of the bytes of all values over the rows
+func Share_CheckKey(api frontend.API, kif []frontend.Variable, tag, appid, byteid frontend.Variable, bytes [][8]frontend.Variable,
+	bq []frontend.Variable, row [][8]frontend.Variable, key frontend.Variable, key_bits int) {
+	field, _ := big.NewInt(1).SetString("21888242871839275222246405745257275088548364400416034343698204186575808495617", 0)
+	bq_bound := big.NewInt(1).Rsh(field, trivium.Threshold_Batch)
+
+	appval := api.Add(api.Mul(appid, key_bits), api.Add(byteid, 1))
+
+	var sum frontend.Variable = 0
+	for c := range kif {
+		mimc1, _ := mimc.NewMiMC(api)
+		mimc1.Write(kif[c], appval)
+		mimc2, _ := mimc.NewMiMC(api)
+		mimc2.Write(kif[c], tag, appval)
+		rawbyte := api.Select(api.IsZero(tag), mimc1.Sum(), mimc2.Sum())
+
+		val := api.Mul(bq[c], 1<<trivium.Threshold_Batch)
+		for col := 0; col < 8; col++ {
+			api.AssertIsBoolean(bytes[c][col])
+			api.AssertIsBoolean(row[c][col])
+			val = api.Add(val, api.Mul(bytes[c][col], 1<<col))
+			sum = api.Add(sum, api.Mul(row[c][col], bytes[c][col]))
+		}
+		api.AssertIsLessOrEqual(bq[c], bq_bound)
+		api.AssertIsEqual(rawbyte, val)
+	}
+	api.AssertIsEqual(key, api.ToBinary(sum, bits.Len(uint(8*len(kif))))[0])
+}
+
+func (circuit *ShareCircuit) Define(api frontend.API) error {
+	for i := 0; i < Block_Size; i++ {
+		Boolean_CheckLWEError(api, circuit.Secret_Error0[i])
+		api.AssertIsBoolean(circuit.Secret_TriviumKey[i])
+		for j := 0; j < RingSize_Boolean; j++ {
+			Boolean_CheckRLWEError(api, circuit.Secret_Error1[i][j])
+			api.AssertIsBoolean(circuit.Secret_temp_Key[i][j])
+		}
+		for j := 0; j < RingSize_Boolean+1; j++ {
+			Boolean_CheckQuo(api, circuit.Secret_Quo[i][j])
+		}
+	}
+	hash, _ := mimc.NewMiMC(api)
+	hash.Write(circuit.Secret_KeyInfo...)
+	api.AssertIsEqual(hash.Sum(), circuit.ExpectedHash)
+	Share_CheckKey(api, circuit.Secret_KeyInfo, circuit.App_Tag, circuit.Seg_ID, circuit.Byte_ID, circuit.Secret_Byte,
+		circuit.Secret_ByteQuo, circuit.Row, circuit.Secret_TriviumKey[0], circuit.Key_Bits)
+
+	for i := 0; i < Block_Size; i++ {
+		New_ct := Boolean_EncTFHE(api, circuit.Secret_TriviumKey[i], circuit.Secret_Error0[i], circuit.Secret_temp_Key[i],
+			circuit.Secret_Error1[i], circuit.Secret_Quo[i])
+		for j := 0; j < RingSize_Boolean+1; j++ {
+			api.AssertIsEqual(New_ct[j], circuit.Ct[i][j])
+		}
+	}
+	return nil
+}
+
+// Assign the public inputs of key bit i of the share of a key holder for a segment or application ID, the secret ones are 0
+func shareAssignment(sharing auxiliary.KeySharing, keyholder int, appid int, keyhash []byte, i int) ShareCircuit {
+	tag, index := applications.KeyDomain(appid)
+	sets := trivium.ThresholdSets(sharing, keyholder)
+	assignment := ShareCircuit{
+		Secret_KeyInfo: make([]frontend.Variable, len(sets)),
+		Secret_Byte:    make([][8]frontend.Variable, len(sets)),
+		Secret_ByteQuo: make([]frontend.Variable, len(sets)),
+		Row:            make([][8]frontend.Variable, len(sets)),
+		App_Tag:        big.NewInt(1).SetBytes(tag),
+		Seg_ID:         index,
+		Byte_ID:        i / 8,
+		ExpectedHash:   keyhash,
+	}
+	for c, set := range sets {
+		M := auxiliary.GF256_BitMatrix(auxiliary.ShamirSetPoly(set, keyholder))
+		assignment.Secret_KeyInfo[c] = 0
+		assignment.Secret_ByteQuo[c] = 0
+		for col := 0; col < 8; col++ {
+			assignment.Secret_Byte[c][col] = 0
+			assignment.Row[c][col] = M[i%8][col]
+		}
+	}
+	assignment.Secret_TriviumKey[0] = 0
+	assignment.Secret_Error0[0] = 0
+	for j := 0; j < RingSize_Boolean; j++ {
+		assignment.Secret_temp_Key[0][j] = 0
+		assignment.Secret_Error1[0][j] = 0
+	}
+	for j := 0; j < RingSize_Boolean+1; j++ {
+		assignment.Secret_Quo[0][j] = 0
+		assignment.Ct[0][j] = 0
+	}
+	return assignment
+}
+
+func EncStreamWithPublicKeyWithProveTFHE_Share(keyinfo []byte, keyholder int, appid int,
+	ccs *constraint.ConstraintSystem, proveKey groth16.ProvingKey) ([]tfhe.LWECiphertext[uint32], []groth16.Proof, []witness.Witness) {
+
+	triv_params := tfhe.ParamsBinaryOriginal.Compile()
+	pk := trivium.ReadPK(triv_params)
+	sharing := auxiliary.ReadKeySharing()
+	key_bits := auxiliary.ReadCipher().KeyBits()
+	assignment := make([]ShareCircuit, key_bits)
+
+	seg_key := trivium.ThresholdShare(sharing, keyholder, keyinfo, appid, key_bits)
+	seg_key_ct := make([]tfhe.LWECiphertext[uint32], key_bits)
+	expecthash, _ := auxiliary.MimcHashRaw(keyinfo, auxiliary.Mimchashcurve)
+
+	values := trivium.ThresholdValues(keyinfo)
+	bytes := make([][]int, len(values))
+	quos := make([][]*big.Int, len(values))
+	for c, value := range values {
+		bytes[c], quos[c] = trivium.GenSegmentKeyWithQuo(value, appid, key_bits, trivium.Threshold_Batch)
+	}
+
+	for i := 0; i < key_bits; i++ {
+		assignment[i] = shareAssignment(sharing, keyholder, appid, expecthash, i)
+		j := i / 8
+		for c, value := range values {
+			assignment[i].Secret_KeyInfo[c] = value
+			assignment[i].Secret_ByteQuo[c] = quos[c][j]
+			for col := 0; col < 8; col++ {
+				if 8*j+col < key_bits {
+					assignment[i].Secret_Byte[c][col] = bytes[c][8*j+col]
+				}
+			}
+		}
+
+		ct, spi := auxiliary.EncWithPublicKeyForZKSnarks_tfheb(uint32(seg_key[i]), pk)
+		assignment[i].Secret_TriviumKey[0] = seg_key[i]
+		assignment[i].Secret_Error0[0] = int32(spi.E0)
+		for j := 0; j < RingSize_Boolean; j++ {
+			assignment[i].Secret_temp_Key[0][j] = spi.TSK[j]
+			assignment[i].Secret_Error1[0][j] = int32(spi.E1[j])
+		}
+		for j := 0; j < RingSize_Boolean+1; j++ {
+			assignment[i].Secret_Quo[0][j] = spi.Quo[j]
+			assignment[i].Ct[0][j] = ct.Value[j]
+		}
+		seg_key_ct[i] = ct
+	}
+
+	proof := make([]groth16.Proof, key_bits)
+	publicWitness := make([]witness.Witness, key_bits)
+
+	for k := 0; k < key_bits; k++ {
+		witness, _ := frontend.NewWitness(&assignment[k], ecc.BN254.ScalarField())
+		publicWitness[k], _ = witness.Public()
+		proof[k], _ = groth16.Prove(*ccs, proveKey, witness)
+	}
+
+	return seg_key_ct, proof, publicWitness
+}
+
+// Reconstruct the publicWitness With the SegKey ciphertext of a key holder for a segment or application ID and its hash in threshold mode
+func ConstructpublicWitnessWithSegKeyShare(sharing auxiliary.KeySharing, keyholder int, appid int, keyhash []byte,
+	seg_key_ct []tfhe.LWECiphertext[uint32]) []witness.Witness {
+	publicWitness := make([]witness.Witness, len(seg_key_ct))
+
+	for i := range seg_key_ct {
+		assignment := shareAssignment(sharing, keyholder, appid, keyhash, i)
+		for j := 0; j < RingSize_Boolean+1; j++ {
+			assignment.Ct[0][j] = seg_key_ct[i].Value[j]
+		}
+		witness, _ := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
+		publicWitness[i], _ = witness.Public()
+	}
+
+	return publicWitness
+}
@@ -27,6 +27,7 @@
 package snarks
 
 import (
+	"Governome/applications"
 	"Governome/auxiliary"
 	"Governome/streamcipher/trivium"
 	"math"
@@ -58,10 +59,14 @@ type DefaultCircuit struct {
 	Secret_Error0     [Block_Size]frontend.Variable                       `gnark:"ea"`
 	Secret_Error1     [Block_Size][RingSize_Boolean]frontend.Variable     `gnark:"eb"`
 	Secret_Quo        [Block_Size][RingSize_Boolean + 1]frontend.Variable `gnark:"quo"`
+	// tag of the application, 0 for a segment, and the segment ID or the index of the application, see applications/registry.go
+	App_Tag           frontend.Variable                                   `gnark:",public"`
 	Seg_ID            frontend.Variable                                   `gnark:",public"`
 	Batch_ID          frontend.Variable                                   `gnark:",public"`
 	ExpectedHash      frontend.Variable                                   `gnark:",public"`
 	Ct                [Block_Size][RingSize_Boolean + 1]frontend.Variable `gnark:",public"`
+	// key length of the stream cipher, see auxiliary.Cipher
+	Key_Bits          int                                                 `gnark:"-"`
 }
 
 func Boolean_Scale(api frontend.API, val frontend.Variable) frontend.Variable {
@@ -109,15 +114,18 @@ func Boolean_CheckHash(api frontend.API, data frontend.Variable, expecthash fron
 	api.AssertIsEqual(hash_result, expecthash)
 }
 
-func Boolean_CheckKey(api frontend.API, kif, appid, batchid, kq frontend.Variable, key [Block_Size]frontend.Variable) {
+// The key is mimc(kif, segID * key_bits + batchid + 1) for a segment, and mimc(kif, tag, index * key_bits + batchid + 1) for an application
+func Boolean_CheckKey(api frontend.API, kif, tag, appid, batchid, kq frontend.Variable, key [Block_Size]frontend.Variable, key_bits int) {
 	field, _ := big.NewInt(1).SetString("21888242871839275222246405745257275088548364400416034343698204186575808495617", 0)
 	modulus := big.NewInt(1).Lsh(big.NewInt(1), Block_Size)
 	kq_bound := big.NewInt(1).Rsh(field, Block_Size)
 
+	appval := api.Add(api.Mul(appid, key_bits), api.Add(batchid, 1))
 	mimc1, _ := mimc.NewMiMC(api)
-	appval := api.Add(api.Mul(appid, 80), api.Add(batchid, 1))
 	mimc1.Write(kif, appval)
-	rawkey := mimc1.Sum()
+	mimc2, _ := mimc.NewMiMC(api)
+	mimc2.Write(kif, tag, appval)
+	rawkey := api.Select(api.IsZero(tag), mimc1.Sum(), mimc2.Sum())
 
 	val := api.Mul(kq, modulus)
 
@@ -161,7 +169,7 @@ func (circuit *DefaultCircuit) Define(api frontend.API) error {
 		}
 	}
 	Boolean_CheckHash(api, circuit.Secret_KeyInfo, circuit.ExpectedHash)
-	Boolean_CheckKey(api, circuit.Secret_KeyInfo, circuit.Seg_ID, circuit.Batch_ID, circuit.Secret_KeyQuo, circuit.Secret_TriviumKey)
+	Boolean_CheckKey(api, circuit.Secret_KeyInfo, circuit.App_Tag, circuit.Seg_ID, circuit.Batch_ID, circuit.Secret_KeyQuo, circuit.Secret_TriviumKey, circuit.Key_Bits)
 
 	for i := 0; i < Block_Size; i++ {
 		New_ct := Boolean_EncTFHE(api, circuit.Secret_TriviumKey[i], circuit.Secret_Error0[i], circuit.Secret_temp_Key[i],
@@ -177,20 +185,23 @@ func EncStreamWithPublicKeyWithProveTFHE_Boolean(keyinfo []byte, appid int,
 	ccs *constraint.ConstraintSystem, proveKey groth16.ProvingKey) ([]tfhe.LWECiphertext[uint32], []groth16.Proof, []witness.Witness) {
 	triv_params := tfhe.ParamsBinaryOriginal.Compile()
 	pk := trivium.ReadPK(triv_params)
-	batchnum := int(math.Ceil(float64(80 / Block_Size)))
+	key_bits := auxiliary.ReadCipher().KeyBits()
+	batchnum := int(math.Ceil(float64(key_bits / Block_Size)))
 	assignment := make([]DefaultCircuit, batchnum)
 
-	seg_key, kq := trivium.GenSegmentKeyWithQuo(keyinfo, appid, Block_Size)
-	seg_key_ct := make([]tfhe.LWECiphertext[uint32], 80)
+	seg_key, kq := trivium.GenSegmentKeyWithQuo(keyinfo, appid, key_bits, Block_Size)
+	seg_key_ct := make([]tfhe.LWECiphertext[uint32], key_bits)
 	expecthash, _ := auxiliary.MimcHashRaw(keyinfo, auxiliary.Mimchashcurve)
+	tag, index := applications.KeyDomain(appid)
 
 	for k := 0; k < batchnum; k++ {
 		assignment[k].Batch_ID = k
 		assignment[k].Secret_KeyInfo = keyinfo
 		assignment[k].Secret_KeyQuo = kq[k]
 		assignment[k].ExpectedHash = expecthash
-		assignment[k].Seg_ID = appid
-		for i := 0; i < Block_Size && k*Block_Size+i < 80; i++ {
+		assignment[k].App_Tag = big.NewInt(1).SetBytes(tag)
+		assignment[k].Seg_ID = index
+		for i := 0; i < Block_Size && k*Block_Size+i < key_bits; i++ {
 			ct, spi := auxiliary.EncWithPublicKeyForZKSnarks_tfheb(uint32(seg_key[k*Block_Size+i]), pk)
 			assignment[k].Secret_TriviumKey[i] = seg_key[k*Block_Size+i]
 			assignment[k].Secret_Error0[i] = int32(spi.E0)
@@ -220,16 +231,19 @@ func EncStreamWithPublicKeyWithProveTFHE_Boolean(keyinfo []byte, appid int,
 
 // Reconstruct the publicWitness With the SegKey ciphertext and its hash
 func ConstructpublicWitnessWithSegKeyDefault(appid int, keyhash []byte, seg_key_ct []tfhe.LWECiphertext[uint32]) []witness.Witness {
-	batchnum := int(math.Ceil(float64(80 / Block_Size)))
+	key_bits := len(seg_key_ct)
+	batchnum := int(math.Ceil(float64(key_bits / Block_Size)))
 	assignment := make([]DefaultCircuit, batchnum)
+	tag, index := applications.KeyDomain(appid)
 
 	for k := 0; k < batchnum; k++ {
 		assignment[k].Batch_ID = k
 		assignment[k].Secret_KeyInfo = 0
 		assignment[k].Secret_KeyQuo = 0
 		assignment[k].ExpectedHash = keyhash
-		assignment[k].Seg_ID = appid
-		for i := 0; i < Block_Size && k*Block_Size+i < 80; i++ {
+		assignment[k].App_Tag = big.NewInt(1).SetBytes(tag)
+		assignment[k].Seg_ID = index
+		for i := 0; i < Block_Size && k*Block_Size+i < key_bits; i++ {
 			assignment[k].Secret_TriviumKey[i] = 0
 			assignment[k].Secret_Error0[i] = 0
 			for j := 0; j < RingSize_Boolean; j++ {
@@ -45,14 +45,64 @@ import (
 	"github.com/sp301415/tfhe-go/tfhe"
 )
 
-// Save R1CS circuit
-func SaveR1CS(ccs constraint.ConstraintSystem, option bool) {
-	dicpath := auxiliary.ReadPath()
-	file_path := dicpath + "/Snarks/R1CSTrivium/R1CS"
+// Kind of the circuit that proves the key share of a key holder
+type Circuit_Kind int
+
+const (
+	Circuit_Default Circuit_Kind = iota // share of a segment or an application, DefaultCircuit
+	Circuit_Hosted                      // share for all segments, HostedCircuit
+	Circuit_Share                       // share of a segment or an application in threshold mode, ShareCircuit
+)
+
+// The circuit of the key share of a key holder, by its key mode in n-of-n sharing
+// a FiLIP key share is only proven in hosted mode, once for all segments: every key bit is a TFHE ciphertext and
+// a Groth16 proof, 16384 of them, and a share of a segment would cost them for every key holder and segment
+func CircuitKindOf(sharing auxiliary.KeySharing, modes auxiliary.KeyModes, keyholder int) Circuit_Kind {
+	kind := Circuit_Share
+	if !sharing.IsThreshold() {
+		switch modes[keyholder-1] {
+		case auxiliary.KeyMode_Segment:
+			kind = Circuit_Default
+		case auxiliary.KeyMode_Hosted:
+			kind = Circuit_Hosted
+		default:
+			log.Fatalf("No circuit for key mode %s", modes[keyholder-1])
+		}
+	}
+	if c := auxiliary.ReadCipher(); c == auxiliary.Cipher_FiLIP && kind != Circuit_Hosted {
+		log.Fatalf("A FiLIP key share of a segment has %d bits, each a TFHE ciphertext and a Groth16 proof for every segment, "+
+			"only hosted key shares of FiLIP are proven, choose Trivium or Kreyvium to prove the keys of segments", c.KeyBits())
+	}
+	return kind
+}
 
-	if option {
-		file_path = file_path + "_Hosted"
+// Suffix of the files of a circuit
+func (kind Circuit_Kind) suffix() string {
+	suffix := ""
+	switch kind {
+	case Circuit_Default:
+		// the default circuit takes the tag of an application since version 2, the files of the older circuit are not read
+		suffix = "_v2"
+	case Circuit_Hosted:
+		suffix = "_Hosted"
+	case Circuit_Share:
+		// the share circuit takes a value per set of the pseudo-random secret sharing since version 2, see NewShareCircuit
+		suffix = "_Share_v2_" + strconv.Itoa(auxiliary.ReadKeySharing().HolderValues())
 	}
+	// circuits of other ciphers differ in key length, keep them apart from the Trivium files
+	switch auxiliary.ReadCipher() {
+	case auxiliary.Cipher_Kreyvium:
+		suffix += "_Kreyvium"
+	case auxiliary.Cipher_FiLIP:
+		suffix += "_FiLIP"
+	}
+	return suffix
+}
+
+// Save R1CS circuit
+func SaveR1CS(ccs constraint.ConstraintSystem, kind Circuit_Kind) {
+	dicpath := auxiliary.ReadPath()
+	file_path := dicpath + "/Snarks/R1CSTrivium/R1CS" + kind.suffix()
 
 	_, err := os.Stat(file_path)
 	if os.IsNotExist(err) {
@@ -65,32 +115,27 @@ func SaveR1CS(ccs constraint.ConstraintSystem, option bool) {
 }
 
 // Generate or Read R1CS circuit
-func GenorReadR1CS(WhetherSave, option bool) (ccs constraint.ConstraintSystem) {
+func GenorReadR1CS(WhetherSave bool, kind Circuit_Kind) (ccs constraint.ConstraintSystem) {
 	dicpath := auxiliary.ReadPath()
-	file_path := dicpath + "/Snarks/R1CSTrivium/R1CS"
-
-	if option {
-		file_path = file_path + "_Hosted"
-	}
+	file_path := dicpath + "/Snarks/R1CSTrivium/R1CS" + kind.suffix()
 
 	_, err := os.Stat(file_path)
 
 	if os.IsNotExist(err) {
-		if option {
-			var circuit HostedCircuit
-			ccs, _ = frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
-			if WhetherSave {
-				SaveR1CS(ccs, option)
-			}
-			return
-		} else {
-			var circuit DefaultCircuit
-			ccs, _ = frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
-			if WhetherSave {
-				SaveR1CS(ccs, option)
-			}
-			return
+		var circuit frontend.Circuit
+		switch kind {
+		case Circuit_Hosted:
+			circuit = &HostedCircuit{}
+		case Circuit_Share:
+			circuit = NewShareCircuit(auxiliary.ReadKeySharing(), auxiliary.ReadCipher().KeyBits())
+		default:
+			circuit = &DefaultCircuit{Key_Bits: auxiliary.ReadCipher().KeyBits()}
+		}
+		ccs, _ = frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, circuit)
+		if WhetherSave {
+			SaveR1CS(ccs, kind)
 		}
+		return
 	} else {
 		var buf bytes.Buffer
 		data, _ := os.ReadFile(file_path)
@@ -102,15 +147,11 @@ func GenorReadR1CS(WhetherSave, option bool) (ccs constraint.ConstraintSystem) {
 }
 
 // Save Setup Keys
-func SaveSetupKeys(ProveKey groth16.ProvingKey, VerifyKey groth16.VerifyingKey, option bool) {
+func SaveSetupKeys(ProveKey groth16.ProvingKey, VerifyKey groth16.VerifyingKey, kind Circuit_Kind) {
 	dicpath := auxiliary.ReadPath()
 
-	filepathpk := dicpath + "/Snarks/SetupTrivium/pk"
-	filepathvk := dicpath + "/Snarks/SetupTrivium/vk"
-	if option {
-		filepathpk = filepathpk + "_Hosted"
-		filepathvk = filepathvk + "_Hosted"
-	}
+	filepathpk := dicpath + "/Snarks/SetupTrivium/pk" + kind.suffix()
+	filepathvk := dicpath + "/Snarks/SetupTrivium/vk" + kind.suffix()
 
 	_, errpk := os.Stat(filepathpk)
 	_, errvk := os.Stat(filepathvk)
@@ -133,15 +174,11 @@ func SaveSetupKeys(ProveKey groth16.ProvingKey, VerifyKey groth16.VerifyingKey,
 }
 
 // Generate or Read R1CS circuit
-func GenorReadSetup(r1cs constraint.ConstraintSystem, WhetherSave, option bool) (ProveKey groth16.ProvingKey, VerifyKey groth16.VerifyingKey) {
+func GenorReadSetup(r1cs constraint.ConstraintSystem, WhetherSave bool, kind Circuit_Kind) (ProveKey groth16.ProvingKey, VerifyKey groth16.VerifyingKey) {
 	dicpath := auxiliary.ReadPath()
 
-	filepathpk := dicpath + "/Snarks/SetupTrivium/pk"
-	filepathvk := dicpath + "/Snarks/SetupTrivium/vk"
-	if option {
-		filepathpk = filepathpk + "_Hosted"
-		filepathvk = filepathvk + "_Hosted"
-	}
+	filepathpk := dicpath + "/Snarks/SetupTrivium/pk" + kind.suffix()
+	filepathvk := dicpath + "/Snarks/SetupTrivium/vk" + kind.suffix()
 
 	_, errpk := os.Stat(filepathpk)
 	_, errvk := os.Stat(filepathvk)
@@ -149,7 +186,7 @@ func GenorReadSetup(r1cs constraint.ConstraintSystem, WhetherSave, option bool)
 	if os.IsNotExist(errpk) || os.IsNotExist(errvk) {
 		ProveKey, VerifyKey, _ = groth16.Setup(r1cs)
 		if WhetherSave {
-			SaveSetupKeys(ProveKey, VerifyKey, option)
+			SaveSetupKeys(ProveKey, VerifyKey, kind)
 		}
 		return
 	} else {
@@ -190,7 +227,7 @@ func ReadProof(people auxiliary.People, keyholder int, blocksize int) (proof []g
 
 	fullpath := dicpath + "/Snarks/ProofTrivium/" + auxiliary.MappingPeopletoFolder(people) + "/" + people.Name + "/Keyholder_" + strconv.Itoa(keyholder) + "/"
 
-	size := int(math.Ceil(80 / float64(blocksize)))
+	size := int(math.Ceil(float64(auxiliary.ReadCipher().KeyBits()) / float64(blocksize)))
 	proof = make([]groth16.Proof, size)
 
 	for k := 0; k < size; k++ {
@@ -233,9 +270,10 @@ func ReadSegKey(people auxiliary.People, keyholder int, params tfhe.Parameters[u
 	dicpath := auxiliary.ReadPath()
 	fullpath := dicpath + "/Snarks/SegKey/" + auxiliary.MappingPeopletoFolder(people) + "/" + people.Name + "/Keyholder_" + strconv.Itoa(keyholder) + "/"
 
-	segkey = make([]tfhe.LWECiphertext[uint32], 80)
+	key_bits := auxiliary.ReadCipher().KeyBits()
+	segkey = make([]tfhe.LWECiphertext[uint32], key_bits)
 
-	for k := 0; k < 80; k++ {
+	for k := 0; k < key_bits; k++ {
 
 		filepath := fullpath + "BitID_" + strconv.Itoa(k)
 		_, err := os.Stat(filepath)
@@ -247,46 +285,81 @@ func ReadSegKey(people auxiliary.People, keyholder int, params tfhe.Parameters[u
 			data, _ := os.ReadFile(filepath)
 			buf.Write(data)
 
-			for i := 0; i < 80; i++ {
-				segkey[i] = tfhe.NewLWECiphertext[uint32](params)
-				segkey[i].ReadFrom(&buf)
-			}
+			segkey[k] = tfhe.NewLWECiphertext[uint32](params)
+			segkey[k].ReadFrom(&buf)
 		}
 	}
 	return
 }
 
-func UserProof(WhetherSave bool, people auxiliary.People, keyholder int, segID int, option bool) {
+// Read the Segkey Ciphertexts of the key holders of an individual, in threshold mode the key holders without one are skipped
+func ReadSegKeys(people auxiliary.People, params tfhe.Parameters[uint32]) trivium.SegKeys {
+	sharing := auxiliary.ReadKeySharing()
+	res := trivium.NewSegKeys(sharing)
+	dicpath := auxiliary.ReadPath()
+	for k := 1; k <= sharing.Holders; k++ {
+		fullpath := dicpath + "/Snarks/SegKey/" + auxiliary.MappingPeopletoFolder(people) + "/" + people.Name + "/Keyholder_" + strconv.Itoa(k) + "/"
+		if _, err := os.Stat(fullpath); os.IsNotExist(err) && sharing.IsThreshold() {
+			continue
+		}
+		res.Keys[k] = ReadSegKey(people, k, params)
+	}
+	res.Stored = true
+	return res
+}
 
-	var ccs constraint.ConstraintSystem
-	if keyholder == 1 {
-		ccs = GenorReadR1CS(WhetherSave, option)
-	} else {
-		ccs = GenorReadR1CS(WhetherSave, !option)
+// Reconstruct the publicWitness of the SegKey ciphertext of key holder k in a circuit, appid is not used by Circuit_Hosted
+func ConstructpublicWitnessWithSegKey(kind Circuit_Kind, sharing auxiliary.KeySharing, keyholder int, appid int, keyhash []byte, seg_key_ct []tfhe.LWECiphertext[uint32]) []witness.Witness {
+	switch kind {
+	case Circuit_Hosted:
+		return ConstructpublicWitnessWithSegKeyHosted(keyhash, seg_key_ct)
+	case Circuit_Share:
+		return ConstructpublicWitnessWithSegKeyShare(sharing, keyholder, appid, keyhash, seg_key_ct)
+	}
+	return ConstructpublicWitnessWithSegKeyDefault(appid, keyhash, seg_key_ct)
+}
+
+// Verify the proofs of the SegKey ciphertexts of the key holders of an individual against its key hashes, a failure is fatal
+func VerifySegKeys(people auxiliary.People, appid int, segkeys trivium.SegKeys, keyhashes [][]byte) {
+	modes := auxiliary.ReadKeyModes()
+	VerifyKeys := make(map[Circuit_Kind]groth16.VerifyingKey)
+	for k, segkey := range segkeys.Keys {
+		kind := CircuitKindOf(segkeys.Sharing, modes, k)
+		if _, ok := VerifyKeys[kind]; !ok {
+			_, VerifyKeys[kind] = GenorReadSetup(GenorReadR1CS(false, kind), false, kind)
+		}
+		proof := ReadProof(people, k, Block_Size)
+		publicWitness := ConstructpublicWitnessWithSegKey(kind, segkeys.Sharing, k, appid, keyhashes[k-1], segkey)
+		for b := 0; b < len(proof); b++ {
+			err := groth16.Verify(proof[b], VerifyKeys[kind], publicWitness[b])
+			if err != nil {
+				log.Fatalf("Verification failed, err is %+v", err)
+			}
+		}
 	}
+}
 
-	ProveKey, VerifyKey := GenorReadSetup(ccs, WhetherSave, option)
+// Encrypt the key share of a key holder with the public key and prove it, the proofs are verified instead of saved without WhetherSave
+func UserProof(WhetherSave bool, people auxiliary.People, keyholder int, segID int) {
 
-	keyinfo, _ := trivium.GenerateRawKey(people, keyholder)
+	kind := CircuitKindOf(auxiliary.ReadKeySharing(), auxiliary.ReadKeyModes(), keyholder)
+	ccs := GenorReadR1CS(WhetherSave, kind)
+
+	ProveKey, VerifyKey := GenorReadSetup(ccs, WhetherSave, kind)
+
+	keyinfo, _ := trivium.LoadRawKey(people, keyholder)
 
 	var segkey []tfhe.LWECiphertext[uint32]
 	var proof []groth16.Proof
 	var publicWitness []witness.Witness
 
-	if option {
-		if keyholder == 1 {
-			segkey, proof, publicWitness = EncStreamWithPublicKeyWithProveTFHE_Hosted(keyinfo, &ccs, ProveKey)
-		} else {
-			segkey, proof, publicWitness = EncStreamWithPublicKeyWithProveTFHE_Boolean(keyinfo, segID, &ccs, ProveKey)
-		}
-
-	} else {
-		if keyholder == 1 {
-			segkey, proof, publicWitness = EncStreamWithPublicKeyWithProveTFHE_Boolean(keyinfo, segID, &ccs, ProveKey)
-		} else {
-			segkey, proof, publicWitness = EncStreamWithPublicKeyWithProveTFHE_Hosted(keyinfo, &ccs, ProveKey)
-		}
-
+	switch kind {
+	case Circuit_Hosted:
+		segkey, proof, publicWitness = EncStreamWithPublicKeyWithProveTFHE_Hosted(keyinfo, &ccs, ProveKey)
+	case Circuit_Share:
+		segkey, proof, publicWitness = EncStreamWithPublicKeyWithProveTFHE_Share(keyinfo, keyholder, segID, &ccs, ProveKey)
+	default:
+		segkey, proof, publicWitness = EncStreamWithPublicKeyWithProveTFHE_Boolean(keyinfo, segID, &ccs, ProveKey)
 	}
 
 	if WhetherSave {
@@ -29,9 +29,11 @@ package trivium
 import (
 	"Governome/applications"
 	"Governome/auxiliary"
+	"bytes"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"log"
 	"math/big"
 	"os"
 	"path/filepath"
@@ -83,7 +85,7 @@ func Enc_CODIS_Raw(c CODIS, params tfhe.Parameters[uint32]) (res CODIS_TFHE) {
 }
 
 // Decrypt a CODIS in TFHE ciphertext
-func Dec_CODIS(c CODIS_TFHE, enc *tfhe.BinaryEncryptor) (res CODIS) {
+func Dec_CODIS(c CODIS_TFHE, enc LWEBoolDecryptor) (res CODIS) {
 	for i := 0; i < 13; i++ {
 		for j := 0; j < 8; j++ {
 			if enc.DecryptLWEBool(c.Loci[i].Repeat1[j]) {
@@ -116,7 +118,7 @@ func Compare_CODIS_TFHE(cod1, cod2 CODIS_TFHE, eval *tfhe.BinaryEvaluator) tfhe.
 }
 
 // Decrypt Stream ciphertext with TFHE key
-func DecCODISCiphertextBySegKey(encrypted_data CODIS_TFHE, triv *Trivium_TFHE, eval *tfhe.BinaryEvaluator) (decrypted_data CODIS_TFHE) {
+func DecCODISCiphertextBySegKey(encrypted_data CODIS_TFHE, triv HomomorphicStreamCipher, eval *tfhe.BinaryEvaluator) (decrypted_data CODIS_TFHE) {
 	eval = eval.ShallowCopy()
 
 	for i := 0; i < 13; i++ {
@@ -173,26 +175,27 @@ func Decode_CODIS(cod []CODIS) []applications.CODIS {
 	return res
 }
 
+// Marker of the rows of the Encrypted CODIS Data in the key domain of the registered SearchPerson application, in the second
+// column after the key hashes, rows without it are encrypted with the AppID Seg_num + 1 from before the registry
+const CODIS_KeyDomain = "AppTag"
+
 // Encrypt the CODIS data
-func XOR_CODIS(cod CODIS, keyinfo1, keyinfo2 []byte, option bool) CODIS {
+func XOR_CODIS(cod CODIS, keys *RawKeys) CODIS {
+	return xorCODIS(cod, keys, applications.App_id_SearchPerson())
+}
 
-	var StreamKey1, StreamKey2, iv []int
+// AppID of searching a person before the application registry
+func legacyCODISAppID() int {
+	return auxiliary.ReadSegParams().Seg_num + 1
+}
 
-	if option {
-		StreamKey1 = GenKeyHostedMode(keyinfo1, 1)
-		StreamKey2 = GenSegmentKey(keyinfo2, applications.App_id_SearchPerson, 1)
-	} else {
-		StreamKey1 = GenSegmentKey(keyinfo1, applications.App_id_SearchPerson, 1)
-		StreamKey2 = GenKeyHostedMode(keyinfo2, 1)
-	}
+// Encrypt the CODIS data with the keys of an AppID
+func xorCODIS(cod CODIS, keys *RawKeys, appid int) CODIS {
 
-	iv = GenIVHostedMode(applications.App_id_SearchPerson)
+	StreamKey := keys.SegmentKey(appid, keys.HostedKey())
+	iv := GenIVHostedMode(appid, keys.Cipher.IVBits())
 
-	StreamKey := make([]int, 80)
-	for i := 0; i < 80; i++ {
-		StreamKey[i] = StreamKey1[i] ^ StreamKey2[i]
-	}
-	var triv Trivium
+	triv := NewStreamCipher(keys.Cipher)
 	triv.Init(StreamKey, iv)
 	var res CODIS
 	for i := 0; i < 13; i++ {
@@ -209,38 +212,23 @@ func XOR_CODIS(cod CODIS, keyinfo1, keyinfo2 []byte, option bool) CODIS {
 }
 
 // Encrypt the CODIS Data and Save it
-func EncAndSaveCODIS_Trivium(option bool) {
+func EncAndSaveCODIS_Trivium() {
 	now := time.Now()
 	dicpath := auxiliary.ReadPath()
 	os.MkdirAll(dicpath+"/EncSTR", os.ModePerm)
-	file_name := "EncStr.csv"
-	if option {
-		file_name = "EncStrHosted.csv"
-	}
-	file_path := dicpath + "/EncSTR/" + file_name
+	file_path := codisFilePath(dicpath)
 
 	data := applications.ReadCODISData()
-	cods := Encode_CODIS(data)
-	enc_cods := make([]CODIS, len(cods))
-	Indivs := auxiliary.ReadIndividuals()
-	keyhashset1 := make([]string, len(Indivs))
-	keyhashset2 := make([]string, len(Indivs))
-	for i := 0; i < len(Indivs); i++ {
-		keyinfo1, keyhash1 := GenerateRawKey(Indivs[i], 1)
-		keyinfo2, keyhash2 := GenerateRawKey(Indivs[i], 2)
-		keyhashset1[i] = "Hash1: " + big.NewInt(1).SetBytes(keyhash1).String()
-		keyhashset2[i] = "Hash2: " + big.NewInt(1).SetBytes(keyhash2).String()
-		enc_cods[i] = XOR_CODIS(cods[i], keyinfo1, keyinfo2, option)
-	}
-	new_data := Decode_CODIS(enc_cods)
+	Indivs := auxiliary.ReadAllIndividuals()
+	erased := auxiliary.ReadErasures()
 
 	N_Data := make([][]string, len(Indivs))
-
 	for i := 0; i < len(Indivs); i++ {
-		N_Data[i] = make([]string, 4)
-		N_Data[i][0] = keyhashset1[i]
-		N_Data[i][1] = keyhashset2[i]
-		N_Data[i] = append(N_Data[i], new_data[i].Decode2String()...)
+		if _, ok := erased[Indivs[i].Name]; ok {
+			N_Data[i] = tombstoneCODIS()
+			continue
+		}
+		N_Data[i] = encryptCODISRow(data[i], Indivs[i])
 	}
 
 	f, _ := os.Create(file_path)
@@ -254,44 +242,205 @@ func EncAndSaveCODIS_Trivium(option bool) {
 
 }
 
-// Read the Encrypted CODIS Data
-func ReadCODISData(batch_size int, option bool, dicpath string) (res []applications.CODIS, hash1, hash2 [][]byte) {
+// Row of an individual in the Encrypted CODIS Data: the key hash of each key holder, two blank columns, then the encrypted loci
+func encryptCODISRow(cod applications.CODIS, people auxiliary.People) []string {
+	return codisRow(cod, LoadOrNewRawKeys(people))
+}
+
+// Row of the CODIS encrypted with the given keys
+func codisRow(cod applications.CODIS, keys *RawKeys) []string {
+	enc_cod := XOR_CODIS(Encode_Single_CODIS(cod), keys)
+
+	var row []string
+	for k, keyhash := range keys.Keyhashes {
+		row = append(row, "Hash"+strconv.Itoa(k+1)+": "+big.NewInt(1).SetBytes(keyhash).String())
+	}
+	row = append(row, "", CODIS_KeyDomain)
+	return append(row, Decode_CODIS([]CODIS{enc_cod})[0].Decode2String()...)
+}
+
+// Row of an erased individual in the Encrypted CODIS Data
+func tombstoneCODIS() []string {
+	var cod applications.CODIS
+	var row []string
+	for k := 1; k <= auxiliary.ReadKeySharing().Holders; k++ {
+		row = append(row, "Hash"+strconv.Itoa(k)+": 0")
+	}
+	row = append(row, "Erased", "")
+	return append(row, cod.Decode2String()...)
+}
+
+// Get the file path of the Encrypted CODIS Data
+func codisFilePath(dicpath string) string {
+	return dicpath + "/EncSTR/EncStr.csv"
+}
+
+// Replace the row of an individual in the Encrypted CODIS Data by a tombstone, the other rows keep their positions
+func TombstoneCODIS(people auxiliary.People) {
+	replaceCODISRow(people, tombstoneCODIS())
+}
+
+// Encrypt the CODIS of an individual again from old keys to new keys, e.g. for a key rotation
+// the existing ciphertext is decrypted with the old keys, whose key hashes must match the row, and the new row is returned
+// nil is returned if the data has not been encrypted
+func ReencryptCODIS(people auxiliary.People, keys *RawKeys, new_keys *RawKeys) []string {
+	file, err := os.Open(codisFilePath(auxiliary.ReadPath()))
+	if err != nil {
+		return nil
+	}
+	rows, err := csv.NewReader(file).ReadAll()
+	file.Close()
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+
+	for i, p := range auxiliary.ReadAllIndividuals() {
+		if p.Name == people.Name && i < len(rows) {
+			return codisRow(decryptCODISRow(people, rows[i], keys), new_keys)
+		}
+	}
+	return nil
+}
+
+// Decrypt a row of the Encrypted CODIS Data with the keys of the individual, whose key hashes must match the row
+// a row without CODIS_KeyDomain is decrypted with the AppID from before the registry
+func decryptCODISRow(people auxiliary.People, row []string, keys *RawKeys) applications.CODIS {
+	enc_cod, keyhashes := parseCODISRow(row)
+	if len(keyhashes) != len(keys.Keyhashes) {
+		log.Fatalf("The CODIS of %s is not encrypted with its keys", people.Name)
+	}
+	for k := range keyhashes {
+		if !auxiliary.HashEqual(keyhashes[k], keys.Keyhashes[k]) {
+			log.Fatalf("The CODIS of %s is not encrypted with its keys", people.Name)
+		}
+	}
+	appid := applications.App_id_SearchPerson()
+	if isLegacyCODISRow(row) {
+		appid = legacyCODISAppID()
+	}
+	return Decode_CODIS([]CODIS{xorCODIS(Encode_Single_CODIS(enc_cod), keys, appid)})[0]
+}
+
+// Whether a row of the Encrypted CODIS Data is encrypted with the AppID from before the registry, tombstones are not
+func isLegacyCODISRow(row []string) bool {
+	loci := len(row) - 13
+	return loci >= 2 && row[loci-2] != "Erased" && row[loci-1] != CODIS_KeyDomain
+}
+
+// Encrypt the rows of the Encrypted CODIS Data from before the application registry again in the key domain of SearchPerson
+// the keys of every such individual are needed, the file is replaced at once
+func MigrateLegacyCODIS() {
+	file_path := codisFilePath(auxiliary.ReadPath())
+	file, err := os.Open(file_path)
+	if err != nil {
+		return
+	}
+	rows, err := csv.NewReader(file).ReadAll()
+	file.Close()
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
 
-	Indivs := auxiliary.ReadIndividuals()
+	migrated := 0
+	for i, p := range auxiliary.ReadAllIndividuals() {
+		if i < len(rows) && isLegacyCODISRow(rows[i]) {
+			keys := LoadRawKeys(p)
+			rows[i] = codisRow(decryptCODISRow(p, rows[i], keys), keys)
+			migrated++
+		}
+	}
+	if migrated == 0 {
+		return
+	}
+
+	writeCODISRows(file_path, rows)
+	fmt.Printf("Encrypted the CODIS of %d Individuals again for the application registry\n", migrated)
+}
 
-	file_name := "EncStr.csv"
-	if option {
-		file_name = "EncStrHosted.csv"
+// Write all rows of the Encrypted CODIS Data through a synced temporary file, a crash leaves the old or the new rows
+func writeCODISRows(file_path string, rows [][]string) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	if err := writeFileSync(file_path, buf.Bytes(), 0644); err != nil {
+		log.Fatalf("can not write, err is %+v", err)
 	}
-	file_path := dicpath + "/EncSTR/" + file_name
+}
 
-	path, _ := filepath.Abs(file_path)
+// Replace the row of an individual in the Encrypted CODIS Data, nothing is done if the data has not been encrypted
+// the rows of the other individuals are written again unchanged, the file is replaced at once
+func replaceCODISRow(people auxiliary.People, row []string) {
+	file_path := codisFilePath(auxiliary.ReadPath())
+	file, err := os.Open(file_path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	rows, err := csv.NewReader(file).ReadAll()
+	file.Close()
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+
+	for i, p := range auxiliary.ReadAllIndividuals() {
+		if p.Name == people.Name && i < len(rows) {
+			rows[i] = row
+		}
+	}
+
+	writeCODISRows(file_path, rows)
+}
+
+// Read the Encrypted CODIS Data, in the order of ReadIndividuals, the erased individuals are skipped
+// keyhashes[i][k-1] is the key hash of key holder k of the i-th individual, the loci are the last 13 columns
+func ReadCODISData(batch_size int, dicpath string) (res []applications.CODIS, keyhashes [][][]byte) {
+
+	All := auxiliary.ReadAllIndividuals()
+	erased := auxiliary.ReadErasures()
+
+	path, _ := filepath.Abs(codisFilePath(dicpath))
 
 	file, _ := os.Open(path)
 	defer file.Close()
 
-	res = make([]applications.CODIS, len(Indivs))
-	hash1 = make([][]byte, len(Indivs))
-	hash2 = make([][]byte, len(Indivs))
-
 	r := csv.NewReader(file)
 
-	for i := 0; i < len(Indivs); i++ {
+	for i := 0; i < len(All); i++ {
 		row, err := r.Read()
 		if err == io.EOF {
 			break
 		}
+		if _, ok := erased[All[i].Name]; ok {
+			continue
+		}
 
-		for j := 4; j < 17; j++ {
-			temp := strings.Split(row[j], " ")
-			res[i].Loci[j-4].Repeat1, _ = strconv.Atoi(temp[1])
-			res[i].Loci[j-4].Repeat2, _ = strconv.Atoi(temp[2])
+		if isLegacyCODISRow(row) {
+			log.Fatalf("The CODIS of %s is encrypted with the AppID from before the application registry, convert it with -convert", All[i].Name)
 		}
+		cod, hashes := parseCODISRow(row)
+		res = append(res, cod)
+		keyhashes = append(keyhashes, hashes)
+	}
+	return
+}
+
+// Parse a row of the Encrypted CODIS Data into the encrypted loci and the key hashes
+func parseCODISRow(row []string) (cod applications.CODIS, hashes [][]byte) {
+	loci := len(row) - 13
+	for j := loci; j < len(row); j++ {
+		temp := strings.Split(row[j], " ")
+		cod.Loci[j-loci].Repeat1, _ = strconv.Atoi(temp[1])
+		cod.Loci[j-loci].Repeat2, _ = strconv.Atoi(temp[2])
+	}
 
-		temp1, _ := big.NewInt(1).SetString(strings.Split(row[0], " ")[1], 0)
-		hash1[i] = auxiliary.PadBytes(temp1.Bytes(), auxiliary.Mimchashcurve.Size())
-		temp2, _ := big.NewInt(1).SetString(strings.Split(row[1], " ")[1], 0)
-		hash2[i] = auxiliary.PadBytes(temp2.Bytes(), auxiliary.Mimchashcurve.Size())
+	hashes = make([][]byte, loci-2)
+	for k := 0; k < loci-2; k++ {
+		temp, _ := big.NewInt(1).SetString(strings.Split(row[k], " ")[1], 0)
+		hashes[k] = auxiliary.PadBytes(temp.Bytes(), auxiliary.Mimchashcurve.Size())
 	}
 	return
 }
@@ -0,0 +1,140 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/applications"
+	"Governome/auxiliary"
+	"encoding/csv"
+	"math/big"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestAppKeyDomain(t *testing.T) {
+	newTestRoot(t)
+	appid := applications.App_id_SearchPerson()
+	_, index := applications.KeyDomain(appid)
+	keyinfo := randomKeyinfo()
+
+	key := GenSegmentKey(keyinfo, appid, 80, 1)
+	iv := GenIVHostedMode(appid, 80)
+	for _, segID := range []int{index, legacyCODISAppID(), appid} {
+		if segID != appid && reflect.DeepEqual(key, GenSegmentKey(keyinfo, segID, 80, 1)) {
+			t.Errorf("the key of the application is the key of segment %d", segID)
+		}
+		if segID != appid && reflect.DeepEqual(iv, GenIVHostedMode(segID, 80)) {
+			t.Errorf("the IV of the application is the IV of segment %d", segID)
+		}
+	}
+	if quo, _ := GenSegmentKeyWithQuo(keyinfo, appid, 80, 1); !reflect.DeepEqual(key, quo) {
+		t.Errorf("the keys for the proofs differ from the keys of the application")
+	}
+
+	// the registry is persisted with the tags of the applications
+	file, err := os.Open(applications.RegistryPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, _ := csv.NewReader(file).ReadAll()
+	file.Close()
+	apps := applications.RegisteredApplications()
+	if len(rows) != len(apps) {
+		t.Fatalf("%d applications are persisted, want %d", len(rows), len(apps))
+	}
+	for i, app := range apps {
+		tag := big.NewInt(1).SetBytes(app.Tag).String()
+		if !reflect.DeepEqual(rows[i], []string{app.Name, strconv.Itoa(app.Index), tag}) {
+			t.Errorf("row %d of the registry is %v", i, rows[i])
+		}
+	}
+}
+
+// CODIS encrypted with the AppID from before the registry is encrypted again by the migration
+func TestMigrateLegacyCODIS(t *testing.T) {
+	people, want, cod := newRotationRoot(t)
+	keys := LoadRawKeys(people)
+	enc_cod := xorCODIS(Encode_Single_CODIS(cod), keys, legacyCODISAppID())
+	var row []string
+	for k, keyhash := range keys.Keyhashes {
+		row = append(row, "Hash"+strconv.Itoa(k+1)+": "+big.NewInt(1).SetBytes(keyhash).String())
+	}
+	row = append(row, "", "")
+	row = append(row, Decode_CODIS([]CODIS{enc_cod})[0].Decode2String()...)
+	replaceCODISRow(people, row)
+
+	MigrateLegacyCODIS()
+	checkRotationData(t, people, want, cod)
+}
+
+// A tombstone replaces the row of one individual, the rows of the others stay as they are
+func TestTombstoneCODIS(t *testing.T) {
+	peoples := newTestRoot(t, "Alice", "Bob", "Carol")
+	os.MkdirAll(auxiliary.ReadPath()+"/CODIS_Data", os.ModePerm)
+	f, _ := os.Create(auxiliary.ReadPath() + "/CODIS_Data/Random_CODIS_Data.csv")
+	w := csv.NewWriter(f)
+	for range peoples {
+		cod := applications.GenRandomCODIS()
+		w.Write(cod.Decode2String())
+	}
+	w.Flush()
+	f.Close()
+	EncAndSaveCODIS_Trivium()
+
+	read := func() [][]string {
+		file, err := os.Open(codisFilePath(auxiliary.ReadPath()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		rows, err := csv.NewReader(file).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	before := read()
+	TombstoneCODIS(peoples[1])
+	after := read()
+
+	if len(after) != len(before) {
+		t.Fatalf("%d rows after the tombstone, want %d", len(after), len(before))
+	}
+	for _, i := range []int{0, 2} {
+		if !reflect.DeepEqual(after[i], before[i]) {
+			t.Errorf("the row of %s is changed", peoples[i].Name)
+		}
+	}
+	if !reflect.DeepEqual(after[1], tombstoneCODIS()) {
+		t.Errorf("the row of Bob is %v, not a tombstone", after[1])
+	}
+	if _, err := os.Stat(codisFilePath(auxiliary.ReadPath()) + ".tmp"); err == nil {
+		t.Error("the temporary file is left")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Cost and security of a cipher to decrypt the data in TFHE ciphertext, the gates are bootstrapped gates
+type CipherBenchmark struct {
+	Cipher        auxiliary.Cipher
+	Security_Bits int
+	Key_Bits      int
+	Combine_Gates int // XOR of the key shares of n-of-n sharing with the default key holders
+	Init_Gates    int
+	Bit_Gates     int
+	Segment_Gates int // to decrypt a segment of Minimal_Blocksize variants, the key combined
+	Init_Time     time.Duration
+	Bit_Time      time.Duration // mean of the measured stream bits
+	Mismatch      int           // stream bits that differ from the plaintext cipher
+}
+
+// Count the gates of a cipher and time it on bits stream bits of a random key, the stream bits are checked against the
+// plaintext cipher
+func BenchmarkCipher(c auxiliary.Cipher, bits int, enc *tfhe.BinaryEncryptor, eval *tfhe.BinaryEvaluator) CipherBenchmark {
+	var res CipherBenchmark
+	res.Cipher = c
+	res.Security_Bits = c.SecurityBits()
+	res.Key_Bits = c.KeyBits()
+	res.Combine_Gates = c.KeyBits() * (auxiliary.Default_KeySharing.Holders - 1)
+	res.Init_Gates, res.Bit_Gates = GateCount(c)
+	segment_bits := auxiliary.Default_SegParams.Minimal_Blocksize * (auxiliary.Key_Bits + auxiliary.Genotype_Bits)
+	res.Segment_Gates = res.Combine_Gates + res.Init_Gates + segment_bits*res.Bit_Gates
+
+	key := make([]int, c.KeyBits())
+	iv := make([]int, c.IVBits())
+	for i := range key {
+		key[i] = rand.Intn(2)
+	}
+	for i := range iv {
+		iv[i] = rand.Intn(2)
+	}
+
+	// a gate refreshes the fresh encryptions, as SegKeys.Combine does for the SegKeys
+	zero := NewTFHECiphertext(0, eval.Parameters)
+	key_ct := make([]tfhe.LWECiphertext[uint32], len(key))
+	for i := range key {
+		key_ct[i] = eval.XOR(enc.EncryptLWEBool(key[i] == 1), zero)
+	}
+
+	plain := NewStreamCipher(c)
+	plain.Init(key, iv)
+	triv := NewHomomorphicStreamCipher(c, runtime.NumCPU())
+
+	now := time.Now()
+	triv.Init(key_ct, eval, iv)
+	res.Init_Time = time.Since(now)
+
+	var total time.Duration
+	for i := 0; i < bits; i++ {
+		now = time.Now()
+		ct := triv.Genbit(eval)
+		total += time.Since(now)
+
+		bit := 0
+		if enc.DecryptLWEBool(ct) {
+			bit = 1
+		}
+		if bit != plain.Genbit() {
+			res.Mismatch++
+		}
+	}
+	if bits > 0 {
+		res.Bit_Time = total / time.Duration(bits)
+	}
+
+	return res
+}
@@ -33,16 +33,16 @@ import (
 )
 
 type Variant struct {
-	Rsid     [32]int
-	Genotype [4]int
+	Rsid     [auxiliary.Key_Bits]int
+	Genotype [auxiliary.Genotype_Bits]int
 }
 
 // Encryption or Decryption a Variant in Plaintext
 func (v1 *Variant) XOR_Stream(v2 Variant) (res Variant) {
-	for i := 0; i < 32; i++ {
+	for i := 0; i < auxiliary.Key_Bits; i++ {
 		res.Rsid[i] = v1.Rsid[i] ^ v2.Rsid[i]
 	}
-	for i := 0; i < 4; i++ {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		res.Genotype[i] = v1.Genotype[i] ^ v2.Genotype[i]
 	}
 	return
@@ -51,11 +51,11 @@ func (v1 *Variant) XOR_Stream(v2 Variant) (res Variant) {
 // Compare two Variants to judge whether they are equal
 func (v1 *Variant) Compare_Variant(v2 Variant) bool {
 	res := true
-	for i := 0; i < 32; i++ {
+	for i := 0; i < auxiliary.Key_Bits; i++ {
 		temp := (v1.Rsid[i] == v2.Rsid[i])
 		res = (res && temp)
 	}
-	for i := 0; i < 4; i++ {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		temp := (v1.Genotype[i] == v2.Genotype[i])
 		res = (res && temp)
 	}
@@ -63,9 +63,9 @@ func (v1 *Variant) Compare_Variant(v2 Variant) bool {
 	return res
 }
 
-// Encode rsID to Variant format
-func Encode_rsID(rsid int) (res [32]int) {
-	for i := 0; i < 32; i++ {
+// Encode variant key (rsID or position key) to Variant format
+func Encode_rsID(rsid int) (res [auxiliary.Key_Bits]int) {
+	for i := 0; i < auxiliary.Key_Bits; i++ {
 		res[i] = rsid & 1
 		rsid = rsid >> 1
 	}
@@ -73,27 +73,27 @@ func Encode_rsID(rsid int) (res [32]int) {
 }
 
 // Encode Genotype to Variant format
-func Encode_Genotype(genotype int) (res [4]int) {
-	for i := 0; i < 4; i++ {
+func Encode_Genotype(genotype int) (res [auxiliary.Genotype_Bits]int) {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		res[i] = genotype & 1
 		genotype = genotype >> 1
 	}
 	return
 }
 
-// Decode the rsID array
-func Decode_rsID(rsid [32]int) int {
+// Decode the variant key array
+func Decode_rsID(rsid [auxiliary.Key_Bits]int) int {
 	res := 0
-	for i := 0; i < 32; i++ {
+	for i := 0; i < auxiliary.Key_Bits; i++ {
 		res += (1 << i) * rsid[i]
 	}
 	return res
 }
 
 // Decode the genotype array
-func Decode_Genotype(genotype [4]int) int {
+func Decode_Genotype(genotype [auxiliary.Genotype_Bits]int) int {
 	res := 0
-	for i := 0; i < 4; i++ {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		res += (1 << i) * genotype[i]
 	}
 	return res
@@ -108,49 +108,68 @@ func Encode_Variant(rsid int, genotype int) (v Variant) {
 
 // Decode the variant into origin string format
 func (v *Variant) Decode2String() string {
-	r := auxiliary.RsID_i2s(Decode_rsID(v.Rsid))
+	r := auxiliary.VariantKey_i2s(Decode_rsID(v.Rsid))
 	g := auxiliary.Genotype_i2s(Decode_Genotype(v.Genotype))
 	return r + " " + g
 }
 
+// Encode the variant into a lossless string format, used for ciphertext
+func (v *Variant) Encode2String() string {
+	r := strconv.FormatUint(uint64(Decode_rsID(v.Rsid)), 10)
+	g := strconv.Itoa(Decode_Genotype(v.Genotype))
+	return r + " " + g
+}
+
 // Divide the origin data of an individual into Segments
 func DivideIntoSegments(people auxiliary.People) (Encoded_Variants [][]Variant) {
-
 	RSIDs, GTs := auxiliary.ReadPlaintext_data(people)
-	Encoded_Variants = make([][]Variant, auxiliary.Seg_num)
+	return DivideVariantsIntoSegments(people, RSIDs, GTs)
+}
+
+// Divide the variants of an individual into Segments by the parameters of the dataset, the variants may come from any reader
+func DivideVariantsIntoSegments(people auxiliary.People, RSIDs, GTs []int) (Encoded_Variants [][]Variant) {
+	params := auxiliary.ReadSegParams()
+	Encoded_Variants = make([][]Variant, params.Seg_num)
 	for i := 0; i < len(RSIDs); i++ {
 		var temp_variant Variant
 		temp_variant.Genotype = Encode_Genotype(GTs[i])
 		temp_variant.Rsid = Encode_rsID(RSIDs[i])
-		index := auxiliary.SegmentID(people, RSIDs[i], auxiliary.Seg_num)
+		index := auxiliary.SegmentID(people, RSIDs[i], params.Seg_num)
 		Encoded_Variants[index] = append(Encoded_Variants[index], temp_variant)
 	}
 
-	for i := 0; i < auxiliary.Seg_num; i++ {
-		if len(Encoded_Variants[i]) < auxiliary.Minimal_Blocksize {
-			newEV := make([]Variant, auxiliary.Minimal_Blocksize)
-			for j := 0; j < auxiliary.Minimal_Blocksize; j++ {
-				if j < len(Encoded_Variants[i]) {
-					newEV[j] = Encoded_Variants[i][j]
-				} else {
-					var v Variant
-					newEV[j] = v
-				}
-			}
-			Encoded_Variants[i] = newEV
-
-		}
+	for i := 0; i < params.Seg_num; i++ {
+		Encoded_Variants[i] = PadSegment(Encoded_Variants[i], params.Minimal_Blocksize)
 	}
 	return
 }
 
+// Encode the variants of a segment
+func Encode_Variants(RSIDs, GTs []int) []Variant {
+	res := make([]Variant, len(RSIDs))
+	for i := 0; i < len(RSIDs); i++ {
+		res[i] = Encode_Variant(RSIDs[i], GTs[i])
+	}
+	return res
+}
+
+// Pad a segment with empty variants to the minimal blocksize
+func PadSegment(Variants []Variant, blocksize int) []Variant {
+	if len(Variants) >= blocksize {
+		return Variants
+	}
+	newEV := make([]Variant, blocksize)
+	copy(newEV, Variants)
+	return newEV
+}
+
 // Transfer encrypted segments to string form that can be saved
-func SegmentToStrings(seg_data [][]Variant, keyhash1, keyhash2 []byte, Indivname string) [][]string {
+func SegmentToStrings(seg_data [][]Variant, keyhashes [][]byte, Indivname string) [][]string {
 	string_data := make([][]string, len(seg_data)*2+1)
-	string_data[0] = make([]string, 3)
-	string_data[0][0] = Indivname
-	string_data[0][1] = "Key hash1: " + big.NewInt(1).SetBytes(keyhash1).String()
-	string_data[0][2] = "Key hash2: " + big.NewInt(1).SetBytes(keyhash2).String()
+	string_data[0] = []string{Indivname}
+	for k := 0; k < len(keyhashes); k++ {
+		string_data[0] = append(string_data[0], "Key hash"+strconv.Itoa(k+1)+": "+big.NewInt(1).SetBytes(keyhashes[k]).String())
+	}
 	for i := 0; i < len(seg_data); i++ {
 		string_data[2*i+1] = make([]string, 2)
 		string_data[2*i+1][0] = "Segment" + strconv.Itoa(i)
@@ -159,90 +178,110 @@ func SegmentToStrings(seg_data [][]Variant, keyhash1, keyhash2 []byte, Indivname
 		// string_data[2*i+1][3] = "Full Hash2: " + big.NewInt(1).SetBytes(full_hash2[i]).String()
 		string_data[2*i+2] = make([]string, len(seg_data[i]))
 		for j := 0; j < len(seg_data[i]); j++ {
-			string_data[2*i+2][j] = seg_data[i][j].Decode2String()
+			string_data[2*i+2][j] = seg_data[i][j].Encode2String()
 		}
 	}
 	return string_data
 }
 
-// Encrypt the data with keyinfo, with option, if option == true, Hosted mode, else, each segment a key
-func Data_Enc(RawData [][]Variant, keyinfo1, keyinfo2 []byte, option bool) [][]Variant {
-	Stream := make([][]Variant, auxiliary.Seg_num)
+// Encrypt the data with the keys of the key holders, each key holder derives its share by its key mode
+func Data_Enc(RawData [][]Variant, keys *RawKeys) [][]Variant {
+	versions := make([]int, len(RawData))
+	return Data_Enc_Version(RawData, keys, versions)
+}
 
-	StreamKey1 := make([]int, 80)
-	StreamKey2 := make([]int, 80)
+// Encrypt the data with the keys of the key holders, each segment with the IV of its version, the segments with nil data are skipped.
+// With Trivium, 64 segments are encrypted at once by Trivium_Sliced
+func Data_Enc_Version(RawData [][]Variant, keys *RawKeys, versions []int) [][]Variant {
+	HostedKey := keys.HostedKey()
 
-	if option {
-		StreamKey1 = GenKeyHostedMode(keyinfo1, 1)
-	} else {
-		StreamKey2 = GenKeyHostedMode(keyinfo2, 1)
-	}
-
-	for i := 0; i < auxiliary.Seg_num; i++ {
-		if !option {
-			StreamKey1 = GenSegmentKey(keyinfo1, i, 1)
-		} else {
-			StreamKey2 = GenSegmentKey(keyinfo2, i, 1)
+	Ciphertext := make([][]Variant, len(RawData))
+	var batch []int
+	var StreamKeys, ivs [][]int
+	for i := 0; i < len(RawData); i++ {
+		if RawData[i] == nil {
+			continue
 		}
+		StreamKey := keys.SegmentKey(i, HostedKey)
+		iv := SegmentIV(keys.Cipher, i, versions[i])
 
-		iv := GenIVHostedMode(i)
-
-		StreamKey := make([]int, 80)
-		for j := 0; j < 80; j++ {
-			StreamKey[j] = StreamKey1[j] ^ StreamKey2[j]
+		if keys.Cipher != auxiliary.Cipher_Trivium {
+			Ciphertext[i] = Seg_Enc(RawData[i], keys.Cipher, StreamKey, iv)
+			continue
+		}
+		batch = append(batch, i)
+		StreamKeys = append(StreamKeys, StreamKey)
+		ivs = append(ivs, iv)
+		if len(batch) == 64 {
+			Seg_Enc_Sliced(RawData, Ciphertext, batch, StreamKeys, ivs)
+			batch, StreamKeys, ivs = batch[:0], StreamKeys[:0], ivs[:0]
 		}
+	}
+	if len(batch) > 0 {
+		Seg_Enc_Sliced(RawData, Ciphertext, batch, StreamKeys, ivs)
+	}
 
-		var triv Trivium
-		triv.Init(StreamKey, iv)
+	return Ciphertext
+}
 
-		Stream[i] = make([]Variant, len(RawData[i]))
+// Encrypt or decrypt up to 64 segments of RawData with Trivium into Ciphertext, segment segIDs[l] with StreamKeys[l]
+// and ivs[l]
+func Seg_Enc_Sliced(RawData [][]Variant, Ciphertext [][]Variant, segIDs []int, StreamKeys [][]int, ivs [][]int) {
+	var triv Trivium_Sliced
+	triv.Init(StreamKeys, ivs)
 
-		for j := 0; j < len(RawData[i]); j++ {
-			for k := 0; k < 32; k++ {
-				newbit := triv.Genbit()
-				Stream[i][j].Rsid[k] = newbit
-			}
-			for k := 0; k < 4; k++ {
-				newbit := triv.Genbit()
-				Stream[i][j].Genotype[k] = newbit
-			}
+	length := 0
+	for _, i := range segIDs {
+		Ciphertext[i] = make([]Variant, len(RawData[i]))
+		if len(RawData[i]) > length {
+			length = len(RawData[i])
 		}
 	}
 
-	Ciphertext := make([][]Variant, auxiliary.Seg_num)
-	for i := 0; i < auxiliary.Seg_num; i++ {
-		Ciphertext[i] = make([]Variant, len(RawData[i]))
-		for j := 0; j < len(RawData[i]); j++ {
-			Ciphertext[i][j] = RawData[i][j].XOR_Stream(Stream[i][j])
+	// the stream of a segment is read from its lane, in the order of Seg_Enc
+	for j := 0; j < length; j++ {
+		for k := 0; k < auxiliary.Key_Bits; k++ {
+			z := triv.Genword()
+			for l, i := range segIDs {
+				if j < len(RawData[i]) {
+					Ciphertext[i][j].Rsid[k] = RawData[i][j].Rsid[k] ^ int(z>>l&1)
+				}
+			}
+		}
+		for k := 0; k < auxiliary.Genotype_Bits; k++ {
+			z := triv.Genword()
+			for l, i := range segIDs {
+				if j < len(RawData[i]) {
+					Ciphertext[i][j].Genotype[k] = RawData[i][j].Genotype[k] ^ int(z>>l&1)
+				}
+			}
 		}
 	}
-
-	return Ciphertext
 }
 
-// Decrypt a segment with keyinfo
-func Seg_Dec(RawData []Variant, keyinfo []byte, segID int, batch_size int) []Variant {
-	StreamKey := GenSegmentKey(keyinfo, segID, batch_size)
-	iv := make([]int, 80)
-	var triv Trivium
+// Encrypt or decrypt a segment with the stream key and iv of the cipher
+func Seg_Enc(RawData []Variant, c auxiliary.Cipher, StreamKey []int, iv []int) []Variant {
+	triv := NewStreamCipher(c)
 	triv.Init(StreamKey, iv)
-	Stream := make([]Variant, len(RawData))
 
-	for i := 0; i < len(RawData); i++ {
-		for j := 0; j < 32; j++ {
+	Ciphertext := make([]Variant, len(RawData))
+	for j := 0; j < len(RawData); j++ {
+		var Stream Variant
+		for k := 0; k < auxiliary.Key_Bits; k++ {
 			newbit := triv.Genbit()
-			Stream[i].Rsid[j] = newbit
+			Stream.Rsid[k] = newbit
 		}
-		for j := 0; j < 4; j++ {
+		for k := 0; k < auxiliary.Genotype_Bits; k++ {
 			newbit := triv.Genbit()
-			Stream[i].Genotype[j] = newbit
+			Stream.Genotype[k] = newbit
 		}
+		Ciphertext[j] = RawData[j].XOR_Stream(Stream)
 	}
 
-	Plaintext := make([]Variant, len(RawData))
-	for i := 0; i < len(RawData); i++ {
-		Plaintext[i] = RawData[i].XOR_Stream(Stream[i])
-	}
+	return Ciphertext
+}
 
-	return Plaintext
+// Decrypt a segment of an IV version with the keys of the individual
+func Seg_Dec(RawData []Variant, keys *RawKeys, segID int, version int) []Variant {
+	return Seg_Enc(RawData, keys.Cipher, keys.SegmentKey(segID, keys.HostedKey()), SegmentIV(keys.Cipher, segID, version))
 }
@@ -0,0 +1,222 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"log"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// An instance of FiLIP with a direct sum of monomials filter, N key bits and Monomials[d-1] monomials of degree d
+type FiLIP_Params struct {
+	N         int
+	Monomials []int
+}
+
+// FiLIP-1216 (Méaux et al., Indocrypt 2019), 2^14 key bits and a filter of 1216 variables for 128-bit security
+var FiLIP_1216 = FiLIP_Params{N: auxiliary.FiLIP_Key_Bits, Monomials: []int{128, 64, 0, 80, 0, 0, 0, 80}}
+
+// FiLIP instance of the datasets with a key of auxiliary.FiLIP_Key_Bits bits, a test sets a smaller one along with them
+var FiLIP_Dataset = FiLIP_1216
+
+// Number of key bits read by the filter for a stream bit
+func (p FiLIP_Params) Vars() int {
+	n := 0
+	for d, m := range p.Monomials {
+		n += (d + 1) * m
+	}
+	return n
+}
+
+// Number of AND and XOR gates of the filter in TFHE ciphertext, the whitening is a free NOT
+func (p FiLIP_Params) Gates() (and int, xor int) {
+	terms := 0
+	for d, m := range p.Monomials {
+		and += d * m
+		terms += m
+	}
+	return and, terms - 1
+}
+
+// FiLIP, a filter permutator (Méaux et al., Eurocrypt 2016), a stream bit is the filter of a subset of the key bits,
+// whitened by a mask, the subset and the mask are drawn by a public PRNG seeded by the IV, so it has no warm-up
+type FiLIP struct {
+	Params FiLIP_Params
+	K      []int
+	prng   filipPRNG
+}
+
+// FiLIP in TFHE ciphertext, the key is encrypted and the PRNG is public
+type FiLIP_TFHE struct {
+	Params FiLIP_Params
+	K      []tfhe.LWECiphertext[uint32]
+	prng   filipPRNG
+}
+
+// PRNG of the subsets and the masks, AES-128 in counter mode keyed by the IV
+type filipPRNG struct {
+	stream cipher.Stream
+	buf    [4]byte
+	// round in which a key bit was drawn last, to draw a subset without repetition
+	drawn []int
+	round int
+}
+
+func (g *filipPRNG) init(iv []int, n int) {
+	var seed [16]byte
+	for i := 0; i < 128; i++ {
+		seed[i/8] |= byte(iv[i]) << (i % 8)
+	}
+	block, err := aes.NewCipher(seed[:])
+	if err != nil {
+		log.Fatalf("can not init the PRNG, err is %+v", err)
+	}
+	g.stream = cipher.NewCTR(block, make([]byte, aes.BlockSize))
+	g.drawn = make([]int, n)
+	g.round = 0
+}
+
+func (g *filipPRNG) uint32() uint32 {
+	g.buf = [4]byte{}
+	g.stream.XORKeyStream(g.buf[:], g.buf[:])
+	return binary.LittleEndian.Uint32(g.buf[:])
+}
+
+// Uniform in [0, n) by rejection
+func (g *filipPRNG) intn(n int) int {
+	limit := uint32((1 << 32) / uint64(n) * uint64(n))
+	for {
+		if v := g.uint32(); v < limit || limit == 0 {
+			return int(v % uint32(n))
+		}
+	}
+}
+
+// Indices of the key bits in the order of the filter variables and the whitening mask of the next stream bit
+func (g *filipPRNG) next(vars int) (index []int, mask []int) {
+	g.round++
+	index = make([]int, vars)
+	for i := 0; i < vars; i++ {
+		j := g.intn(len(g.drawn))
+		for g.drawn[j] == g.round {
+			j = g.intn(len(g.drawn))
+		}
+		g.drawn[j] = g.round
+		index[i] = j
+	}
+	mask = make([]int, vars)
+	for i := 0; i < vars; i += 32 {
+		v := g.uint32()
+		for b := 0; b < 32 && i+b < vars; b++ {
+			mask[i+b] = int(v>>b) & 1
+		}
+	}
+	return
+}
+
+// Generate a stream bit
+func (f *FiLIP) Genbit() int {
+	index, mask := f.prng.next(f.Params.Vars())
+
+	z, pos := 0, 0
+	for d, m := range f.Params.Monomials {
+		for j := 0; j < m; j++ {
+			monomial := 1
+			for k := 0; k <= d; k++ {
+				monomial &= f.K[index[pos]] ^ mask[pos]
+				pos++
+			}
+			z ^= monomial
+		}
+	}
+
+	return z
+}
+
+// Generate a stream bit in TFHE ciphertext
+func (f *FiLIP_TFHE) Genbit(eval *tfhe.BinaryEvaluator) tfhe.LWECiphertext[uint32] {
+	index, mask := f.prng.next(f.Params.Vars())
+
+	variable := func(pos int) tfhe.LWECiphertext[uint32] {
+		if mask[pos] == 1 {
+			return eval.NOT(f.K[index[pos]])
+		}
+		return f.K[index[pos]]
+	}
+
+	var z tfhe.LWECiphertext[uint32]
+	first, pos := true, 0
+	for d, m := range f.Params.Monomials {
+		for j := 0; j < m; j++ {
+			monomial := variable(pos)
+			pos++
+			for k := 1; k <= d; k++ {
+				monomial = eval.AND(monomial, variable(pos))
+				pos++
+			}
+			if first {
+				z, first = monomial.Copy(), false
+			} else {
+				z = eval.XOR(z, monomial)
+			}
+		}
+	}
+
+	return z
+}
+
+// Init the key and the PRNG for FiLIP, the IV has 128 bits, FiLIP_1216 if no instance is set
+func (f *FiLIP) Init(key []int, iv []int) {
+	if f.Params.N == 0 {
+		f.Params = FiLIP_1216
+	}
+	if len(key) != f.Params.N || len(iv) != 128 {
+		log.Fatalf("FiLIP takes a key of %d bits and an IV of 128 bits, %d and %d are given", f.Params.N, len(key), len(iv))
+	}
+	f.K = append([]int(nil), key...)
+	f.prng.init(iv, f.Params.N)
+}
+
+// Init in ciphertext, the key is recovered from the key shares by SegKeys.Combine
+func (f *FiLIP_TFHE) Init(key []tfhe.LWECiphertext[uint32], eval *tfhe.BinaryEvaluator, iv []int) {
+	if f.Params.N == 0 {
+		f.Params = FiLIP_1216
+	}
+	if len(key) != f.Params.N || len(iv) != 128 {
+		log.Fatalf("FiLIP takes a key of %d bits and an IV of 128 bits, %d and %d are given", f.Params.N, len(key), len(iv))
+	}
+	f.K = make([]tfhe.LWECiphertext[uint32], len(key))
+	for i := range key {
+		f.K[i] = key[i].Copy()
+	}
+	f.prng.init(iv, f.Params.N)
+}
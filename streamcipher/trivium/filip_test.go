@@ -0,0 +1,81 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// A dataset encrypted with a reduced FiLIP instance is recovered from the SegKeys in TFHE ciphertext
+func TestFiLIPDataRecover(t *testing.T) {
+	key_bits, instance := auxiliary.FiLIP_Key_Bits, FiLIP_Dataset
+	t.Cleanup(func() { auxiliary.FiLIP_Key_Bits, FiLIP_Dataset = key_bits, instance })
+	auxiliary.FiLIP_Key_Bits = 512
+	FiLIP_Dataset = FiLIP_Params{N: 512, Monomials: []int{8, 4, 0, 4}}
+
+	plaintext, _ := filepath.Abs("testdata/legacy/Alice_Plaintext.csv")
+	people := newTestRoot(t, "Alice")
+	auxiliary.SaveCipher(auxiliary.Cipher_FiLIP)
+	RSIDs, GTs := auxiliary.ReadGenotypeCSV(plaintext)
+	os.MkdirAll(filepath.Dir(DataHashPath()), os.ModePerm)
+	EncryptAndSaveSegments(people[0], DivideVariantsIntoSegments(people[0], RSIDs, GTs))
+
+	params := testParams()
+	enc := tfhe.NewBinaryEncryptor(params)
+	eval := tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+	keys := LoadRawKeys(people[0])
+	for _, i := range []int{0, len(RSIDs) - 1} {
+		segID := auxiliary.SegmentID(people[0], RSIDs[i], auxiliary.ReadSegParams().Seg_num)
+		segkeys := NewSegKeys(keys.Sharing)
+		for k := range keys.Keyinfo {
+			for _, bit := range keys.KeyShare(k, segID, 1) {
+				segkeys.Keys[k] = append(segkeys.Keys[k], enc.EncryptLWEBool(bit == 1))
+			}
+		}
+
+		Data := GetCiphertextData(RSIDs[i], eval, 1, people)
+		found := false
+		for _, v := range Data_Recover(eval, Data, []SegKeys{segkeys}, people, RSIDs[i])[0] {
+			v := Dec_Variant(v, enc)
+			if Decode_rsID(v.Rsid) == RSIDs[i] {
+				if gt := Decode_Genotype(v.Genotype); gt != GTs[i] {
+					t.Fatalf("rs%d: got %s, want %s", RSIDs[i], auxiliary.Genotype_i2s(gt), auxiliary.Genotype_i2s(GTs[i]))
+				}
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("rs%d is not recovered from segment %d", RSIDs[i], segID)
+		}
+	}
+}
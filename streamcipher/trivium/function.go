@@ -29,18 +29,13 @@ package trivium
 import (
 	"Governome/applications"
 	"Governome/auxiliary"
-	"bytes"
-	"encoding/csv"
 	"fmt"
-	"io"
 	"log"
 	"math"
 	"math/big"
 	"os"
-	"path/filepath"
 	"runtime"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -48,7 +43,7 @@ import (
 )
 
 // Read plaintext data, encrypt it, then save it
-func EncryptAndSaveData(option bool) {
+func EncryptAndSaveData() {
 
 	now := time.Now()
 
@@ -70,9 +65,9 @@ func EncryptAndSaveData(option bool) {
 	var wg sync.WaitGroup
 	wg.Add(len(Indivs))
 
-	ch := make(chan struct{}, numCores/2)
+	ch := make(chan struct{}, max(numCores/2, 1))
 
-	fmt.Println("threads number: " + strconv.Itoa(numCores/2))
+	fmt.Println("threads number: " + strconv.Itoa(max(numCores/2, 1)))
 
 	for i := 0; i < len(Indivs); i++ {
 
@@ -80,24 +75,7 @@ func EncryptAndSaveData(option bool) {
 		ch <- struct{}{}
 		go func() {
 			Encoded_Variants := DivideIntoSegments(Indivs[index])
-
-			keyinfo1, keyhash1 := GenerateRawKey(Indivs[index], 1)
-			keyinfo2, keyhash2 := GenerateRawKey(Indivs[index], 2)
-
-			enc_data := Data_Enc(Encoded_Variants, keyinfo1, keyinfo2, option)
-			string_data := SegmentToStrings(enc_data, keyhash1, keyhash2, Indivs[index].Name)
-			file_name := Indivs[index].Name
-			if option {
-				file_name = file_name + "_Hosted"
-			}
-			file_name = file_name + "_Segments.csv"
-			file_path := dicpath + "/Segments_Enc_Data/" + auxiliary.MappingPeopletoFolder(Indivs[index]) + "/" + file_name
-			f, _ := os.Create(file_path)
-			w := csv.NewWriter(f)
-
-			w.WriteAll(string_data)
-			w.Flush()
-			f.Close()
+			EncryptAndSaveSegments(Indivs[index], Encoded_Variants)
 
 			<-ch
 			wg.Done()
@@ -111,224 +89,424 @@ func EncryptAndSaveData(option bool) {
 
 }
 
-// Read a ciphertext segment
-func ReadSegmentData(people auxiliary.People, segID int, batch_size int, option bool) (Variants []Variant, keyhash1, keyhash2 []byte) {
-	dicpath := auxiliary.ReadPath()
-	file_name := people.Name
-	if option {
-		file_name = file_name + "_Hosted"
-	}
-	file_name = file_name + "_Segments.csv"
-	file_path := dicpath + "/Segments_Enc_Data/" + auxiliary.MappingPeopletoFolder(people) + "/" + file_name
-	path, _ := filepath.Abs(file_path)
-	file, _ := os.Open(path)
-	defer file.Close()
-	r := csv.NewReader(file)
-	for {
-		row, err := r.Read()
-		if err == io.EOF {
-			break
-		}
+// Encrypt the segments of an individual, then save it
+func EncryptAndSaveSegments(people auxiliary.People, Encoded_Variants [][]Variant) {
+	keys := LoadOrNewRawKeys(people)
 
-		if row[0] == people.Name {
-			k1 := strings.Split(row[1], ":")[1][1:]
-			kval1, _ := big.NewInt(1).SetString(k1, 0)
-			keyhash1 = kval1.Bytes()
-			k2 := strings.Split(row[2], ":")[1][1:]
-			kval2, _ := big.NewInt(1).SetString(k2, 0)
-			keyhash2 = kval2.Bytes()
-			continue
-		}
+	params := auxiliary.ReadSegParams()
+	if len(Encoded_Variants) != params.Seg_num {
+		log.Fatalf("%d segments of %s, but the dataset uses %d segments", len(Encoded_Variants), people.Name, params.Seg_num)
+	}
+	auxiliary.SaveSegParams(params)
 
-		if len(row[0]) < 8 {
-			fmt.Println(row)
-			fmt.Println(people)
-		}
+	enc_data := Data_Enc(Encoded_Variants, keys)
+	saveEncryptedSegments(people, enc_data, keys.Keyhashes)
+}
 
-		if row[0][0:7] != "Segment" || row[0][7:] != strconv.Itoa(segID) {
-			continue
-		}
+// Save the encrypted segments of an individual to a new container and register its root
+func saveEncryptedSegments(people auxiliary.People, enc_data [][]Variant, keyhashes [][]byte) {
+	root := saveEncryptedSegmentsTo(SegmentFilePath(people, ".bin"), people, enc_data, keyhashes)
+	SaveDataHash(people, root)
+}
 
-		seg_len, error := strconv.Atoi(row[1][17:])
-		if error != nil {
-			fmt.Println("Error:", error)
-			return
-		}
+// Save the encrypted segments of an individual to a new container at path, return its root
+func saveEncryptedSegmentsTo(path string, people auxiliary.People, enc_data [][]Variant, keyhashes [][]byte) []byte {
+	params := auxiliary.ReadSegParams()
+	header := SegmentHeader{Name: people.Name, Seg_num: len(enc_data), Minimal_Blocksize: params.Minimal_Blocksize, Key_Bits: auxiliary.Key_Bits, Genotype_Bits: auxiliary.Genotype_Bits, Keyhashes: keyhashes}
+	return SaveSegmentFile(path, header, enc_data, nil)
+}
 
-		row, err = r.Read()
-		if err == io.EOF {
-			break
-		}
+// Rotate the key of a key holder of an individual, e.g. when the key holder is compromised
+// the segments and the CODIS record are encrypted again with the new key, the containers get the new
+// key hashes and Merkle roots, and the SegKeys, proofs and cached warm-up states issued with the old key are removed
+// in threshold mode a new Trivium key is dealt to all key holders, so the passphrases of all of them are needed
+// the new keys, container and CODIS row are staged first and committed by a journal, see RecoverRotation
+func RotateKey(people auxiliary.People, keyholderID int) {
 
-		Variants = make([]Variant, seg_len)
+	now := time.Now()
+	sharing := auxiliary.ReadKeySharing()
+	if keyholderID < 1 || keyholderID > sharing.Holders {
+		log.Fatalf("Invalid key holder %d", keyholderID)
+	}
 
-		for i := 0; i < seg_len; i++ {
+	RecoverRotation(people)
+	commitRotation(people, stageRotation(people, keyholderID))
 
-			v := strings.Split(row[i], " ")
-			Variants[i].Rsid = Encode_rsID(auxiliary.RsID_s2i(v[0]))
-			Variants[i].Genotype = Encode_Genotype(auxiliary.Genotype_s2i(v[1]))
+	fmt.Printf("Finish Key Rotation of Key Holder "+strconv.Itoa(keyholderID)+" of "+people.Name+" in (%s)\n", time.Since(now))
+}
+
+// Stage the rotation of the key of a key holder, the new keys, the container encrypted with them and the new CODIS row
+// nothing is replaced until the rotation is committed
+func stageRotation(people auxiliary.People, keyholderID int) Rotation {
+	keys := LoadRawKeys(people)
+
+	// Decrypt all data with the old keys before anything is written
+	var RawData [][]Variant
+	if _, err := os.Stat(SegmentFilePath(people, ".bin")); err == nil {
+		sf := openSegmentFile(people)
+		root := sf.Header.Root
+		sf.Close()
+		if !auxiliary.HashEqual(root, ReadDataHash()[people.Name]) {
+			log.Fatalf("Segments of %s do not match the registered data hash", people.Name)
 		}
-		break
+		Segments, versions := ReadAllSegments(people)
+		RawData = Data_Enc_Version(Segments, keys, versions)
+	}
 
+	// The new keys are staged beside the old ones, so the old keys are kept until all data is encrypted with the new ones
+	new_keys, staged := stageRawKeys(people, keys, keyholderID)
+
+	// A new key gives a new keystream, so the IV versions start again from 0
+	rotation := Rotation{Keyholders: staged}
+	if RawData != nil {
+		enc_data := Data_Enc(RawData, new_keys)
+		root := saveEncryptedSegmentsTo(SegmentFilePath(people, ".bin")+".new", people, enc_data, new_keys.Keyhashes)
+		rotation.DataHash = signedDataHash(people, root)
 	}
-	return
+	rotation.CODIS = ReencryptCODIS(people, keys, new_keys)
+
+	return rotation
 }
 
-// Read the key hash
-func ReadKeyhash(people auxiliary.People, batch_size int, option bool) (keyhash1, keyhash2 []byte) {
+// Remove the SegKeys and proofs of a key holder of an individual, the same layout as snarks.SaveSegkey and snarks.SaveProof
+// keyholderID 0 removes those of all key holders
+func RemoveSnarkArtifacts(people auxiliary.People, keyholderID int) {
 	dicpath := auxiliary.ReadPath()
-	file_name := people.Name
-	if option {
-		file_name = file_name + "_Hosted"
-	}
-	file_name = file_name + "_Segments.csv"
-	file_path := dicpath + "/Segments_Enc_Data/" + auxiliary.MappingPeopletoFolder(people) + "/" + file_name
-	path, _ := filepath.Abs(file_path)
-	file, _ := os.Open(path)
-	defer file.Close()
-	r := csv.NewReader(file)
-	for {
-		row, err := r.Read()
-		if err == io.EOF {
-			break
+	folder := auxiliary.MappingPeopletoFolder(people)
+	for _, dir := range []string{"/Snarks/SegKey/", "/Snarks/ProofTrivium/"} {
+		fullpath := dicpath + dir + folder + "/" + people.Name
+		if keyholderID > 0 {
+			fullpath = fullpath + "/Keyholder_" + strconv.Itoa(keyholderID)
 		}
+		os.RemoveAll(fullpath)
+	}
+}
 
-		if row[0] == people.Name {
-			k1 := strings.Split(row[1], ":")[1][1:]
-			kval1, _ := big.NewInt(1).SetString(k1, 0)
-			keyhash1 = kval1.Bytes()
-			k2 := strings.Split(row[2], ":")[1][1:]
-			kval2, _ := big.NewInt(1).SetString(k2, 0)
-			keyhash2 = kval2.Bytes()
-			break
+// Delete an individual: remove the encrypted segments, SegKeys, proofs, keys and cached warm-up states, tombstone the EncSTR rows and record an erasure receipt
+// the receipt binds the key hashes and the last data hashes and is signed, later queries refuse the erased individual
+// the individual must be listed in Individuals.csv
+func DeleteIndividual(people auxiliary.People) auxiliary.ErasureReceipt {
+	known := false
+	for _, p := range auxiliary.ReadAllIndividuals() {
+		if p.Name == people.Name && p.ID == people.ID {
+			known = true
 		}
+	}
+	if !known {
+		log.Fatalf("%s is not in Individuals.csv", people.Name)
+	}
+	if _, ok := auxiliary.ReadErasures()[people.Name]; ok {
+		log.Fatalf("%s has already been erased", people.Name)
+	}
 
+	receipt := auxiliary.ErasureReceipt{Name: people.Name, ID: people.ID, Time: time.Now().Unix()}
+	for _, keyhash := range ReadRawKeyhashes(people) {
+		receipt.Keyhashes = append(receipt.Keyhashes, new(big.Int).SetBytes(keyhash))
+	}
+	datahash := new(big.Int).SetBytes(ReadDataHash()[people.Name])
+	receipt.DataHashDefault, receipt.DataHashHosted = datahash, new(big.Int)
+	if auxiliary.ReadKeyModes().Equal(auxiliary.DefaultKeyModes(auxiliary.ReadKeySharing().Holders, true)) {
+		receipt.DataHashDefault, receipt.DataHashHosted = new(big.Int), datahash
 	}
-	return
-}
 
-// Generate and Save tfheb key
-func GenAndSaveKey(params tfhe.Parameters[uint32]) {
-	enc := tfhe.NewBinaryEncryptor(params)
-	pk := auxiliary.GenLWEPublicKey_tfheb(enc)
-	Save_SK(enc)
-	Save_PK(pk)
-}
+	for _, ext := range []string{".bin", ".csv"} {
+		os.Remove(SegmentFilePath(people, ext))
+		os.Remove(legacyHostedSegmentFilePath(people, ext))
+	}
+	TombstoneCODIS(people)
 
-// Read tfheb Secret Key
-func ReadSK(enc *tfhe.BinaryEncryptor) {
-	dicpath := auxiliary.ReadPath()
-	var buf bytes.Buffer
-	file, _ := os.ReadFile(dicpath + "/Key_Information/Trivium_SecretKey")
-	buf.Write(file)
-	enc.BaseEncryptor.SecretKey.ReadFrom(&buf)
+	RemoveSnarkArtifacts(people, 0)
+	RemoveRawKeys(people)
+	RemoveWarmupCache(people)
+
+	receipt.Sign()
+	auxiliary.SaveErasure(receipt)
+
+	fmt.Printf("Erased %s, receipt %s\n", people.Name, receipt.Receipt.String())
+	return receipt
 }
 
-// Read tfheb Public Key
-func ReadPK(params tfhe.Parameters[uint32]) (pk auxiliary.PublicKey_tfheb) {
-	dicpath := auxiliary.ReadPath()
-	pk.Params = params
-	row_path := dicpath + "/Key_Information/Trivium_PublicKey.csv"
-	path, _ := filepath.Abs(row_path)
-	file, _ := os.Open(path)
-	defer file.Close()
+// Update the encrypted data of an individual by a delta of variants, a hom-ref genotype removes the variant
+// only the affected segments are decrypted and encrypted again, with the next IV version
+func UpdateIndividual(people auxiliary.People, RSIDs, GTs []int) {
 
-	r := csv.NewReader(file)
+	now := time.Now()
 
-	sk_size := params.LWEDimension()
+	path := SegmentFilePath(people, ".bin")
+	datahash := ReadDataHash()
+	params := auxiliary.ReadSegParams()
 
-	count := 0
-	pk.A = make([][]uint32, sk_size)
+	delta := make(map[int]map[int]int)
+	for i := 0; i < len(RSIDs); i++ {
+		segID := auxiliary.SegmentID(people, RSIDs[i], params.Seg_num)
+		if delta[segID] == nil {
+			delta[segID] = make(map[int]int)
+		}
+		delta[segID][RSIDs[i]] = GTs[i]
+	}
 
-	for {
-		row, err := r.Read()
-		if err != nil && err != io.EOF {
+	sf := openSegmentFile(people)
+	RawData := make([][]Variant, params.Seg_num)
+	versions := make([]int, params.Seg_num)
+	var err error
+	for segID := range delta {
+		RawData[segID], versions[segID], err = sf.ReadSegment(segID)
+		if err != nil {
 			log.Fatalf("can not read, err is %+v", err)
 		}
-		if err == io.EOF {
-			break
-		}
-
-		if count == sk_size {
-			pk.B = make([]uint32, sk_size)
-			for i := 0; i < sk_size; i++ {
-				val, _ := big.NewInt(1).SetString(row[i], 10)
-				pk.B[i] = uint32(val.Uint64())
+	}
+	sf.Close()
+
+	keys := LoadRawKeys(people)
+	dec_data := Data_Enc_Version(RawData, keys, versions)
+
+	new_versions := make([]int, params.Seg_num)
+	for segID, changes := range delta {
+		var RSID_seg, GT_seg []int
+		for _, v := range dec_data[segID] {
+			rsid := Decode_rsID(v.Rsid)
+			genotype := Decode_Genotype(v.Genotype)
+			if rsid == 0 && genotype == 0 {
+				continue
 			}
-		} else {
-			pk.A[count] = make([]uint32, sk_size)
-			for i := 0; i < sk_size; i++ {
-				val, _ := big.NewInt(1).SetString(row[i], 10)
-				pk.A[count][i] = uint32(val.Uint64())
+			if _, ok := changes[rsid]; ok {
+				continue
+			}
+			RSID_seg = append(RSID_seg, rsid)
+			GT_seg = append(GT_seg, genotype)
+		}
+		for rsid, genotype := range changes {
+			if !auxiliary.Genotype_IsHomRef(genotype) {
+				RSID_seg = append(RSID_seg, rsid)
+				GT_seg = append(GT_seg, genotype)
 			}
 		}
+		RawData[segID] = PadSegment(Encode_Variants(RSID_seg, GT_seg), params.Minimal_Blocksize)
+		new_versions[segID] = versions[segID] + 1
+	}
+
+	enc_data := Data_Enc_Version(RawData, keys, new_versions)
+	updates := make(map[int][]Variant, len(delta))
+	update_versions := make(map[int]int, len(delta))
+	for segID := range delta {
+		updates[segID] = enc_data[segID]
+		update_versions[segID] = new_versions[segID]
+	}
+	root := UpdateSegmentFile(path, datahash[people.Name], updates, update_versions)
+	SaveDataHash(people, root)
+
+	fmt.Printf("Finish Update of "+strconv.Itoa(len(delta))+" Segments of "+people.Name+" in (%s)\n", time.Since(now))
+}
 
-		count++
+// Read a multi-sample VCF/BCF file, encrypt the samples listed in Individuals.csv, then save them
+// the file is read record by record, the calls of other samples are not kept
+func EncryptAndSaveVCF(vcf_path string) []auxiliary.VCFReject {
+	vr, err := auxiliary.OpenVCF(vcf_path)
+	if err != nil {
+		log.Fatalf("can not open %s, err is %+v", vcf_path, err)
+	}
+	defer vr.Close()
 
+	known := make(map[string]bool)
+	for _, p := range auxiliary.ReadIndividuals() {
+		known[p.Name] = true
 	}
+	samples := make([]auxiliary.SampleGenotypes, len(vr.Samples))
+	for i := range samples {
+		samples[i].Name = vr.Samples[i]
+	}
+	rejects := []auxiliary.VCFReject{}
 
-	return
+	err = vr.Scan(func(i, key, genotype int) {
+		if known[samples[i].Name] {
+			samples[i].RsID = append(samples[i].RsID, key)
+			samples[i].Genotype = append(samples[i].Genotype, genotype)
+		}
+	}, func(r auxiliary.VCFReject) {
+		if r.Sample == "" || known[r.Sample] {
+			rejects = append(rejects, r)
+		}
+	})
+	if err != nil {
+		log.Fatalf("can not read %s, err is %+v", vcf_path, err)
+	}
+	return EncryptAndSaveSamples(samples, rejects)
 }
 
-// Get Ciphertext SegKey with Public Key
-func GetSegKeyFromPK(pk auxiliary.PublicKey_tfheb, rsid int, batch_size int, Indiv []auxiliary.People, option bool) (res1, res2 [][]tfhe.LWECiphertext[uint32]) {
-	Data_Len := len(Indiv)
-	res1 = make([][]tfhe.LWECiphertext[uint32], Data_Len)
-	res2 = make([][]tfhe.LWECiphertext[uint32], Data_Len)
+// Read a PLINK bed/bim/fam trio, encrypt the samples listed in Individuals.csv, then save them
+func EncryptAndSavePLINK(prefix string) []auxiliary.VCFReject {
+	samples, rejects := auxiliary.ReadPLINKSamples(prefix)
+	return EncryptAndSaveSamples(samples, rejects)
+}
+
+// Read a direct-to-consumer genotype file of a single individual, encrypt and save it, Individuals.csv is not used
+func EncryptAndSaveDTC(people auxiliary.People, dtc_path string, table_path string) []auxiliary.VCFReject {
 
 	now := time.Now()
 
-	var wg sync.WaitGroup
-	wg.Add(Data_Len)
+	sample, rejects := auxiliary.ReadDTCSample(dtc_path, people.Name, auxiliary.ReadRefAltTable(table_path))
+	Encoded_Variants := DivideVariantsIntoSegments(people, sample.RsID, sample.Genotype)
+	EncryptAndSaveSegments(people, Encoded_Variants)
+
+	fmt.Printf("Finish Data Encryption and Save of "+people.Name+" with "+strconv.Itoa(len(sample.RsID))+" Variants and "+strconv.Itoa(len(rejects))+" Rejects in (%s)\n", time.Since(now))
+
+	return rejects
+}
+
+// Encrypt the samples listed in Individuals.csv and save them, the other samples are added to the rejects
+func EncryptAndSaveSamples(samples []auxiliary.SampleGenotypes, rejects []auxiliary.VCFReject) []auxiliary.VCFReject {
+
+	now := time.Now()
+
+	Indivs := auxiliary.ReadIndividuals()
+	known := make(map[string]auxiliary.People, len(Indivs))
+	for _, p := range Indivs {
+		known[p.Name] = p
+	}
+
+	peoples := make([]auxiliary.People, 0, len(samples))
+	datas := make([]auxiliary.SampleGenotypes, 0, len(samples))
+	for _, s := range samples {
+		p, ok := known[s.Name]
+		if !ok {
+			rejects = append(rejects, auxiliary.VCFReject{Sample: s.Name, Reason: "sample not in Individuals.csv"})
+			continue
+		}
+		peoples = append(peoples, p)
+		datas = append(datas, s)
+	}
+
 	numCores := runtime.NumCPU()
 
-	ch := make(chan struct{}, numCores/2)
+	var wg sync.WaitGroup
+	wg.Add(len(peoples))
+
+	ch := make(chan struct{}, max(numCores/2, 1))
 
-	for i := 0; i < Data_Len; i++ {
+	for i := 0; i < len(peoples); i++ {
 		index := i
 		ch <- struct{}{}
 		go func() {
-			seg_ID := auxiliary.SegmentID(Indiv[index], rsid, auxiliary.Seg_num)
-			keyinfo1, _ := GenerateRawKey(Indiv[index], 1)
-			keyinfo2, _ := GenerateRawKey(Indiv[index], 2)
-
-			var segkey1, segkey2 []int
-
-			if option {
-				segkey1 = GenKeyHostedMode(keyinfo1, 1)
-				segkey2 = GenSegmentKey(keyinfo2, seg_ID, 1)
-			} else {
-				segkey1 = GenSegmentKey(keyinfo1, seg_ID, 1)
-				segkey2 = GenKeyHostedMode(keyinfo2, 1)
-			}
-
-			res1[index] = make([]tfhe.LWECiphertext[uint32], 80)
-			res2[index] = make([]tfhe.LWECiphertext[uint32], 80)
-
-			for j := 0; j < 80; j++ {
-				res1[index][j] = auxiliary.EncWithPublicKey_tfheb(uint32(segkey1[j]), pk)
-				res2[index][j] = auxiliary.EncWithPublicKey_tfheb(uint32(segkey2[j]), pk)
-			}
+			Encoded_Variants := DivideVariantsIntoSegments(peoples[index], datas[index].RsID, datas[index].Genotype)
+			EncryptAndSaveSegments(peoples[index], Encoded_Variants)
+			datas[index] = auxiliary.SampleGenotypes{}
 
 			<-ch
 			wg.Done()
 		}()
-
 	}
 
 	wg.Wait()
 
-	fmt.Printf("Finish Key Encryption of "+strconv.Itoa(Data_Len)+" Individuals in (%s)\n", time.Since(now))
+	fmt.Printf("Finish Data Encryption and Save of "+strconv.Itoa(len(peoples))+" Individuals with "+strconv.Itoa(len(rejects))+" Rejects in (%s)\n", time.Since(now))
+
+	return rejects
+}
 
+// Read all ciphertext segments of an individual, together with their IV versions
+func ReadAllSegments(people auxiliary.People) (Segments [][]Variant, versions []int) {
+	sf := openSegmentFile(people)
+	defer sf.Close()
+
+	Segments = make([][]Variant, sf.Header.Seg_num)
+	versions = make([]int, sf.Header.Seg_num)
+	for i := 0; i < sf.Header.Seg_num; i++ {
+		var err error
+		Segments[i], versions[i], err = sf.ReadSegment(i)
+		if err != nil {
+			log.Fatalf("can not read, err is %+v", err)
+		}
+	}
 	return
 }
 
-// Get Ciphertext SegKey with Public Key, option decide whehter Hosted Mode,then appid is useless
-func GetSegKeyFromPKForAppID(pk auxiliary.PublicKey_tfheb, appid int, batch_size int, Indiv []auxiliary.People, option bool) (res1, res2 [][]tfhe.LWECiphertext[uint32]) {
-	Data_Len := len(Indiv)
+// Decrypt all segments of an individual with the raw keys, padding variants are removed
+func DecryptSegmentsToSample(people auxiliary.People) (sample auxiliary.SampleGenotypes) {
+	keys := LoadRawKeys(people)
+
+	// The stream cipher is an XOR, so encrypting the ciphertext again gives the plaintext
+	Segments, versions := ReadAllSegments(people)
+	dec_data := Data_Enc_Version(Segments, keys, versions)
+
+	sample.Name = people.Name
+	for i := 0; i < len(dec_data); i++ {
+		for j := 0; j < len(dec_data[i]); j++ {
+			rsid := Decode_rsID(dec_data[i][j].Rsid)
+			genotype := Decode_Genotype(dec_data[i][j].Genotype)
+			if rsid == 0 && genotype == 0 {
+				continue
+			}
+			sample.RsID = append(sample.RsID, rsid)
+			sample.Genotype = append(sample.Genotype, genotype)
+		}
+	}
+	return
+}
 
-	res1 = make([][]tfhe.LWECiphertext[uint32], Data_Len)
-	res2 = make([][]tfhe.LWECiphertext[uint32], Data_Len)
+// Read a ciphertext segment, along with the key hashes of the key holders
+func ReadSegmentData(people auxiliary.People, segID int, batch_size int) (Variants []Variant, keyhashes [][]byte) {
+	sf := openSegmentFile(people)
+	defer sf.Close()
+
+	Variants, _, err := sf.ReadSegment(segID)
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	return Variants, sf.Header.Keyhashes
+}
+
+// Read the IV version of a segment
+func ReadSegmentVersion(people auxiliary.People, segID int) int {
+	sf := openSegmentFile(people)
+	defer sf.Close()
+
+	version, err := sf.SegmentVersion(segID)
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	return version
+}
+
+// Read the key hashes of the key holders, keyhashes[k-1] is that of key holder k
+func ReadKeyhashes(people auxiliary.People) [][]byte {
+	sf := openSegmentFile(people)
+	defer sf.Close()
+	return sf.Header.Keyhashes
+}
+
+// Generate and Save tfheb key
+func GenAndSaveKey(params tfhe.Parameters[uint32], passphrase string) *tfhe.BinaryEncryptor {
+	enc := tfhe.NewBinaryEncryptor(params)
+	pk := auxiliary.GenLWEPublicKey_tfheb(enc)
+	Save_SK(enc, passphrase)
+	Save_PK(pk)
+	return enc
+}
+
+// Get Ciphertext SegKey with Public Key, the key shares of the loaded key holders of each individual
+func GetSegKeyFromPK(pk auxiliary.PublicKey_tfheb, rsid int, batch_size int, Indiv []auxiliary.People) []SegKeys {
+	seg_num := auxiliary.ReadSegParams().Seg_num
+	segIDs := make([]int, len(Indiv))
+	for i := 0; i < len(Indiv); i++ {
+		segIDs[i] = auxiliary.SegmentID(Indiv[i], rsid, seg_num)
+	}
+	return encryptKeyShares(pk, segIDs, 1, Indiv)
+}
+
+// Get Ciphertext SegKey with Public Key for an application ID, the hosted shares do not depend on it
+func GetSegKeyFromPKForAppID(pk auxiliary.PublicKey_tfheb, appid int, batch_size int, Indiv []auxiliary.People) []SegKeys {
+	segIDs := make([]int, len(Indiv))
+	for i := 0; i < len(Indiv); i++ {
+		segIDs[i] = appid
+	}
+	return encryptKeyShares(pk, segIDs, batch_size, Indiv)
+}
+
+// Encrypt the key shares of each individual for its segment or application ID with the public key
+func encryptKeyShares(pk auxiliary.PublicKey_tfheb, segIDs []int, batch_size int, Indiv []auxiliary.People) []SegKeys {
+	auxiliary.AssertNotErased(Indiv)
+	Data_Len := len(Indiv)
+	res := make([]SegKeys, Data_Len)
 
 	now := time.Now()
 
@@ -336,28 +514,22 @@ func GetSegKeyFromPKForAppID(pk auxiliary.PublicKey_tfheb, appid int, batch_size
 	wg.Add(Data_Len)
 	numCores := runtime.NumCPU()
 
-	ch := make(chan struct{}, numCores/2)
+	ch := make(chan struct{}, max(numCores/2, 1))
 
 	for i := 0; i < Data_Len; i++ {
 		index := i
 		ch <- struct{}{}
 		go func() {
-			keyinfo1, _ := GenerateRawKey(Indiv[index], 1)
-			keyinfo2, _ := GenerateRawKey(Indiv[index], 2)
-			var segkey1, segkey2 []int
-			if option {
-				segkey1 = GenKeyHostedMode(keyinfo1, batch_size)
-				segkey2 = GenSegmentKey(keyinfo2, appid, batch_size)
-			} else {
-				segkey1 = GenSegmentKey(keyinfo1, appid, batch_size)
-				segkey2 = GenKeyHostedMode(keyinfo2, batch_size)
-			}
-			res1[index] = make([]tfhe.LWECiphertext[uint32], 80)
-			res2[index] = make([]tfhe.LWECiphertext[uint32], 80)
-
-			for j := 0; j < 80; j++ {
-				res1[index][j] = auxiliary.EncWithPublicKey_tfheb(uint32(segkey1[j]), pk)
-				res2[index][j] = auxiliary.EncWithPublicKey_tfheb(uint32(segkey2[j]), pk)
+			keys := LoadRawKeys(Indiv[index])
+			res[index] = NewSegKeys(keys.Sharing)
+
+			for k := range keys.Keyinfo {
+				share := keys.KeyShare(k, segIDs[index], batch_size)
+				ct := make([]tfhe.LWECiphertext[uint32], len(share))
+				for j := 0; j < len(share); j++ {
+					ct[j] = auxiliary.EncWithPublicKey_tfheb(uint32(share[j]), pk)
+				}
+				res[index].Keys[k] = ct
 			}
 
 			<-ch
@@ -370,11 +542,13 @@ func GetSegKeyFromPKForAppID(pk auxiliary.PublicKey_tfheb, appid int, batch_size
 
 	fmt.Printf("Finish Key Encryption of "+strconv.Itoa(Data_Len)+" Individuals in (%s)\n", time.Since(now))
 
-	return
+	return res
 }
 
 // Get the Ciphertext Data Segment for Calculation
-func GetCiphertextData(rsid int, eval *tfhe.BinaryEvaluator, batch_size int, Indiv []auxiliary.People, option bool) [][]Variant_TFHE {
+func GetCiphertextData(rsid int, eval *tfhe.BinaryEvaluator, batch_size int, Indiv []auxiliary.People) [][]Variant_TFHE {
+	auxiliary.AssertNotErased(Indiv)
+	seg_num := auxiliary.ReadSegParams().Seg_num
 	Data_Len := len(Indiv)
 
 	now := time.Now()
@@ -385,15 +559,17 @@ func GetCiphertextData(rsid int, eval *tfhe.BinaryEvaluator, batch_size int, Ind
 	var wg sync.WaitGroup
 	wg.Add(Data_Len)
 
-	ch := make(chan struct{}, numCores/2)
+	ch := make(chan struct{}, max(numCores/2, 1))
+
+	datahash := ReadDataHash()
 
 	for i := 0; i < Data_Len; i++ {
 		index := i
 		ch <- struct{}{}
 
 		go func() {
-			seg_ID := auxiliary.SegmentID(Indiv[index], rsid, auxiliary.Seg_num)
-			Seg, _, _ := ReadSegmentData(Indiv[index], seg_ID, batch_size, option)
+			seg_ID := auxiliary.SegmentID(Indiv[index], rsid, seg_num)
+			Seg := ReadSegmentDataVerified(Indiv[index], seg_ID, datahash)
 			Data[index] = make([]Variant_TFHE, len(Seg))
 			for j := 0; j < len(Seg); j++ {
 				Data[index][j] = Enc_Variant_Raw(Seg[j], eval.Parameters)
@@ -411,28 +587,31 @@ func GetCiphertextData(rsid int, eval *tfhe.BinaryEvaluator, batch_size int, Ind
 }
 
 // Recover the data for calculation in ciphertext
-func Data_Recover(eval *tfhe.BinaryEvaluator, Data [][]Variant_TFHE, segkey1 [][]tfhe.LWECiphertext[uint32], segkey2 [][]tfhe.LWECiphertext[uint32], Indiv []auxiliary.People, rsid int, option bool) [][]Variant_TFHE {
+func Data_Recover(eval *tfhe.BinaryEvaluator, Data [][]Variant_TFHE, segkeys []SegKeys, Indiv []auxiliary.People, rsid int) [][]Variant_TFHE {
+	seg_num := auxiliary.ReadSegParams().Seg_num
 	Data_Len := len(Data)
 	now := time.Now()
 
 	Dec_Data := make([][]Variant_TFHE, Data_Len)
+	cipher := auxiliary.ReadCipher()
 
 	var wg sync.WaitGroup
 	wg.Add(Data_Len)
 	numCores := runtime.NumCPU()
 
-	ch := make(chan struct{}, numCores/2)
+	ch := make(chan struct{}, max(numCores/2, 1))
 
 	for i := 0; i < Data_Len; i++ {
 		index := i
 		ch <- struct{}{}
 		go func() {
-			seg_ID := auxiliary.SegmentID(Indiv[index], rsid, auxiliary.Seg_num)
-			iv := GenIVHostedMode(seg_ID)
-			var triv Trivium_TFHE
-			triv.Init(segkey1[index], segkey2[index], eval.ShallowCopy(), iv)
+			seg_ID := auxiliary.SegmentID(Indiv[index], rsid, seg_num)
+			iv := SegmentIV(cipher, seg_ID, ReadSegmentVersion(Indiv[index], seg_ID))
+			new_eval := eval.ShallowCopy()
+			triv := NewHomomorphicStreamCipher(cipher, 1)
+			triv.Init(segkeys[index].Combine(new_eval), new_eval, iv)
 
-			Dec_Data[index] = DecCiphertextBySegKey(Data[index], &triv, eval.ShallowCopy())
+			Dec_Data[index] = DecCiphertextBySegKey(Data[index], triv, eval.ShallowCopy())
 			<-ch
 			wg.Done()
 		}()
@@ -471,7 +650,7 @@ func GetDistribute(rsid int, eval *tfhe.BinaryEvaluator, Dec_Data [][]Variant_TF
 	wg.Add(Data_Len)
 	numCores := runtime.NumCPU()
 
-	ch := make(chan struct{}, numCores/2)
+	ch := make(chan struct{}, max(numCores/2, 1))
 
 	for i := 0; i < Data_Len; i++ {
 		index := i
@@ -506,15 +685,15 @@ func GetDistribute(rsid int, eval *tfhe.BinaryEvaluator, Dec_Data [][]Variant_TF
 }
 
 // query a rsid in ciphertext
-func QueryCiphertext(rsid int, segkey1, segkey2 [][]tfhe.LWECiphertext[uint32], eval *tfhe.BinaryEvaluator, batch_size int, Indiv []auxiliary.People, option bool) []BigValueCiphertext {
+func QueryCiphertext(rsid int, segkeys []SegKeys, eval *tfhe.BinaryEvaluator, batch_size int, Indiv []auxiliary.People) []BigValueCiphertext {
 
 	Data_Len := len(Indiv)
 
 	fmt.Println("Processing Query of " + strconv.Itoa(Data_Len) + " individuals...")
 
-	Data := GetCiphertextData(rsid, eval, batch_size, Indiv, option)
+	Data := GetCiphertextData(rsid, eval, batch_size, Indiv)
 
-	Dec_Data := Data_Recover(eval, Data, segkey1, segkey2, Indiv, rsid, option)
+	Dec_Data := Data_Recover(eval, Data, segkeys, Indiv, rsid)
 
 	res := GetDistribute(rsid, eval, Dec_Data)
 
@@ -523,10 +702,10 @@ func QueryCiphertext(rsid int, segkey1, segkey2 [][]tfhe.LWECiphertext[uint32],
 }
 
 // Get Ciphertext CODIS Data
-func GetCodisDataCiphtertext(eval *tfhe.BinaryEvaluator, Data_Len int, batch_size int, option bool) []CODIS_TFHE {
+func GetCodisDataCiphtertext(eval *tfhe.BinaryEvaluator, Data_Len int, batch_size int) []CODIS_TFHE {
 	now := time.Now()
 	dicpath := auxiliary.ReadPath()
-	rawdata, _, _ := ReadCODISData(batch_size, option, dicpath)
+	rawdata, _ := ReadCODISData(batch_size, dicpath)
 	triv_rawdata := Encode_CODIS(rawdata)
 	Data := make([]CODIS_TFHE, Data_Len)
 
@@ -535,7 +714,7 @@ func GetCodisDataCiphtertext(eval *tfhe.BinaryEvaluator, Data_Len int, batch_siz
 	var wg sync.WaitGroup
 	wg.Add(Data_Len)
 
-	ch := make(chan struct{}, numCores/2)
+	ch := make(chan struct{}, max(numCores/2, 1))
 
 	for i := 0; i < Data_Len; i++ {
 		index := i
@@ -555,30 +734,29 @@ func GetCodisDataCiphtertext(eval *tfhe.BinaryEvaluator, Data_Len int, batch_siz
 	return Data
 }
 
-// Data Recover for CODIS Data
-func Data_Recover_CODIS(eval *tfhe.BinaryEvaluator, Data_Len int, Data []CODIS_TFHE, segkey1 [][]tfhe.LWECiphertext[uint32], segkey2 [][]tfhe.LWECiphertext[uint32], option bool) []CODIS_TFHE {
+// Data Recover for CODIS Data, Indiv in the order of the data as ReadCODISData
+func Data_Recover_CODIS(eval *tfhe.BinaryEvaluator, Data_Len int, Data []CODIS_TFHE, segkeys []SegKeys, Indiv []auxiliary.People) []CODIS_TFHE {
 	now := time.Now()
 
 	Dec_Data := make([]CODIS_TFHE, Data_Len)
+	cipher := auxiliary.ReadCipher()
 
 	var wg sync.WaitGroup
 	wg.Add(Data_Len)
 
 	numCores := runtime.NumCPU()
 
-	ch := make(chan struct{}, numCores/2)
+	ch := make(chan struct{}, max(numCores/2, 1))
 
 	for i := 0; i < Data_Len; i++ {
 		index := i
 		ch <- struct{}{}
 		go func() {
+			appid := applications.App_id_SearchPerson()
+			iv := GenIVHostedMode(appid, cipher.IVBits())
+			triv := InitCachedStreamCipher(Indiv[index], appid, cipher, segkeys[index], iv, eval.ShallowCopy(), 1)
 
-			iv := GenIVHostedMode(applications.App_id_SearchPerson)
-
-			var triv Trivium_TFHE
-			triv.Init(segkey1[index], segkey2[index], eval.ShallowCopy(), iv)
-
-			Dec_Data[index] = DecCODISCiphertextBySegKey(Data[index], &triv, eval.ShallowCopy())
+			Dec_Data[index] = DecCODISCiphertextBySegKey(Data[index], triv, eval.ShallowCopy())
 			<-ch
 			wg.Done()
 		}()
@@ -602,7 +780,7 @@ func CODIS_Set_Comparasion(Data_Len int, Dec_Data []CODIS_TFHE, eval *tfhe.Binar
 
 	numCores := runtime.NumCPU()
 
-	ch := make(chan struct{}, numCores/2)
+	ch := make(chan struct{}, max(numCores/2, 1))
 
 	for i := 0; i < Data_Len; i++ {
 		index := i
@@ -621,12 +799,12 @@ func CODIS_Set_Comparasion(Data_Len int, Dec_Data []CODIS_TFHE, eval *tfhe.Binar
 }
 
 // query a person in ciphertext
-func SearchPerson(QueryCODIS CODIS_TFHE, segkey1, segkey2 [][]tfhe.LWECiphertext[uint32], eval *tfhe.BinaryEvaluator, Data_Len int, batch_size int, option bool) (res []tfhe.LWECiphertext[uint32]) {
+func SearchPerson(QueryCODIS CODIS_TFHE, segkeys []SegKeys, eval *tfhe.BinaryEvaluator, Data_Len int, batch_size int) (res []tfhe.LWECiphertext[uint32]) {
 
 	fmt.Println("Processing Person Searching in " + strconv.Itoa(Data_Len) + " individuals...")
-	Data := GetCodisDataCiphtertext(eval, Data_Len, batch_size, option)
+	Data := GetCodisDataCiphtertext(eval, Data_Len, batch_size)
 
-	Dec_Data := Data_Recover_CODIS(eval, Data_Len, Data, segkey1, segkey2, option)
+	Dec_Data := Data_Recover_CODIS(eval, Data_Len, Data, segkeys, auxiliary.ReadIndividuals())
 
 	res = CODIS_Set_Comparasion(Data_Len, Dec_Data, eval, QueryCODIS)
 
@@ -635,10 +813,10 @@ func SearchPerson(QueryCODIS CODIS_TFHE, segkey1, segkey2 [][]tfhe.LWECiphertext
 }
 
 // A user can query his rsid
-func Userquery(people auxiliary.People, rsid int, segkey1, segkey2 []tfhe.LWECiphertext[uint32], batch_size int, eval *tfhe.BinaryEvaluator, option bool) (res [4]tfhe.LWECiphertext[uint32]) {
+func Userquery(people auxiliary.People, rsid int, segkeys SegKeys, batch_size int, eval *tfhe.BinaryEvaluator) (res [auxiliary.Genotype_Bits]tfhe.LWECiphertext[uint32]) {
 
-	seg_ID := auxiliary.SegmentID(people, rsid, auxiliary.Seg_num)
-	Seg, _, _ := ReadSegmentData(people, seg_ID, batch_size, option)
+	seg_ID := auxiliary.SegmentID(people, rsid, auxiliary.ReadSegParams().Seg_num)
+	Seg := ReadSegmentDataVerified(people, seg_ID, ReadDataHash())
 
 	var QueryVariant Variant
 	QueryVariant.Rsid = Encode_rsID(rsid)
@@ -651,20 +829,18 @@ func Userquery(people auxiliary.People, rsid int, segkey1, segkey2 []tfhe.LWECip
 
 	now := time.Now()
 
-	iv := make([]int, 80)
-	if option {
-		iv = GenIVHostedMode(seg_ID)
-	}
-	var triv Trivium_TFHE
-	triv.Init(segkey1, segkey2, eval, iv)
+	cipher := auxiliary.ReadCipher()
+	iv := SegmentIV(cipher, seg_ID, ReadSegmentVersion(people, seg_ID))
+	triv := NewHomomorphicStreamCipher(cipher, runtime.NumCPU())
+	triv.Init(segkeys.Combine(eval), eval, iv)
 
-	Dec_ct := DecCiphertextBySegKey(Data_ct, &triv, eval)
+	Dec_ct := DecCiphertextBySegKey(Data_ct, triv, eval)
 
 	res = Compare_RSID_TFHE(Dec_ct[0], QueryVariant_TFHE, eval)
 
 	for i := 1; i < len(Dec_ct); i++ {
 		c := Compare_RSID_TFHE(Dec_ct[i], QueryVariant_TFHE, eval)
-		for j := 0; j < 4; j++ {
+		for j := 0; j < auxiliary.Genotype_Bits; j++ {
 			res[j] = eval.OR(res[j], c[j])
 		}
 	}
@@ -675,7 +851,7 @@ func Userquery(people auxiliary.People, rsid int, segkey1, segkey2 []tfhe.LWECip
 
 }
 
-// Get genotype => BigvalueCiphertext, not consideration the situation of 0|2 or larger
+// Get genotype => BigvalueCiphertext, the number of non-reference alleles, 0 for missing genotypes
 func GetMergedGenotype(rsid int, eval *tfhe.BinaryEvaluator, Dec_Data [][]Variant_TFHE) []BigValueCiphertext {
 	Data_Len := len(Dec_Data)
 	var QueryVariant Variant
@@ -691,7 +867,7 @@ func GetMergedGenotype(rsid int, eval *tfhe.BinaryEvaluator, Dec_Data [][]Varian
 	wg.Add(Data_Len)
 	numCores := runtime.NumCPU()
 
-	ch := make(chan struct{}, numCores/2)
+	ch := make(chan struct{}, max(numCores/2, 1))
 
 	for i := 0; i < Data_Len; i++ {
 		index := i
@@ -700,11 +876,12 @@ func GetMergedGenotype(rsid int, eval *tfhe.BinaryEvaluator, Dec_Data [][]Varian
 		go func() {
 
 			for j := 0; j < len(Dec_Data[index]); j++ {
-				gt := Compare_RSID_TFHE(Dec_Data[index][j], QueryVariant_TFHE, new_eval)
+				gt := GenotypeFromTwoVariants(Dec_Data[index][j], QueryVariant_TFHE, new_eval)
 				temp1 := NewBigValueCiphertext(new_eval.Parameters)
 				temp2 := NewBigValueCiphertext(new_eval.Parameters)
-				temp1.Values[0] = gt[0]
+				temp1.Values[0] = gt[1]
 				temp2.Values[0] = gt[2]
+				temp2 = Mul2(temp2, new_eval.Parameters)
 				res[index] = AddBigValueCiphertext(res[index], temp1, new_eval)
 				res[index] = AddBigValueCiphertext(res[index], temp2, new_eval)
 				res[index].UpperBound = 2
@@ -721,15 +898,15 @@ func GetMergedGenotype(rsid int, eval *tfhe.BinaryEvaluator, Dec_Data [][]Varian
 }
 
 // Perform a Boolean GWAS in ciphertext
-func GWASBool(rsid int, segkey1, segkey2 [][]tfhe.LWECiphertext[uint32], eval *tfhe.BinaryEvaluator, batch_size int, Indiv []auxiliary.People, phenotype []BigValueCiphertext, option bool) (Fix16, Int72Ciphertext) {
+func GWASBool(rsid int, segkeys []SegKeys, eval *tfhe.BinaryEvaluator, batch_size int, Indiv []auxiliary.People, phenotype []BigValueCiphertext) (Fix16, Int72Ciphertext) {
 
 	Data_Len := len(Indiv)
 
 	fmt.Println("Processing GWAS of " + strconv.Itoa(Data_Len) + " individuals...")
 
-	Data := GetCiphertextData(rsid, eval, batch_size, Indiv, option)
+	Data := GetCiphertextData(rsid, eval, batch_size, Indiv)
 
-	Dec_Data := Data_Recover(eval, Data, segkey1, segkey2, Indiv, rsid, option)
+	Dec_Data := Data_Recover(eval, Data, segkeys, Indiv, rsid)
 
 	now := time.Now()
 
@@ -27,48 +27,55 @@
 package trivium
 
 import (
+	"Governome/applications"
 	"Governome/auxiliary"
-	"bytes"
-	"encoding/csv"
 	"math"
 	"math/big"
-	"os"
 	"strconv"
-
-	"github.com/sp301415/tfhe-go/tfhe"
 )
 
 const PointNum = 10
 
 var Batch_Size_Set = [PointNum]int{1, 2, 4, 5, 8, 10, 16, 20, 40, 80}
 
-// This function generate the Key Information held by key holders, along with its public hash value
-// keyholderID: 1, 2, ...
-func GenerateRawKey(people auxiliary.People, keyholderID int) ([]byte, []byte) {
-	rawstr := make([]string, 1)
-	rawstr[0] = "Welcome to Governome, key holder" + strconv.Itoa(keyholderID) + " of " + people.Name + "!"
+// Key Information of a key holder before the keystore, the MiMC hash of a public string, with its public hash value
+// it is only used to decrypt the legacy csv segments, which are then encrypted again with the keys of the keystore
+func LegacyRawKey(people auxiliary.People, keyholderID int) ([]byte, []byte) {
+	rawstr := []string{"Welcome to Governome, key holder" + strconv.Itoa(keyholderID) + " of " + people.Name + "!"}
 	keyinfo, _ := auxiliary.MimcHash(rawstr, auxiliary.Curve, auxiliary.Mimchashcurve)
 	keyhash, _ := auxiliary.MimcHashRaw(keyinfo, auxiliary.Mimchashcurve)
 	return keyinfo, keyhash
 }
 
-// This function generate the segment key by keyinfo and segmentID
-func GenSegmentKey(keyinfo []byte, segmentID int, batch_size int) []int {
+// MiMC input after the keyinfo for batch k of the key of a segment or application ID: a segment hashes segID * key_bits + k + 1,
+// an application hashes its tag first, then index * key_bits + k + 1, so its keys are apart from the keys of segments
+func keyDomainInput(segmentID int, key_bits int, k int) []byte {
+	tag, index := applications.KeyDomain(segmentID)
+	temp := big.NewInt(int64(index*key_bits + k + 1)).Bytes()
+	return append(tag, auxiliary.PadBytes(temp, auxiliary.Mimchashcurve.Size())...)
+}
+
+// MiMC input of the IV of a segment or application ID, the segment ID, or the tag and the index of an application
+func ivDomainInput(segmentID int) []byte {
+	tag, index := applications.KeyDomain(segmentID)
+	temp := big.NewInt(int64(index)).Bytes()
+	return append(tag, auxiliary.PadBytes(temp, auxiliary.Mimchashcurve.Size())...)
+}
+
+// This function generate the segment key of key_bits bits by keyinfo and segmentID, an AppID of applications/registry.go takes the place of segmentID for an application
+func GenSegmentKey(keyinfo []byte, segmentID int, key_bits int, batch_size int) []int {
 	length := ((len(keyinfo)-1)/auxiliary.Mimchashcurve.Size() + 1) * auxiliary.Mimchashcurve.Size()
 	keyinfo = auxiliary.PadBytes(keyinfo, length)
 
-	batchnum := int(math.Ceil(80 / float64(batch_size)))
+	batchnum := int(math.Ceil(float64(key_bits) / float64(batch_size)))
 
-	// In each batch, we get batch_size bits by mimc(keyinfo, segID * 80 + batchID + 1)
-	key := make([]int, 80)
+	// In each batch, we get batch_size bits by mimc(keyinfo, segID * key_bits + batchID + 1), 80 for Trivium, see keyDomainInput
+	key := make([]int, key_bits)
 	for k := 0; k < batchnum; k++ {
-		testval := segmentID*80 + k + 1
-		temp := big.NewInt(int64(testval)).Bytes()
-		temp = auxiliary.PadBytes(temp, auxiliary.Mimchashcurve.Size())
-		temp = append(keyinfo, temp...)
+		temp := append(append([]byte{}, keyinfo...), keyDomainInput(segmentID, key_bits, k)...)
 		subhash, _ := auxiliary.MimcHashRaw(temp, auxiliary.Mimchashcurve)
 		hashval := big.NewInt(1).SetBytes(subhash)
-		for i := 0; i < batch_size && k*batch_size+i < 80; i++ {
+		for i := 0; i < batch_size && k*batch_size+i < key_bits; i++ {
 			bigk := big.NewInt(1).And(big.NewInt(1), hashval)
 			key[k*batch_size+i] = int(bigk.Uint64())
 			hashval = big.NewInt(1).Rsh(hashval, 1)
@@ -78,14 +85,14 @@ func GenSegmentKey(keyinfo []byte, segmentID int, batch_size int) []int {
 	return key
 }
 
-// In hosted mode, every segment share a same key, but the iv is different
-func GenKeyHostedMode(keyinfo []byte, batch_size int) []int {
+// In hosted mode, every segment share a same key of key_bits bits, but the iv is different
+func GenKeyHostedMode(keyinfo []byte, key_bits int, batch_size int) []int {
 	length := ((len(keyinfo)-1)/auxiliary.Mimchashcurve.Size() + 1) * auxiliary.Mimchashcurve.Size()
 	keyinfo = auxiliary.PadBytes(keyinfo, length)
-	batchnum := int(math.Ceil(80 / float64(batch_size)))
+	batchnum := int(math.Ceil(float64(key_bits) / float64(batch_size)))
 
 	// In each batch, we get batch_size bits by mimc(keyinfo, batchID + 1)
-	key := make([]int, 80)
+	key := make([]int, key_bits)
 	for k := 0; k < batchnum; k++ {
 		testval := k + 1
 		temp := big.NewInt(int64(testval)).Bytes()
@@ -93,7 +100,7 @@ func GenKeyHostedMode(keyinfo []byte, batch_size int) []int {
 		temp = append(keyinfo, temp...)
 		subhash, _ := auxiliary.MimcHashRaw(temp, auxiliary.Mimchashcurve)
 		hashval := big.NewInt(1).SetBytes(subhash)
-		for i := 0; i < batch_size && k*batch_size+i < 80; i++ {
+		for i := 0; i < batch_size && k*batch_size+i < key_bits; i++ {
 			bigk := big.NewInt(1).And(big.NewInt(1), hashval)
 			key[k*batch_size+i] = int(bigk.Uint64())
 			hashval = big.NewInt(1).Rsh(hashval, 1)
@@ -104,14 +111,14 @@ func GenKeyHostedMode(keyinfo []byte, batch_size int) []int {
 }
 
 // Generate Hosted key for zk-snarks
-func GenKeyHostedModeWithQuo(keyinfo []byte, batch_size int) ([]int, []*big.Int) {
+func GenKeyHostedModeWithQuo(keyinfo []byte, key_bits int, batch_size int) ([]int, []*big.Int) {
 	length := ((len(keyinfo)-1)/auxiliary.Mimchashcurve.Size() + 1) * auxiliary.Mimchashcurve.Size()
 	keyinfo = auxiliary.PadBytes(keyinfo, length)
 
-	batchnum := int(math.Ceil(80 / float64(batch_size)))
+	batchnum := int(math.Ceil(float64(key_bits) / float64(batch_size)))
 
 	// In each batch, we get batch_size bits by mimc(keyinfo, batchID + 1)
-	key := make([]int, 80)
+	key := make([]int, key_bits)
 	res := make([]*big.Int, batchnum)
 	for k := 0; k < batchnum; k++ {
 		testval := k + 1
@@ -120,7 +127,7 @@ func GenKeyHostedModeWithQuo(keyinfo []byte, batch_size int) ([]int, []*big.Int)
 		temp = append(keyinfo, temp...)
 		subhash, _ := auxiliary.MimcHashRaw(temp, auxiliary.Mimchashcurve)
 		hashval := big.NewInt(1).SetBytes(subhash)
-		for i := 0; i < batch_size && k*batch_size+i < 80; i++ {
+		for i := 0; i < batch_size && k*batch_size+i < key_bits; i++ {
 			bigk := big.NewInt(1).And(big.NewInt(1), hashval)
 			key[k*batch_size+i] = int(bigk.Uint64())
 			hashval = big.NewInt(1).Rsh(hashval, 1)
@@ -131,15 +138,33 @@ func GenKeyHostedModeWithQuo(keyinfo []byte, batch_size int) ([]int, []*big.Int)
 	return key, res
 }
 
-// In hosted mode, every segment share a same key, but the iv is different, based on the segmentID
-func GenIVHostedMode(segmentID int) []int {
-	iv := make([]int, 80)
+// In hosted mode, every segment share a same key, but the iv of iv_bits bits is different, based on the segmentID
+func GenIVHostedMode(segmentID int, iv_bits int) []int {
+	iv := make([]int, iv_bits)
+
+	subhash, _ := auxiliary.MimcHashRaw(ivDomainInput(segmentID), auxiliary.Mimchashcurve)
+	hashval := big.NewInt(1).SetBytes(subhash)
+	for i := 0; i < iv_bits; i++ {
+		bigk := big.NewInt(1).And(big.NewInt(1), hashval)
+		iv[i] = int(bigk.Uint64())
+		hashval = big.NewInt(1).Rsh(hashval, 1)
+	}
+	return iv
+}
+
+// IV of a segment after it has been updated version times, version 0 is the IV of GenIVHostedMode
+// each update takes a new IV, so the keystream of a segment key is never reused for different plaintexts
+func GenIVVersion(segmentID int, version int, iv_bits int) []int {
+	if version == 0 {
+		return GenIVHostedMode(segmentID, iv_bits)
+	}
+	iv := make([]int, iv_bits)
 
-	temp := big.NewInt(int64(segmentID)).Bytes()
-	temp = auxiliary.PadBytes(temp, auxiliary.Mimchashcurve.Size())
+	temp := ivDomainInput(segmentID)
+	temp = append(temp, auxiliary.PadBytes(big.NewInt(int64(version)).Bytes(), auxiliary.Mimchashcurve.Size())...)
 	subhash, _ := auxiliary.MimcHashRaw(temp, auxiliary.Mimchashcurve)
 	hashval := big.NewInt(1).SetBytes(subhash)
-	for i := 0; i < 80; i++ {
+	for i := 0; i < iv_bits; i++ {
 		bigk := big.NewInt(1).And(big.NewInt(1), hashval)
 		iv[i] = int(bigk.Uint64())
 		hashval = big.NewInt(1).Rsh(hashval, 1)
@@ -148,23 +173,20 @@ func GenIVHostedMode(segmentID int) []int {
 }
 
 // Generate Segment key for zk-snarks
-func GenSegmentKeyWithQuo(keyinfo []byte, segmentID int, batch_size int) ([]int, []*big.Int) {
+func GenSegmentKeyWithQuo(keyinfo []byte, segmentID int, key_bits int, batch_size int) ([]int, []*big.Int) {
 	length := ((len(keyinfo)-1)/auxiliary.Mimchashcurve.Size() + 1) * auxiliary.Mimchashcurve.Size()
 	keyinfo = auxiliary.PadBytes(keyinfo, length)
 
-	batchnum := int(math.Ceil(80 / float64(batch_size)))
+	batchnum := int(math.Ceil(float64(key_bits) / float64(batch_size)))
 
-	// In each batch, we get batch_size bits by mimc(keyinfo | segID * 80 + batchID + 1)
-	key := make([]int, 80)
+	// In each batch, we get batch_size bits by mimc(keyinfo | segID * key_bits + batchID + 1), see keyDomainInput
+	key := make([]int, key_bits)
 	res := make([]*big.Int, batchnum)
 	for k := 0; k < batchnum; k++ {
-		testval := segmentID*80 + k + 1
-		temp := big.NewInt(int64(testval)).Bytes()
-		temp = auxiliary.PadBytes(temp, auxiliary.Mimchashcurve.Size())
-		temp = append(keyinfo, temp...)
+		temp := append(append([]byte{}, keyinfo...), keyDomainInput(segmentID, key_bits, k)...)
 		subhash, _ := auxiliary.MimcHashRaw(temp, auxiliary.Mimchashcurve)
 		hashval := big.NewInt(1).SetBytes(subhash)
-		for i := 0; i < batch_size && k*batch_size+i < 80; i++ {
+		for i := 0; i < batch_size && k*batch_size+i < key_bits; i++ {
 			bigk := big.NewInt(1).And(big.NewInt(1), hashval)
 			key[k*batch_size+i] = int(bigk.Uint64())
 			hashval = big.NewInt(1).Rsh(hashval, 1)
@@ -174,45 +196,3 @@ func GenSegmentKeyWithQuo(keyinfo []byte, segmentID int, batch_size int) ([]int,
 
 	return key, res
 }
-
-// Save secret key for tfheb
-func Save_SK(enc *tfhe.BinaryEncryptor) {
-	dicpath := auxiliary.ReadPath()
-
-	os.MkdirAll(dicpath+"/Key_Information/", os.ModePerm)
-
-	var buf bytes.Buffer
-	enc.BaseEncryptor.SecretKey.WriteTo(&buf)
-	os.WriteFile(dicpath+"/Key_Information/Trivium_SecretKey", buf.Bytes(), 0644)
-
-}
-
-// Save Public key for tfheb
-func Save_PK(pk auxiliary.PublicKey_tfheb) {
-	dicpath := auxiliary.ReadPath()
-	os.MkdirAll(dicpath+"/Key_Information/", os.ModePerm)
-	file_path := dicpath + "/Key_Information/Trivium_PublicKey.csv"
-	size := len(pk.B)
-	Data := make([][]string, size+1)
-
-	for i := 0; i < size; i++ {
-		Data[i] = make([]string, size)
-		for j := 0; j < size; j++ {
-			big_val := big.NewInt(1).SetUint64(uint64(pk.A[i][j]))
-			Data[i][j] = big_val.String()
-		}
-	}
-	Data[size] = make([]string, size)
-	for i := 0; i < size; i++ {
-		big_val := big.NewInt(1).SetUint64(uint64(pk.B[i]))
-		Data[size][i] = big_val.String()
-	}
-
-	f, _ := os.Create(file_path)
-
-	w := csv.NewWriter(f)
-
-	w.WriteAll(Data)
-	w.Flush()
-	f.Close()
-}
@@ -0,0 +1,389 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Key sharing between the key holders of an individual, see auxiliary.KeySharing
+// n-of-n: every key holder has its own keyinfo, the key of the cipher is the XOR of the key shares derived from them,
+// each key holder derives its share by its key mode, see auxiliary.KeyMode, recorded with the dataset,
+// with the default key modes and 2 key holders this is the original key schedule
+// t-of-n: pseudo-random secret sharing, a random value r_T is dealt for each set T of t-1 key holders to the key holders
+// outside T, see auxiliary.ShamirUnqualifiedSets, the keyinfo of a key holder is the concatenation of its values,
+// the key of a segment or application is the XOR of GenSegmentKey(r_T, segID) over all T and the share of key holder k
+// for it is a Shamir share over GF(2^8) of that key, see ThresholdShare, so any t key holders recover the key of a segment,
+// t-1 of them miss the value of their own set, and a SegKey does not give the key of another segment or application
+// the shares and keys have the bits of the cipher of the dataset, see auxiliary.Cipher
+
+// Keys of the key holders of an individual, Keyinfo[k] is the keyinfo of key holder k if it is loaded,
+// Keyhashes[k-1] is the public hash value of key holder k (nil if its key is lost in threshold mode)
+type RawKeys struct {
+	Sharing   auxiliary.KeySharing
+	Modes     auxiliary.KeyModes
+	Cipher    auxiliary.Cipher
+	Keyinfo   map[int][]byte
+	Keyhashes [][]byte
+}
+
+// Encrypted key shares of an individual for a segment or application, Keys[k] is the share of key holder k
+type SegKeys struct {
+	Sharing auxiliary.KeySharing
+	Keys    map[int][]tfhe.LWECiphertext[uint32]
+	Stored  bool // read from the uploaded SegKey files, so a later query gets the same ciphertexts
+}
+
+// Key share of key_bits bits derived from a keyinfo by a key mode for a segment or application ID, with batch_size bits per hash
+func ModeShare(mode auxiliary.KeyMode, keyinfo []byte, segID int, key_bits int, batch_size int) []int {
+	switch mode {
+	case auxiliary.KeyMode_Segment:
+		return GenSegmentKey(keyinfo, segID, key_bits, batch_size)
+	case auxiliary.KeyMode_Hosted:
+		return GenKeyHostedMode(keyinfo, key_bits, batch_size)
+	}
+	log.Fatalf("Unknown key mode %s", mode)
+	return nil
+}
+
+// Key share derived by a key mode, along with the quotients of the hashes for the proofs
+func ModeShareWithQuo(mode auxiliary.KeyMode, keyinfo []byte, segID int, key_bits int, batch_size int) ([]int, []*big.Int) {
+	switch mode {
+	case auxiliary.KeyMode_Segment:
+		return GenSegmentKeyWithQuo(keyinfo, segID, key_bits, batch_size)
+	case auxiliary.KeyMode_Hosted:
+		return GenKeyHostedModeWithQuo(keyinfo, key_bits, batch_size)
+	}
+	log.Fatalf("Unknown key mode %s", mode)
+	return nil, nil
+}
+
+// IV of the cipher for a segment or application ID, the same for all key modes, the version changes whenever the data
+// is encrypted again with the same key
+func SegmentIV(c auxiliary.Cipher, segID int, version int) []int {
+	return GenIVVersion(segID, version, c.IVBits())
+}
+
+// Bits per hash of the values of pseudo-random secret sharing, a byte of GF(2^8)
+const Threshold_Batch = 8
+
+// Whether a set of key holders has a key holder
+func inSet(set []int, keyholderID int) bool {
+	for _, m := range set {
+		if m == keyholderID {
+			return true
+		}
+	}
+	return false
+}
+
+// The sets of the values of a key holder in threshold mode, in the order of the values in its keyinfo
+func ThresholdSets(sharing auxiliary.KeySharing, keyholderID int) [][]int {
+	var res [][]int
+	for _, set := range auxiliary.ShamirUnqualifiedSets(sharing.Threshold, sharing.Holders) {
+		if !inSet(set, keyholderID) {
+			res = append(res, set)
+		}
+	}
+	return res
+}
+
+// The values of a keyinfo in threshold mode, a MiMC block each
+func ThresholdValues(keyinfo []byte) [][]byte {
+	size := auxiliary.Mimchashcurve.Size()
+	res := make([][]byte, len(keyinfo)/size)
+	for c := range res {
+		res[c] = keyinfo[c*size : (c+1)*size]
+	}
+	return res
+}
+
+// Key share of a key holder in threshold mode for a segment or application ID, byte j is the XOR over its values r_T of
+// f_T(k) * byte j of GenSegmentKey(r_T, segID) in GF(2^8), f_T is auxiliary.ShamirSetPoly, bit i is bit i%8 of byte i/8
+func ThresholdShare(sharing auxiliary.KeySharing, keyholderID int, keyinfo []byte, segID int, key_bits int) []int {
+	res := make([]int, key_bits)
+	values := ThresholdValues(keyinfo)
+	for c, set := range ThresholdSets(sharing, keyholderID) {
+		M := auxiliary.GF256_BitMatrix(auxiliary.ShamirSetPoly(set, keyholderID))
+		val := GenSegmentKey(values[c], segID, key_bits, Threshold_Batch)
+		for i := 0; i < key_bits; i++ {
+			j, r := i/8, i%8
+			for col := 0; col < 8 && 8*j+col < key_bits; col++ {
+				res[i] ^= M[r][col] & val[8*j+col]
+			}
+		}
+	}
+	return res
+}
+
+// Key share of a loaded key holder for a segment or application ID, with batch_size bits per hash as GenSegmentKey in n-of-n sharing
+func (rk *RawKeys) KeyShare(keyholderID int, segID int, batch_size int) []int {
+	if rk.Sharing.IsThreshold() {
+		return ThresholdShare(rk.Sharing, keyholderID, rk.Keyinfo[keyholderID], segID, rk.Cipher.KeyBits())
+	}
+	return ModeShare(rk.Modes[keyholderID-1], rk.Keyinfo[keyholderID], segID, rk.Cipher.KeyBits(), batch_size)
+}
+
+// Generate the keyinfos of all key holders, independent random ones or the values of pseudo-random secret sharing
+func dealKeyinfos(sharing auxiliary.KeySharing) [][]byte {
+	res := make([][]byte, sharing.Holders)
+	if !sharing.IsThreshold() {
+		for k := 0; k < sharing.Holders; k++ {
+			res[k] = randomKeyinfo()
+		}
+		return res
+	}
+	for _, set := range auxiliary.ShamirUnqualifiedSets(sharing.Threshold, sharing.Holders) {
+		value := randomKeyinfo()
+		for k := 0; k < sharing.Holders; k++ {
+			if !inSet(set, k+1) {
+				res[k] = append(res[k], value...)
+			}
+		}
+	}
+	return res
+}
+
+// Generate the keys of all key holders of an individual by the key sharing of the dataset, the passphrases of all key holders are needed
+func NewRawKeys(people auxiliary.People) *RawKeys {
+	sharing := auxiliary.ReadKeySharing()
+	auxiliary.SaveKeySharing(sharing)
+	modes := auxiliary.ReadKeyModes()
+	auxiliary.SaveKeyModes(modes)
+	cipher := auxiliary.ReadCipher()
+	auxiliary.SaveCipher(cipher)
+
+	rk := &RawKeys{Sharing: sharing, Modes: modes, Cipher: cipher, Keyinfo: make(map[int][]byte), Keyhashes: make([][]byte, sharing.Holders)}
+	for k, keyinfo := range dealKeyinfos(sharing) {
+		rk.Keyinfo[k+1] = keyinfo
+		rk.Keyhashes[k] = newRawKeyWith(people, k+1, keyinfo)
+	}
+	return rk
+}
+
+// Load the keys of an individual, all key holders are needed in n-of-n sharing,
+// in threshold mode the key holders without a passphrase or a key file are skipped, at least Threshold are needed
+func LoadRawKeys(people auxiliary.People) *RawKeys {
+	sharing := auxiliary.ReadKeySharing()
+	rk := &RawKeys{Sharing: sharing, Modes: auxiliary.ReadKeyModes(), Cipher: auxiliary.ReadCipher(), Keyinfo: make(map[int][]byte), Keyhashes: make([][]byte, sharing.Holders)}
+	for k := 1; k <= sharing.Holders; k++ {
+		if sharing.IsThreshold() {
+			if _, err := os.Stat(KeyFilePath(people, k)); err != nil {
+				continue
+			}
+			if !hasPassphrase(k) {
+				rk.Keyhashes[k-1] = ReadRawKeyhash(people, k)
+				continue
+			}
+		}
+		rk.Keyinfo[k], rk.Keyhashes[k-1] = LoadRawKey(people, k)
+		if sharing.IsThreshold() && len(rk.Keyinfo[k]) != sharing.HolderValues()*auxiliary.Mimchashcurve.Size() {
+			log.Fatalf("The key of key holder %d of %s is not of the threshold key sharing %+v, generate the keys under another root folder", k, people.Name, sharing)
+		}
+	}
+	if len(rk.Keyinfo) < sharing.Required() {
+		log.Fatalf("Only %d key holders of %s are given, %d are needed", len(rk.Keyinfo), people.Name, sharing.Required())
+	}
+	return rk
+}
+
+// Load the keys of an individual, new ones are generated if no key holder has a key yet
+func LoadOrNewRawKeys(people auxiliary.People) *RawKeys {
+	for k := 1; k <= auxiliary.ReadKeySharing().Holders; k++ {
+		if _, err := os.Stat(KeyFilePath(people, k)); err == nil {
+			return LoadRawKeys(people)
+		}
+	}
+	return NewRawKeys(people)
+}
+
+// Read the public hash values of the keys of all key holders, no passphrase is needed
+// a lost key in threshold mode gives nil
+func ReadRawKeyhashes(people auxiliary.People) [][]byte {
+	sharing := auxiliary.ReadKeySharing()
+	res := make([][]byte, sharing.Holders)
+	for k := 1; k <= sharing.Holders; k++ {
+		if _, err := os.Stat(KeyFilePath(people, k)); err != nil && sharing.IsThreshold() {
+			continue
+		}
+		res[k-1] = ReadRawKeyhash(people, k)
+	}
+	return res
+}
+
+// The loaded key holders in ascending order
+func (rk *RawKeys) holders() []int {
+	res := make([]int, 0, len(rk.Keyinfo))
+	for k := range rk.Keyinfo {
+		res = append(res, k)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// The values of pseudo-random secret sharing of all sets from the loaded key holders, any Threshold key holders know all of them
+func (rk *RawKeys) thresholdValues() [][]byte {
+	sets := auxiliary.ShamirUnqualifiedSets(rk.Sharing.Threshold, rk.Sharing.Holders)
+	res := make([][]byte, len(sets))
+	for _, k := range rk.holders() {
+		values := ThresholdValues(rk.Keyinfo[k])
+		c := 0
+		for index, set := range sets {
+			if !inSet(set, k) {
+				res[index] = values[c]
+				c++
+			}
+		}
+	}
+	for index := range res {
+		if res[index] == nil {
+			log.Fatalf("The loaded key holders do not know the value of key holders %v, %d are needed", sets[index], rk.Sharing.Threshold)
+		}
+	}
+	return res
+}
+
+// The part of the key that is the same for all segments, the XOR of the hosted shares, none in threshold mode
+func (rk *RawKeys) HostedKey() []int {
+	key := make([]int, rk.Cipher.KeyBits())
+	if rk.Sharing.IsThreshold() {
+		return key
+	}
+	for k, keyinfo := range rk.Keyinfo {
+		if rk.Modes[k-1] != auxiliary.KeyMode_Segment {
+			share := ModeShare(rk.Modes[k-1], keyinfo, 0, len(key), 1)
+			for i := 0; i < len(key); i++ {
+				key[i] ^= share[i]
+			}
+		}
+	}
+	return key
+}
+
+// The key of a segment or application ID, hosted is the result of HostedKey
+func (rk *RawKeys) SegmentKey(segID int, hosted []int) []int {
+	key := make([]int, rk.Cipher.KeyBits())
+	copy(key, hosted)
+	if rk.Sharing.IsThreshold() {
+		for _, value := range rk.thresholdValues() {
+			val := GenSegmentKey(value, segID, len(key), Threshold_Batch)
+			for i := 0; i < len(key); i++ {
+				key[i] ^= val[i]
+			}
+		}
+		return key
+	}
+	for k, keyinfo := range rk.Keyinfo {
+		if rk.Modes[k-1] == auxiliary.KeyMode_Segment {
+			share := ModeShare(rk.Modes[k-1], keyinfo, segID, len(key), 1)
+			for i := 0; i < len(key); i++ {
+				key[i] ^= share[i]
+			}
+		}
+	}
+	return key
+}
+
+// Stage new keys that replace the key of a key holder, return the keys after the replacement and the key holders to commit
+// in threshold mode a new key is dealt, so the shares of all key holders are replaced
+func stageRawKeys(people auxiliary.People, rk *RawKeys, keyholderID int) (*RawKeys, []int) {
+	new_rk := &RawKeys{Sharing: rk.Sharing, Modes: rk.Modes, Cipher: rk.Cipher, Keyinfo: make(map[int][]byte), Keyhashes: make([][]byte, rk.Sharing.Holders)}
+	copy(new_rk.Keyhashes, rk.Keyhashes)
+
+	if !rk.Sharing.IsThreshold() {
+		for k, keyinfo := range rk.Keyinfo {
+			new_rk.Keyinfo[k] = keyinfo
+		}
+		keyinfo := randomKeyinfo()
+		new_rk.Keyinfo[keyholderID] = keyinfo
+		new_rk.Keyhashes[keyholderID-1] = StageRawKey(people, keyholderID, keyinfo)
+		return new_rk, []int{keyholderID}
+	}
+
+	staged := make([]int, rk.Sharing.Holders)
+	for k, keyinfo := range dealKeyinfos(rk.Sharing) {
+		new_rk.Keyinfo[k+1] = keyinfo
+		new_rk.Keyhashes[k] = StageRawKey(people, k+1, keyinfo)
+		staged[k] = k + 1
+	}
+	return new_rk, staged
+}
+
+// New empty encrypted key shares
+func NewSegKeys(sharing auxiliary.KeySharing) SegKeys {
+	return SegKeys{Sharing: sharing, Keys: make(map[int][]tfhe.LWECiphertext[uint32])}
+}
+
+// Recover the encrypted key of the cipher, the XOR of all key shares, or the Lagrange interpolation of the first Threshold shares
+// multiplying by a public constant in GF(2^8) is linear over the bits, so both only need XORs
+func (sk SegKeys) Combine(eval *tfhe.BinaryEvaluator) []tfhe.LWECiphertext[uint32] {
+	holders := make([]int, 0, len(sk.Keys))
+	for k := range sk.Keys {
+		holders = append(holders, k)
+	}
+	sort.Ints(holders)
+	if len(holders) < sk.Sharing.Required() {
+		log.Fatalf("Only %d key shares are given, %d are needed", len(holders), sk.Sharing.Required())
+	}
+
+	key := make([]tfhe.LWECiphertext[uint32], len(sk.Keys[holders[0]]))
+	if !sk.Sharing.IsThreshold() {
+		for i := 0; i < len(key); i++ {
+			key[i] = sk.Keys[holders[0]][i].Copy()
+			for _, k := range holders[1:] {
+				key[i] = eval.XOR(key[i], sk.Keys[k][i])
+			}
+		}
+		return key
+	}
+
+	holders = holders[:sk.Sharing.Threshold]
+	lambda := auxiliary.ShamirLagrange(holders)
+	M := make([][8][8]int, len(holders))
+	for h := range holders {
+		M[h] = auxiliary.GF256_BitMatrix(lambda[h])
+	}
+	// bit r of byte j of the key is the XOR of bit c of byte j of the shares over M[r][c] == 1
+	for i := 0; i < len(key); i++ {
+		j, r := i/8, i%8
+		key[i] = NewTFHECiphertext(0, eval.Parameters)
+		for h, k := range holders {
+			for c := 0; c < 8; c++ {
+				if M[h][r][c] == 1 {
+					key[i] = eval.XOR(key[i], sk.Keys[k][8*j+c])
+				}
+			}
+		}
+	}
+	return key
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Set up a dataset in threshold mode with Alice's segments, return the plaintext genotypes by rsID
+func newThresholdRoot(t *testing.T, sharing auxiliary.KeySharing) (auxiliary.People, map[int]int) {
+	plaintext, _ := filepath.Abs("testdata/legacy/Alice_Plaintext.csv")
+	people := newTestRoot(t, "Alice")[0]
+	os.Remove(auxiliary.KeySharingPath())
+	os.Remove(auxiliary.KeyModesPath())
+	auxiliary.SaveKeySharing(sharing)
+	auxiliary.SaveKeyModes(auxiliary.DefaultKeyModes(sharing.Holders, false))
+	for k := 1; k <= sharing.Holders; k++ {
+		t.Setenv(Passphrase_EnvVar+strconv.Itoa(k), "test passphrase "+strconv.Itoa(k))
+	}
+
+	RSIDs, GTs := auxiliary.ReadGenotypeCSV(plaintext)
+	want := make(map[int]int)
+	for i := range RSIDs {
+		want[RSIDs[i]] = GTs[i]
+	}
+	os.MkdirAll(filepath.Dir(DataHashPath()), os.ModePerm)
+	EncryptAndSaveSegments(people, DivideVariantsIntoSegments(people, RSIDs, GTs))
+	return people, want
+}
+
+// Count the variants of a segment that decrypt with a key to the plaintext
+func countDecrypted(people auxiliary.People, keys *RawKeys, key []int, segID int, want map[int]int) int {
+	data, _ := ReadSegmentData(people, segID, 1)
+	found := 0
+	for _, v := range Seg_Enc(data, keys.Cipher, key, SegmentIV(keys.Cipher, segID, ReadSegmentVersion(people, segID))) {
+		if gt, ok := want[Decode_rsID(v.Rsid)]; ok && gt == Decode_Genotype(v.Genotype) {
+			found++
+		}
+	}
+	return found
+}
+
+// The key shares of two key holders for a segment recover its key and not the key of another segment
+func TestThresholdSegmentKey(t *testing.T) {
+	people, want := newThresholdRoot(t, auxiliary.KeySharing{Holders: 3, Threshold: 2})
+	t.Setenv(Passphrase_EnvVar+"2", "")
+	keys := LoadRawKeys(people)
+	if len(keys.Keyinfo) != 2 {
+		t.Fatalf("%d key holders are loaded, want 2", len(keys.Keyinfo))
+	}
+
+	params := testParams()
+	enc := tfhe.NewBinaryEncryptor(params)
+	eval := tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+	segA, segB := -1, -1
+	for segID := 0; segID < auxiliary.ReadSegParams().Seg_num; segID++ {
+		if countDecrypted(people, keys, keys.SegmentKey(segID, keys.HostedKey()), segID, want) > 0 {
+			if segA < 0 {
+				segA = segID
+			} else {
+				segB = segID
+				break
+			}
+		}
+	}
+	if segB < 0 {
+		t.Fatal("Alice has less than two segments with variants")
+	}
+
+	segkeys := NewSegKeys(keys.Sharing)
+	for _, k := range []int{1, 3} {
+		for _, bit := range keys.KeyShare(k, segA, 1) {
+			segkeys.Keys[k] = append(segkeys.Keys[k], enc.EncryptLWEBool(bit == 1))
+		}
+	}
+	combined := segkeys.Combine(eval)
+	keyA := make([]int, len(combined))
+	for i := range combined {
+		if enc.DecryptLWEBool(combined[i]) {
+			keyA[i] = 1
+		}
+	}
+
+	if got, all := countDecrypted(people, keys, keyA, segA, want), countDecrypted(people, keys, keys.SegmentKey(segA, keys.HostedKey()), segA, want); got != all {
+		t.Fatalf("the SegKey of segment %d decrypts %d of its %d variants", segA, got, all)
+	}
+	if got := countDecrypted(people, keys, keyA, segB, want); got != 0 {
+		t.Fatalf("the SegKey of segment %d decrypts %d variants of segment %d", segA, got, segB)
+	}
+}
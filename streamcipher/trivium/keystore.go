@@ -0,0 +1,250 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Keystore of the key holders under ${root}/Keystore
+// Keyholder_k.salt: scrypt salt of key holder k, then a sealed check value to reject a wrong passphrase early
+// ${folder}/${Name}/Keyholder_k.key: the public keyhash, then the keyinfo sealed with the key derived from the passphrase
+// the passphrase of key holder k is read from the environment variable GOVERNOME_PASSPHRASE_k
+const (
+	Keystore_Magic      = "GVKY"
+	Passphrase_EnvVar   = "GOVERNOME_PASSPHRASE_"
+	keystoreCheckValue  = "Governome keystore"
+	keystoreFileMode    = 0600
+	keystoreDirFileMode = 0700
+)
+
+var (
+	sealKeyLock  sync.Mutex
+	sealKeyCache = make(map[string][]byte)
+)
+
+// Get the keystore folder
+func KeystorePath() string {
+	return auxiliary.ReadPath() + "/Keystore"
+}
+
+// Get the path of the sealed keyinfo of a key holder of an individual
+func KeyFilePath(people auxiliary.People, keyholderID int) string {
+	file_path := KeystorePath() + "/" + auxiliary.MappingPeopletoFolder(people) + "/" + people.Name + "/Keyholder_" + strconv.Itoa(keyholderID) + ".key"
+	path, _ := filepath.Abs(file_path)
+	return path
+}
+
+// Associated data of a sealed keyinfo, binds it to the individual, the key holder and the keyhash
+func keyFileAD(people auxiliary.People, keyholderID int, keyhash []byte) []byte {
+	return append([]byte(people.Name+"/Keyholder_"+strconv.Itoa(keyholderID)+"/"), keyhash...)
+}
+
+// Whether the passphrase of a key holder is given
+func hasPassphrase(keyholderID int) bool {
+	return os.Getenv(Passphrase_EnvVar+strconv.Itoa(keyholderID)) != ""
+}
+
+// Get the sealing key of a key holder, derived from the passphrase once per process
+// the salt file is created by the first use, later uses must give the same passphrase
+func sealKey(keyholderID int) []byte {
+	passphrase := os.Getenv(Passphrase_EnvVar + strconv.Itoa(keyholderID))
+	if passphrase == "" {
+		log.Fatalf("Please set the passphrase of key holder %d in %s%d", keyholderID, Passphrase_EnvVar, keyholderID)
+	}
+	salt_path := KeystorePath() + "/Keyholder_" + strconv.Itoa(keyholderID) + ".salt"
+
+	sealKeyLock.Lock()
+	defer sealKeyLock.Unlock()
+	if key, ok := sealKeyCache[salt_path+"\x00"+passphrase]; ok {
+		return key
+	}
+
+	check := []byte(keystoreCheckValue + strconv.Itoa(keyholderID))
+	var key []byte
+	file, err := os.ReadFile(salt_path)
+	if err == nil {
+		if len(file) < auxiliary.Seal_SaltSize {
+			log.Fatalf("can not read, %s is damaged", salt_path)
+		}
+		key = auxiliary.DeriveSealKey(passphrase, file[:auxiliary.Seal_SaltSize])
+		if _, err := auxiliary.Unseal(key, file[auxiliary.Seal_SaltSize:], check); err != nil {
+			log.Fatalf("Wrong passphrase of key holder %d", keyholderID)
+		}
+	} else if os.IsNotExist(err) {
+		salt := auxiliary.RandomBytes(auxiliary.Seal_SaltSize)
+		key = auxiliary.DeriveSealKey(passphrase, salt)
+		os.MkdirAll(KeystorePath(), keystoreDirFileMode)
+		if err := os.WriteFile(salt_path, append(salt, auxiliary.Seal(key, nil, check)...), keystoreFileMode); err != nil {
+			log.Fatalf("can not write, err is %+v", err)
+		}
+	} else {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+
+	sealKeyCache[salt_path+"\x00"+passphrase] = key
+	return key
+}
+
+// Generate a keyinfo from the CSPRNG, uniform below the scalar field of the curve so it is a single MiMC block
+func randomKeyinfo() []byte {
+	val, err := rand.Int(rand.Reader, auxiliary.Curve.ScalarField())
+	if err != nil {
+		log.Fatalf("can not read the CSPRNG, err is %+v", err)
+	}
+	return auxiliary.PadBytes(val.Bytes(), auxiliary.Mimchashcurve.Size())
+}
+
+// Generate a new Key Information of a key holder of an individual, seal it into the keystore, return it along with its public hash value
+// keyholderID: 1, 2, ...
+func NewRawKey(people auxiliary.People, keyholderID int) ([]byte, []byte) {
+	keyinfo := randomKeyinfo()
+	return keyinfo, newRawKeyWith(people, keyholderID, keyinfo)
+}
+
+// Seal a given keyinfo as the new key of a key holder, e.g. the values of a key holder in threshold mode, return its public hash value
+func newRawKeyWith(people auxiliary.People, keyholderID int, keyinfo []byte) []byte {
+	path := KeyFilePath(people, keyholderID)
+	if _, err := os.Stat(path); err == nil {
+		log.Fatalf("Key holder %d of %s already has a key", keyholderID, people.Name)
+	}
+	return writeKeyFile(path, people, keyholderID, keyinfo)
+}
+
+// Stage a new keyinfo of a key holder to replace the current one, it is kept beside the current key until CommitRawKey
+func StageRawKey(people auxiliary.People, keyholderID int, keyinfo []byte) []byte {
+	return writeKeyFile(KeyFilePath(people, keyholderID)+".new", people, keyholderID, keyinfo)
+}
+
+// Replace the current key of a key holder by the staged one, nothing is done if it has already been committed
+func CommitRawKey(people auxiliary.People, keyholderID int) {
+	path := KeyFilePath(people, keyholderID)
+	if err := os.Rename(path+".new", path); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+}
+
+// Remove a staged key of a key holder that has not been committed
+func DiscardRawKey(people auxiliary.People, keyholderID int) {
+	os.Remove(KeyFilePath(people, keyholderID) + ".new")
+}
+
+// Write a keyinfo sealed to path, return its public hash value
+func writeKeyFile(path string, people auxiliary.People, keyholderID int, keyinfo []byte) []byte {
+	keyhash, _ := auxiliary.MimcHashRaw(keyinfo, auxiliary.Mimchashcurve)
+	sealed := auxiliary.Seal(sealKey(keyholderID), keyinfo, keyFileAD(people, keyholderID, keyhash))
+
+	var buf bytes.Buffer
+	buf.WriteString(Keystore_Magic)
+	writeBytes16(&buf, keyhash)
+	buf.Write(sealed)
+
+	os.MkdirAll(filepath.Dir(path), keystoreDirFileMode)
+	if err := writeFileSync(path, buf.Bytes(), keystoreFileMode); err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	return keyhash
+}
+
+// Write a file through a temporary file that is synced and renamed, so a crash leaves either the old or the new file
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Read a key file, return the keyhash and the sealed keyinfo
+func readKeyFile(people auxiliary.People, keyholderID int) (keyhash, sealed []byte, err error) {
+	file, err := os.ReadFile(KeyFilePath(people, keyholderID))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(file) < len(Keystore_Magic) || string(file[:len(Keystore_Magic)]) != Keystore_Magic {
+		return nil, nil, errors.New("the key file of " + people.Name + " is damaged")
+	}
+	r := bytes.NewReader(file[len(Keystore_Magic):])
+	keyhash, err = readBytes16(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keyhash, file[len(file)-r.Len():], nil
+}
+
+// Load the Key Information of a key holder of an individual from the keystore, along with its public hash value
+func LoadRawKey(people auxiliary.People, keyholderID int) ([]byte, []byte) {
+	keyhash, sealed, err := readKeyFile(people, keyholderID)
+	if err != nil {
+		log.Fatalf("can not read the key of key holder %d of %s, err is %+v", keyholderID, people.Name, err)
+	}
+	keyinfo, err := auxiliary.Unseal(sealKey(keyholderID), sealed, keyFileAD(people, keyholderID, keyhash))
+	if err != nil {
+		log.Fatalf("can not unseal the key of key holder %d of %s, err is %+v", keyholderID, people.Name, err)
+	}
+	return keyinfo, keyhash
+}
+
+// Read the public hash value of the key of a key holder, no passphrase is needed
+func ReadRawKeyhash(people auxiliary.People, keyholderID int) []byte {
+	keyhash, _, err := readKeyFile(people, keyholderID)
+	if err != nil {
+		log.Fatalf("can not read the key of key holder %d of %s, err is %+v", keyholderID, people.Name, err)
+	}
+	return keyhash
+}
+
+// Remove the keys of all key holders of an individual, the ciphertexts left anywhere can not be decrypted any more
+func RemoveRawKeys(people auxiliary.People) {
+	os.RemoveAll(filepath.Dir(KeyFilePath(people, 1)))
+}
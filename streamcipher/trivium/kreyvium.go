@@ -0,0 +1,208 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Kreyvium, Trivium with a 128-bit key and IV (Canteaut et al., FSE 2016), the key and the IV are also kept in the
+// registers K and IV, which rotate once per round, K[0] is added to t3 and IV[0] to t1
+type Kreyvium struct {
+	L  [288]int
+	K  [128]int
+	IV [128]int
+}
+
+// Kreyvium in TFHE ciphertext, the IV is public, so its register is plaintext
+type Kreyvium_TFHE struct {
+	L  [288]tfhe.LWECiphertext[uint32]
+	K  [128]tfhe.LWECiphertext[uint32]
+	IV [128]int
+}
+
+// Generate a stream bit
+func (krey *Kreyvium) Genbit() int {
+	t1 := krey.L[65] ^ krey.L[92]
+	t2 := krey.L[161] ^ krey.L[176]
+	t3 := krey.L[242] ^ krey.L[287] ^ krey.K[0]
+	z := t1 ^ t2 ^ t3
+
+	t1 = t1 ^ (krey.L[90] & krey.L[91]) ^ krey.L[170] ^ krey.IV[0]
+	t2 = t2 ^ (krey.L[174] & krey.L[175]) ^ krey.L[263]
+	t3 = t3 ^ (krey.L[285] & krey.L[286]) ^ krey.L[68]
+
+	for i := 287; i > 0; i-- {
+		krey.L[i] = krey.L[i-1]
+	}
+
+	krey.L[0] = t3
+	krey.L[93] = t1
+	krey.L[177] = t2
+
+	k, iv := krey.K[0], krey.IV[0]
+	for i := 0; i < 127; i++ {
+		krey.K[i] = krey.K[i+1]
+		krey.IV[i] = krey.IV[i+1]
+	}
+	krey.K[127] = k
+	krey.IV[127] = iv
+
+	return z
+}
+
+// Generate a stream bit in TFHE ciphertext, adding a public IV bit is a free NOT
+func (krey *Kreyvium_TFHE) Genbit(eval *tfhe.BinaryEvaluator) tfhe.LWECiphertext[uint32] {
+
+	t1 := eval.XOR(krey.L[65], krey.L[92])
+	t2 := eval.XOR(krey.L[161], krey.L[176])
+	t3 := eval.XOR(krey.L[242], krey.L[287])
+	t3 = eval.XOR(t3, krey.K[0])
+	z := eval.XOR(t1, t2)
+	z = eval.XOR(z, t3)
+
+	temp1 := eval.AND(krey.L[90], krey.L[91])
+	t1 = eval.XOR(t1, temp1)
+	t1 = eval.XOR(t1, krey.L[170])
+	if krey.IV[0] == 1 {
+		t1 = eval.NOT(t1)
+	}
+
+	temp2 := eval.AND(krey.L[174], krey.L[175])
+	t2 = eval.XOR(t2, temp2)
+	t2 = eval.XOR(t2, krey.L[263])
+
+	temp3 := eval.AND(krey.L[285], krey.L[286])
+	t3 = eval.XOR(t3, temp3)
+	t3 = eval.XOR(t3, krey.L[68])
+
+	for i := 287; i > 0; i-- {
+		krey.L[i] = krey.L[i-1]
+	}
+
+	krey.L[0] = t3
+	krey.L[93] = t1
+	krey.L[177] = t2
+
+	k, iv := krey.K[0], krey.IV[0]
+	for i := 0; i < 127; i++ {
+		krey.K[i] = krey.K[i+1]
+		krey.IV[i] = krey.IV[i+1]
+	}
+	krey.K[127] = k
+	krey.IV[127] = iv
+
+	return z
+}
+
+// Init the key for Kreyvium, the first 93 key bits fill the first register, the IV the other two, then ones and a zero
+func (krey *Kreyvium) Init(key []int, iv []int) {
+	if len(key) != 128 || len(iv) != 128 {
+		fmt.Println("Invalid Input!")
+		return
+	}
+	for i := 0; i < 288; i++ {
+		krey.L[i] = 1
+	}
+	for i := 0; i < 93; i++ {
+		krey.L[i] = key[i]
+	}
+	for i := 0; i < 128; i++ {
+		krey.L[i+93] = iv[i]
+		krey.K[127-i] = key[i]
+		krey.IV[127-i] = iv[i]
+	}
+	krey.L[287] = 0
+
+	for i := 0; i < 1152; i++ {
+		krey.Genbit()
+	}
+}
+
+// Init in ciphertext, the key is recovered from the key shares by SegKeys.Combine
+func (krey *Kreyvium_TFHE) Init(key []tfhe.LWECiphertext[uint32], eval *tfhe.BinaryEvaluator, iv []int) {
+	if len(key) != 128 || len(iv) != 128 {
+		fmt.Println("Invalid Input!")
+		return
+	}
+	for i := 0; i < 288; i++ {
+		krey.L[i] = NewTFHECiphertext(1, eval.Parameters)
+	}
+	for i := 0; i < 93; i++ {
+		krey.L[i] = key[i].Copy()
+	}
+	for i := 0; i < 128; i++ {
+		krey.L[i+93] = NewTFHECiphertext(iv[i], eval.Parameters)
+		krey.K[127-i] = key[i].Copy()
+		krey.IV[127-i] = iv[i]
+	}
+	krey.L[287] = NewTFHECiphertext(0, eval.Parameters)
+
+	for i := 0; i < 1152; i++ {
+		krey.Genbit(eval)
+	}
+}
+
+// Write the state, the 288 + 128 ciphertexts of L and K and the IV register as bytes
+func (krey *Kreyvium_TFHE) WriteState(w io.Writer) error {
+	for _, ct := range append(krey.L[:], krey.K[:]...) {
+		if _, err := ct.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	iv := make([]byte, 128)
+	for i := 0; i < 128; i++ {
+		iv[i] = byte(krey.IV[i])
+	}
+	_, err := w.Write(iv)
+	return err
+}
+
+// Read a state written by WriteState
+func (krey *Kreyvium_TFHE) ReadState(r io.Reader) error {
+	for i := 0; i < 288; i++ {
+		if _, err := krey.L[i].ReadFrom(r); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < 128; i++ {
+		if _, err := krey.K[i].ReadFrom(r); err != nil {
+			return err
+		}
+	}
+	iv := make([]byte, 128)
+	if _, err := io.ReadFull(r, iv); err != nil {
+		return err
+	}
+	for i := 0; i < 128; i++ {
+		krey.IV[i] = int(iv[i])
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Known answers of Kreyvium, 64 stream bits of a key and an IV in hex, bit i of byte j the bit 8 * j + i, bit i of
+// the word the i-th stream bit. The all-zero vector is the output of the reference implementation of the designers
+// (Canteaut et al., "Stream ciphers: A Practical Solution for Efficient Homomorphic-Ciphertext Compression", FSE
+// 2016), keystream 26DCF1F4BC0F1922..., as checked by the Kreyvium tests of Zama's tfhe-rs (apps/trivium)
+var kreyvium_vectors = []struct {
+	key, iv string
+	stream  uint64
+}{
+	{"00000000000000000000000000000000", "00000000000000000000000000000000", 0x22190fbcf4f1dc26},
+}
+
+func hexKeyBits(t *testing.T, s string, n int) []int {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b)*8 != n {
+		t.Fatalf("invalid vector %q", s)
+	}
+	bits := make([]int, n)
+	for i := range bits {
+		bits[i] = int(b[i/8]>>(i%8)) & 1
+	}
+	return bits
+}
+
+func TestKreyviumVectors(t *testing.T) {
+	params := testParams()
+	enc := tfhe.NewBinaryEncryptor(params)
+	eval := tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+
+	for l, v := range kreyvium_vectors {
+		key, iv := hexKeyBits(t, v.key, 128), hexKeyBits(t, v.iv, 128)
+
+		var krey Kreyvium
+		krey.Init(key, iv)
+		for i := 0; i < 64; i++ {
+			if z := krey.Genbit(); z != int(v.stream>>i)&1 {
+				t.Fatalf("Kreyvium differs from the known answer %d at bit %d", l, i)
+			}
+		}
+
+		key_ct := make([]tfhe.LWECiphertext[uint32], len(key))
+		for i := range key {
+			key_ct[i] = enc.EncryptLWEBool(key[i] == 1)
+		}
+		var krey_tfhe Kreyvium_TFHE
+		krey_tfhe.Init(key_ct, eval, iv)
+		for i := 0; i < 64; i++ {
+			if z := enc.DecryptLWEBool(krey_tfhe.Genbit(eval)); z != (int(v.stream>>i)&1 == 1) {
+				t.Fatalf("Kreyvium_TFHE differs from the known answer %d at bit %d", l, i)
+			}
+		}
+	}
+}
+
+// The zero vector leaves the key and IV registers out, so Kreyvium_TFHE is also checked against Kreyvium on a random key
+// and IV
+func TestKreyviumTFHERandom(t *testing.T) {
+	params := testParams()
+	enc := tfhe.NewBinaryEncryptor(params)
+	eval := tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+
+	key, iv := make([]int, 128), make([]int, 128)
+	key_ct := make([]tfhe.LWECiphertext[uint32], len(key))
+	for i := range key {
+		key[i], iv[i] = rand.Intn(2), rand.Intn(2)
+		key_ct[i] = enc.EncryptLWEBool(key[i] == 1)
+	}
+	var krey Kreyvium
+	krey.Init(key, iv)
+	var krey_tfhe Kreyvium_TFHE
+	krey_tfhe.Init(key_ct, eval, iv)
+	for i := 0; i < 64; i++ {
+		if z := enc.DecryptLWEBool(krey_tfhe.Genbit(eval)); z != (krey.Genbit() == 1) {
+			t.Fatalf("Kreyvium_TFHE differs from Kreyvium at bit %d", i)
+		}
+	}
+}
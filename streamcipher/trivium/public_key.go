@@ -0,0 +1,264 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Binary public key file, all integers are little endian
+// header: magic, version, fingerprint of the TFHE parameters (with a uint16 length), uint32 LWE dimension n
+// body: the n x n matrix A row by row, then the n entries of B, each a uint32
+// checksum: sha256 of everything before it
+const (
+	PublicKey_Magic   = "GVPK"
+	PublicKey_Version = 1
+)
+
+// Process-wide cache of the loaded public keys, keyed by file path and parameter fingerprint
+var (
+	pkCache     = make(map[string]auxiliary.PublicKey_tfheb)
+	pkCacheLock sync.Mutex
+)
+
+// Get the path of the public key, ext is ".bin" for the binary format, ".csv" for the legacy format
+func PublicKeyPath(ext string) string {
+	return auxiliary.ReadPath() + "/Key_Information/Trivium_PublicKey" + ext
+}
+
+// Fingerprint of TFHE parameters, the sha256 of their binary encoding
+func ParamsFingerprint(params tfhe.Parameters[uint32]) []byte {
+	data, err := params.MarshalBinary()
+	if err != nil {
+		log.Fatalf("can not marshal parameters, err is %+v", err)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// Encode a public key in the binary format
+func MarshalPK(pk auxiliary.PublicKey_tfheb) []byte {
+	n := len(pk.B)
+	var buf bytes.Buffer
+	buf.Grow(len(PublicKey_Magic) + 40 + 4*n*(n+1) + sha256.Size)
+
+	buf.WriteString(PublicKey_Magic)
+	binary.Write(&buf, binary.LittleEndian, uint16(PublicKey_Version))
+	writeBytes16(&buf, ParamsFingerprint(pk.Params))
+	binary.Write(&buf, binary.LittleEndian, uint32(n))
+
+	data := buf.Bytes()
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			data = binary.LittleEndian.AppendUint32(data, pk.A[i][j])
+		}
+	}
+	for i := 0; i < n; i++ {
+		data = binary.LittleEndian.AppendUint32(data, pk.B[i])
+	}
+	sum := sha256.Sum256(data)
+	return append(data, sum[:]...)
+}
+
+// Decode a public key in the binary format, the key must be generated under params
+func UnmarshalPK(data []byte, params tfhe.Parameters[uint32]) (pk auxiliary.PublicKey_tfheb, err error) {
+	if len(data) < len(PublicKey_Magic)+sha256.Size || string(data[:len(PublicKey_Magic)]) != PublicKey_Magic {
+		return pk, errors.New("not a public key file")
+	}
+	body := data[:len(data)-sha256.Size]
+	sum := sha256.Sum256(body)
+	if !bytes.Equal(sum[:], data[len(body):]) {
+		return pk, errors.New("checksum mismatch, the public key file is corrupted")
+	}
+
+	r := bytes.NewReader(body[len(PublicKey_Magic):])
+	var version uint16
+	var n uint32
+	if err = binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return pk, err
+	}
+	if version != PublicKey_Version {
+		return pk, fmt.Errorf("unsupported public key version %d", version)
+	}
+	fingerprint, err := readBytes16(r)
+	if err != nil {
+		return pk, err
+	}
+	if !bytes.Equal(fingerprint, ParamsFingerprint(params)) {
+		return pk, errors.New("the public key is generated under other TFHE parameters than the given ones")
+	}
+	if err = binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return pk, err
+	}
+	if int(n) != params.LWEDimension() {
+		return pk, fmt.Errorf("public key dimension %d, expected %d", n, params.LWEDimension())
+	}
+	if r.Len() != 4*int(n)*(int(n)+1) {
+		return pk, errors.New("public key body has a wrong length")
+	}
+
+	values := body[len(body)-r.Len():]
+	pk.Params = params
+	pk.A = make([][]uint32, n)
+	for i := 0; i < int(n); i++ {
+		pk.A[i] = make([]uint32, n)
+		for j := 0; j < int(n); j++ {
+			pk.A[i][j] = binary.LittleEndian.Uint32(values[4*(i*int(n)+j):])
+		}
+	}
+	pk.B = make([]uint32, n)
+	for i := 0; i < int(n); i++ {
+		pk.B[i] = binary.LittleEndian.Uint32(values[4*(int(n)*int(n)+i):])
+	}
+	return pk, nil
+}
+
+// Cache key of a public key file under params
+func pkCacheKey(path string, params tfhe.Parameters[uint32]) string {
+	return path + "#" + hex.EncodeToString(ParamsFingerprint(params))
+}
+
+// Save the public key in the binary format and refresh the cache
+func Save_PK(pk auxiliary.PublicKey_tfheb) {
+	os.MkdirAll(auxiliary.ReadPath()+"/Key_Information/", os.ModePerm)
+	path := PublicKeyPath(".bin")
+	if err := os.WriteFile(path+".tmp", MarshalPK(pk), 0644); err != nil {
+		log.Fatalf("can not write %s, err is %+v", path, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Fatalf("can not write %s, err is %+v", path, err)
+	}
+
+	pkCacheLock.Lock()
+	pkCache[pkCacheKey(path, pk.Params)] = pk
+	pkCacheLock.Unlock()
+}
+
+// Read the public key generated under params, a loaded key is cached for the whole process
+// falls back to the legacy csv key if there is no binary key, any mismatch against params is fatal
+func ReadPK(params tfhe.Parameters[uint32]) auxiliary.PublicKey_tfheb {
+	path := PublicKeyPath(".bin")
+	key := pkCacheKey(path, params)
+
+	pkCacheLock.Lock()
+	defer pkCacheLock.Unlock()
+	if pk, ok := pkCache[key]; ok {
+		return pk
+	}
+
+	var pk auxiliary.PublicKey_tfheb
+	data, err := os.ReadFile(path)
+	if err == nil {
+		pk, err = UnmarshalPK(data, params)
+		if err != nil {
+			log.Fatalf("can not read %s, err is %+v", path, err)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		pk, err = readPKCSV(PublicKeyPath(".csv"), params)
+		if err != nil {
+			log.Fatalf("can not read %s, err is %+v", PublicKeyPath(".csv"), err)
+		}
+	} else {
+		log.Fatalf("can not read %s, err is %+v", path, err)
+	}
+
+	pkCache[key] = pk
+	return pk
+}
+
+// Read the legacy csv public key, n rows of A then one row of B, each with n decimal values
+// the csv carries no parameters, so only the dimension can be checked against params
+func readPKCSV(path string, params tfhe.Parameters[uint32]) (pk auxiliary.PublicKey_tfheb, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return pk, err
+	}
+	defer file.Close()
+
+	n := params.LWEDimension()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = n
+	r.ReuseRecord = true
+
+	pk.Params = params
+	pk.A = make([][]uint32, n)
+	count := 0
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return pk, err
+		}
+		if count > n {
+			return pk, fmt.Errorf("public key has more than %d rows", n+1)
+		}
+
+		vals := make([]uint32, n)
+		for i := 0; i < n; i++ {
+			val, err := strconv.ParseUint(row[i], 10, 32)
+			if err != nil {
+				return pk, err
+			}
+			vals[i] = uint32(val)
+		}
+		if count == n {
+			pk.B = vals
+		} else {
+			pk.A[count] = vals
+		}
+		count++
+	}
+	if count != n+1 {
+		return pk, fmt.Errorf("public key has %d rows, expected %d", count, n+1)
+	}
+	return pk, nil
+}
+
+// Convert the legacy csv public key to the binary format
+func ConvertPKCSV(params tfhe.Parameters[uint32]) {
+	pk, err := readPKCSV(PublicKeyPath(".csv"), params)
+	if err != nil {
+		log.Fatalf("can not read %s, err is %+v", PublicKeyPath(".csv"), err)
+	}
+	Save_PK(pk)
+}
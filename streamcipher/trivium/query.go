@@ -34,9 +34,14 @@ import (
 	"github.com/sp301415/tfhe-go/tfhe"
 )
 
+// Decryptor of TFHE ciphertexts of bits, a tfhe.BinaryEncryptor with the secret key, or a combiner of threshold decryption shares
+type LWEBoolDecryptor interface {
+	DecryptLWEBool(ct tfhe.LWECiphertext[uint32]) bool
+}
+
 type Variant_TFHE struct {
-	Rsid     [32]tfhe.LWECiphertext[uint32]
-	Genotype [4]tfhe.LWECiphertext[uint32]
+	Rsid     [auxiliary.Key_Bits]tfhe.LWECiphertext[uint32]
+	Genotype [auxiliary.Genotype_Bits]tfhe.LWECiphertext[uint32]
 }
 
 // Binary
@@ -66,6 +71,23 @@ type Int72Ciphertext struct {
 	Values [72]tfhe.LWECiphertext[uint32]
 }
 
+// Ciphertexts of the bits of a value, to be decrypted together by the key share holders
+func (v BigValueCiphertext) Ciphertexts() []tfhe.LWECiphertext[uint32] {
+	return v.Values
+}
+
+func (v Fix16) Ciphertexts() []tfhe.LWECiphertext[uint32] {
+	return v.Values[:]
+}
+
+func (v Int64Ciphertext) Ciphertexts() []tfhe.LWECiphertext[uint32] {
+	return v.Values[:]
+}
+
+func (v Int72Ciphertext) Ciphertexts() []tfhe.LWECiphertext[uint32] {
+	return v.Values[:]
+}
+
 // transfer BigValue to int
 func BigValue2Int(v BigValue) int {
 	res := 0
@@ -113,10 +135,10 @@ func Int2BigValueWithUpperBound(u int, upperbound int) BigValue {
 
 // Encrypt a Variant to TFHE with public key
 func Enc_Variant(v Variant, pk auxiliary.PublicKey_tfheb) (res Variant_TFHE) {
-	for i := 0; i < 32; i++ {
+	for i := 0; i < auxiliary.Key_Bits; i++ {
 		res.Rsid[i] = auxiliary.EncWithPublicKey_tfheb(uint32(v.Rsid[i]), pk)
 	}
-	for i := 0; i < 4; i++ {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		res.Genotype[i] = auxiliary.EncWithPublicKey_tfheb(uint32(v.Genotype[i]), pk)
 	}
 	return
@@ -124,24 +146,24 @@ func Enc_Variant(v Variant, pk auxiliary.PublicKey_tfheb) (res Variant_TFHE) {
 
 // Get Raw Variant Ciphertext from Plaintext Without Error
 func Enc_Variant_Raw(v Variant, params tfhe.Parameters[uint32]) (res Variant_TFHE) {
-	for i := 0; i < 32; i++ {
+	for i := 0; i < auxiliary.Key_Bits; i++ {
 		res.Rsid[i] = NewTFHECiphertext(v.Rsid[i], params)
 	}
-	for i := 0; i < 4; i++ {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		res.Genotype[i] = NewTFHECiphertext(v.Genotype[i], params)
 	}
 	return
 }
 
 // Decrypt Variant in TFHE ciphertext
-func Dec_Variant(v Variant_TFHE, enc *tfhe.BinaryEncryptor) (res Variant) {
-	for i := 0; i < 32; i++ {
+func Dec_Variant(v Variant_TFHE, enc LWEBoolDecryptor) (res Variant) {
+	for i := 0; i < auxiliary.Key_Bits; i++ {
 		res.Rsid[i] = 0
 		if enc.DecryptLWEBool(v.Rsid[i]) {
 			res.Rsid[i] = 1
 		}
 	}
-	for i := 0; i < 4; i++ {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		res.Genotype[i] = 0
 		if enc.DecryptLWEBool(v.Genotype[i]) {
 			res.Genotype[i] = 1
@@ -152,10 +174,10 @@ func Dec_Variant(v Variant_TFHE, enc *tfhe.BinaryEncryptor) (res Variant) {
 
 // Xor operation between 2 variants in ciphertext
 func (v1 *Variant_TFHE) Xor_Variant(v2 Variant_TFHE, eval *tfhe.BinaryEvaluator) (res Variant_TFHE) {
-	for i := 0; i < 32; i++ {
+	for i := 0; i < auxiliary.Key_Bits; i++ {
 		res.Rsid[i] = eval.XOR(v1.Rsid[i], v2.Rsid[i])
 	}
-	for i := 0; i < 4; i++ {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		res.Genotype[i] = eval.XOR(v1.Genotype[i], v2.Genotype[i])
 	}
 	return
@@ -163,10 +185,10 @@ func (v1 *Variant_TFHE) Xor_Variant(v2 Variant_TFHE, eval *tfhe.BinaryEvaluator)
 
 // XNOR operation between 2 variants in ciphertext
 func (v1 *Variant_TFHE) XNOR_Variant(v2 Variant_TFHE, eval *tfhe.BinaryEvaluator) (res Variant_TFHE) {
-	for i := 0; i < 32; i++ {
+	for i := 0; i < auxiliary.Key_Bits; i++ {
 		res.Rsid[i] = eval.XNOR(v1.Rsid[i], v2.Rsid[i])
 	}
-	for i := 0; i < 4; i++ {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		res.Genotype[i] = eval.XNOR(v1.Genotype[i], v2.Genotype[i])
 	}
 	return
@@ -175,7 +197,7 @@ func (v1 *Variant_TFHE) XNOR_Variant(v2 Variant_TFHE, eval *tfhe.BinaryEvaluator
 // Judge whether 2 rsid are equal, return 0/1
 func (v1 *Variant_TFHE) Judge_RSID(v2 Variant_TFHE, eval *tfhe.BinaryEvaluator) (res tfhe.LWECiphertext[uint32]) {
 	res = eval.XNOR(v1.Rsid[0], v2.Rsid[0])
-	for i := 1; i < 32; i++ {
+	for i := 1; i < auxiliary.Key_Bits; i++ {
 		temp := eval.XNOR(v1.Rsid[i], v2.Rsid[i])
 		res = eval.AND(res, temp)
 	}
@@ -186,10 +208,10 @@ func (v1 *Variant_TFHE) Judge_RSID(v2 Variant_TFHE, eval *tfhe.BinaryEvaluator)
 func Compare_Variant_TFHE(v1, v2 Variant_TFHE, eval *tfhe.BinaryEvaluator) BigValueCiphertext {
 	v := v1.XNOR_Variant(v2, eval)
 	res := eval.AND(v.Rsid[0], v.Rsid[1])
-	for i := 2; i < 32; i++ {
+	for i := 2; i < auxiliary.Key_Bits; i++ {
 		res = eval.AND(res, v.Rsid[i])
 	}
-	for i := 0; i < 4; i++ {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		res = eval.AND(res, v.Genotype[i])
 	}
 	var v_c BigValueCiphertext
@@ -200,38 +222,38 @@ func Compare_Variant_TFHE(v1, v2 Variant_TFHE, eval *tfhe.BinaryEvaluator) BigVa
 }
 
 // Compare whether the rsid of 2 variants are equal, if so, genotype copy in v1
-func Compare_RSID_TFHE(v1, v2 Variant_TFHE, eval *tfhe.BinaryEvaluator) (res [4]tfhe.LWECiphertext[uint32]) {
+func Compare_RSID_TFHE(v1, v2 Variant_TFHE, eval *tfhe.BinaryEvaluator) (res [auxiliary.Genotype_Bits]tfhe.LWECiphertext[uint32]) {
 	c := v1.Judge_RSID(v2, eval)
-	for i := 0; i < 4; i++ {
+	for i := 0; i < auxiliary.Genotype_Bits; i++ {
 		res[i] = eval.AND(c, v1.Genotype[i])
 	}
 	return
 }
 
-// Compare two variants, if hit, get the number for different genotype of v1, 3 result: 0|0, 0|1 or 1|0, 1|1
+// Whether the allele starting at bit offset of a genotype is a non-reference allele
+func NonRefAllele_TFHE(genotype [auxiliary.Genotype_Bits]tfhe.LWECiphertext[uint32], offset int, eval *tfhe.BinaryEvaluator) (res tfhe.LWECiphertext[uint32]) {
+	res = genotype[offset]
+	for i := 1; i < auxiliary.Allele_Bits; i++ {
+		res = eval.OR(res, genotype[offset+i])
+	}
+	return
+}
+
+// Compare two variants, if hit, get the number for different genotype of v1, 3 result: hit, one non-reference allele, two non-reference alleles
+// A missing genotype is only counted as hit, a haploid non-reference call has one non-reference allele
 func GenotypeFromTwoVariants(v1, v2 Variant_TFHE, eval *tfhe.BinaryEvaluator) (res [3]tfhe.LWECiphertext[uint32]) {
 	c := v1.Judge_RSID(v2, eval)
-	v := make([]Variant, 3)
-	v[0].Genotype = Encode_Genotype(auxiliary.Genotype_s2i("0|1"))
-	v[1].Genotype = Encode_Genotype(auxiliary.Genotype_s2i("1|0"))
-	v[2].Genotype = Encode_Genotype(auxiliary.Genotype_s2i("1|1"))
-	c_list := make([]tfhe.LWECiphertext[uint32], 3)
-	for i := 0; i < 3; i++ {
-		c_list[i] = NewTFHECiphertext(1, eval.Parameters)
-	}
-
-	for i := 0; i < 3; i++ {
-		v_ct := Enc_Variant_Raw(v[i], eval.Parameters)
-		for j := 0; j < 4; j++ {
-			temp := eval.XNOR(v_ct.Genotype[j], v1.Genotype[j])
-			c_list[i] = eval.AND(c_list[i], temp)
-		}
-	}
+
+	missing := v1.Genotype[2*auxiliary.Allele_Bits+1]
+	valid := eval.AND(c, eval.NOT(missing))
+	nonref1 := NonRefAllele_TFHE(v1.Genotype, auxiliary.Allele_Bits, eval)
+	nonref2 := NonRefAllele_TFHE(v1.Genotype, 0, eval)
 
 	res[0] = c
-	res[1] = eval.OR(c_list[0], c_list[1])
-	res[1] = eval.AND(res[1], c)
-	res[2] = eval.AND(c_list[2], c)
+	res[1] = eval.XOR(nonref1, nonref2)
+	res[1] = eval.AND(res[1], valid)
+	res[2] = eval.AND(nonref1, nonref2)
+	res[2] = eval.AND(res[2], valid)
 
 	return
 }
@@ -256,7 +278,7 @@ func Enc_BigValue(v BigValue, pk auxiliary.PublicKey_tfheb) (v_c BigValueCiphert
 }
 
 // Decryption BigValueCiphertext to BigValue
-func Dec_BigValue(v_c BigValueCiphertext, enc *tfhe.BinaryEncryptor) (v BigValue) {
+func Dec_BigValue(v_c BigValueCiphertext, enc LWEBoolDecryptor) (v BigValue) {
 	v.Values = make([]int, len(v_c.Values))
 	for i := 0; i < len(v_c.Values); i++ {
 		v.Values[i] = 0
@@ -556,7 +578,7 @@ func NegInt72Ciphertext(v Int72Ciphertext, eval *tfhe.BinaryEvaluator) (res Int7
 }
 
 // Decryption Int64Ciphertext to int
-func Dec_Int64Ciphertext(v_c Int64Ciphertext, enc *tfhe.BinaryEncryptor) (v int) {
+func Dec_Int64Ciphertext(v_c Int64Ciphertext, enc LWEBoolDecryptor) (v int) {
 	v = 0
 	for i := 0; i < 64; i++ {
 		temp := 0
@@ -569,7 +591,7 @@ func Dec_Int64Ciphertext(v_c Int64Ciphertext, enc *tfhe.BinaryEncryptor) (v int)
 }
 
 // Decryption Int72Ciphertext to int
-func Dec_Int72Ciphertext(v_c Int72Ciphertext, enc *tfhe.BinaryEncryptor) (v *big.Int) {
+func Dec_Int72Ciphertext(v_c Int72Ciphertext, enc LWEBoolDecryptor) (v *big.Int) {
 	v = big.NewInt(0)
 	for i := 0; i < 72; i++ {
 		temp := 0
@@ -600,17 +622,17 @@ func Dec_Int72Ciphertext(v_c Int72Ciphertext, enc *tfhe.BinaryEncryptor) (v *big
 // }
 
 // Decrypt Stream ciphertext with TFHE key
-func DecCiphertextBySegKey(encrypted_data []Variant_TFHE, triv *Trivium_TFHE, eval *tfhe.BinaryEvaluator) (decrypted_data []Variant_TFHE) {
+func DecCiphertextBySegKey(encrypted_data []Variant_TFHE, triv HomomorphicStreamCipher, eval *tfhe.BinaryEvaluator) (decrypted_data []Variant_TFHE) {
 	eval = eval.ShallowCopy()
 	decrypted_data = make([]Variant_TFHE, len(encrypted_data))
 
 	Stream := make([]Variant_TFHE, len(encrypted_data))
 
 	for i := 0; i < len(encrypted_data); i++ {
-		for j := 0; j < 32; j++ {
+		for j := 0; j < auxiliary.Key_Bits; j++ {
 			Stream[i].Rsid[j] = triv.Genbit(eval)
 		}
-		for j := 0; j < 4; j++ {
+		for j := 0; j < auxiliary.Genotype_Bits; j++ {
 			Stream[i].Genotype[j] = triv.Genbit(eval)
 		}
 		decrypted_data[i] = encrypted_data[i].Xor_Variant(Stream[i], eval)
@@ -802,7 +824,7 @@ func EncFix16(a float64, pk auxiliary.PublicKey_tfheb) (res Fix16) {
 }
 
 // Decrypt a Fix16 Value to float64
-func DecFix16(a Fix16, enc *tfhe.BinaryEncryptor) (res float64) {
+func DecFix16(a Fix16, enc LWEBoolDecryptor) (res float64) {
 	res = 0
 	for i := 0; i < 16; i++ {
 		temp := 1 << i
@@ -843,7 +865,7 @@ func DivInt72Ciphertext(a, b Int72Ciphertext, eval *tfhe.BinaryEvaluator) (bit_r
 }
 
 // Decrypt the result for a division
-func DecDivResult(bit_res Fix16, exp_res Int72Ciphertext, enc *tfhe.BinaryEncryptor) float64 {
+func DecDivResult(bit_res Fix16, exp_res Int72Ciphertext, enc LWEBoolDecryptor) float64 {
 	temp := DecFix16(bit_res, enc)
 	exp := Dec_Int72Ciphertext(exp_res, enc).Int64()
 	res := math.Exp2(float64(exp))
@@ -0,0 +1,81 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// The toy parameters with larger polynomials for the tests in TFHE ciphertext. With a polynomial degree of 8, the
+// rounding of the blind rotation now and then fails a gate, about one warm-up of 17k gates in ten
+func testParams() tfhe.Parameters[uint32] {
+	params := auxiliary.ParamsToyBoolean
+	params.PolyDegree = 128
+	params.PolyLargeDegree = 128
+	return params.Compile()
+}
+
+// Set up an empty dataset with the given individuals for a test, with the default key sharing and modes
+// ReadPath reads ../../defaultPath, so the test runs in a folder two levels below the temporary folder
+func newTestRoot(t *testing.T, names ...string) []auxiliary.People {
+	tmp := t.TempDir()
+	work := filepath.Join(tmp, "a", "b")
+	if err := os.MkdirAll(work, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	root := filepath.Join(tmp, "root")
+	auxiliary.SavePath(root)
+	os.MkdirAll(root+"/Individuals", os.ModePerm)
+	var csv string
+	peoples := make([]auxiliary.People, len(names))
+	for i, name := range names {
+		peoples[i] = auxiliary.People{Name: name, ID: i + 1}
+		csv += name + "," + strconv.Itoa(i+1) + "\n"
+	}
+	os.WriteFile(root+"/Individuals/Individuals.csv", []byte(csv), 0644)
+
+	auxiliary.SaveSegParams(auxiliary.SegParams{Seg_num: 16, Minimal_Blocksize: 4})
+	auxiliary.SaveKeySharing(auxiliary.Default_KeySharing)
+	auxiliary.SaveKeyModes(auxiliary.DefaultKeyModes(auxiliary.Default_KeySharing.Holders, false))
+	for k := 1; k <= auxiliary.Default_KeySharing.Holders; k++ {
+		t.Setenv(Passphrase_EnvVar+strconv.Itoa(k), "test passphrase "+strconv.Itoa(k))
+	}
+	t.Setenv(auxiliary.Signer_Passphrase_EnvVar, "test signer passphrase")
+	return peoples
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Journal of a key rotation, ${Keystore}/${folder}/${Name}/Rotation.csv, written once everything is staged
+// rows: "Keyholders" and the key holders whose keys are staged, "DataHash" and the signed data hash row of the staged
+// container, "CODIS" and the row encrypted with the new keys, the last two only if there is such data
+// the journal is the commit point: with a journal the staged files are moved in place, without it they are removed
+type Rotation struct {
+	Keyholders []int
+	DataHash   []string
+	CODIS      []string
+}
+
+// Get the path of the rotation journal of an individual
+func RotationPath(people auxiliary.People) string {
+	return filepath.Dir(KeyFilePath(people, 1)) + "/Rotation.csv"
+}
+
+// Write the journal of a rotation whose keys, container and CODIS row are staged, then apply it
+func commitRotation(people auxiliary.People, rotation Rotation) {
+	writeRotation(people, rotation)
+	applyRotation(people, rotation)
+}
+
+// Write the journal of a rotation
+func writeRotation(people auxiliary.People, rotation Rotation) {
+	keyholders := []string{"Keyholders"}
+	for _, k := range rotation.Keyholders {
+		keyholders = append(keyholders, strconv.Itoa(k))
+	}
+	rows := [][]string{keyholders}
+	if rotation.DataHash != nil {
+		rows = append(rows, append([]string{"DataHash"}, rotation.DataHash...))
+	}
+	if rotation.CODIS != nil {
+		rows = append(rows, append([]string{"CODIS"}, rotation.CODIS...))
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.WriteAll(rows)
+	if err := writeFileSync(RotationPath(people), buf.Bytes(), keystoreFileMode); err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+}
+
+// Move the staged files of a committed rotation in place and remove what was issued with the old keys
+// every step can be repeated, so a rotation interrupted here is applied again by RecoverRotation
+func applyRotation(people auxiliary.People, rotation Rotation) {
+	for _, k := range rotation.Keyholders {
+		CommitRawKey(people, k)
+	}
+	if rotation.DataHash != nil {
+		path := SegmentFilePath(people, ".bin")
+		if err := os.Rename(path+".new", path); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("can not write, err is %+v", err)
+		}
+		appendDataHash(rotation.DataHash)
+	}
+	if rotation.CODIS != nil {
+		replaceCODISRow(people, rotation.CODIS)
+	}
+	for _, k := range rotation.Keyholders {
+		RemoveSnarkArtifacts(people, k)
+	}
+	RemoveWarmupCache(people)
+
+	if err := os.Remove(RotationPath(people)); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+}
+
+// Read the journal of a rotation, false if there is none
+func readRotation(people auxiliary.People) (Rotation, bool) {
+	var rotation Rotation
+	file, err := os.Open(RotationPath(people))
+	if os.IsNotExist(err) {
+		return rotation, false
+	} else if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("can not read, err is %+v", err)
+		}
+		switch row[0] {
+		case "Keyholders":
+			for _, s := range row[1:] {
+				k, err := strconv.Atoi(s)
+				if err != nil {
+					log.Fatalf("can not read, %s is damaged", RotationPath(people))
+				}
+				rotation.Keyholders = append(rotation.Keyholders, k)
+			}
+		case "DataHash":
+			rotation.DataHash = row[1:]
+		case "CODIS":
+			rotation.CODIS = row[1:]
+		default:
+			log.Fatalf("can not read, %s is damaged", RotationPath(people))
+		}
+	}
+	return rotation, true
+}
+
+// Finish or roll back an interrupted key rotation of an individual
+// a committed rotation is applied again, the staged keys and container of an uncommitted one are removed
+func RecoverRotation(people auxiliary.People) {
+	if rotation, ok := readRotation(people); ok {
+		applyRotation(people, rotation)
+		fmt.Println("Finished the interrupted key rotation of " + people.Name)
+		return
+	}
+	discarded := false
+	for k := 1; k <= auxiliary.ReadKeySharing().Holders; k++ {
+		if _, err := os.Stat(KeyFilePath(people, k) + ".new"); err == nil {
+			DiscardRawKey(people, k)
+			discarded = true
+		}
+	}
+	for _, ext := range []string{".bin.new", ".bin.new.tmp"} {
+		if err := os.Remove(SegmentFilePath(people, ext)); err == nil {
+			discarded = true
+		}
+	}
+	if discarded {
+		fmt.Println("Rolled back the interrupted key rotation of " + people.Name)
+	}
+}
+
+// Finish or roll back the interrupted key rotations of all individuals, called at startup
+func RecoverRotations() {
+	for _, people := range auxiliary.ReadIndividuals() {
+		RecoverRotation(people)
+	}
+}
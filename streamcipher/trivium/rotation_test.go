@@ -0,0 +1,130 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/applications"
+	"Governome/auxiliary"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Set up Alice with encrypted segments from testdata/legacy/Alice_Plaintext.csv and an encrypted CODIS record
+func newRotationRoot(t *testing.T) (auxiliary.People, map[int]int, applications.CODIS) {
+	plaintext, _ := filepath.Abs("testdata/legacy/Alice_Plaintext.csv")
+	people := newTestRoot(t, "Alice")[0]
+	RSIDs, GTs := auxiliary.ReadGenotypeCSV(plaintext)
+	want := make(map[int]int)
+	for i := range RSIDs {
+		want[RSIDs[i]] = GTs[i]
+	}
+	os.MkdirAll(filepath.Dir(DataHashPath()), os.ModePerm)
+	EncryptAndSaveSegments(people, DivideVariantsIntoSegments(people, RSIDs, GTs))
+
+	cod := applications.GenRandomCODIS()
+	os.MkdirAll(auxiliary.ReadPath()+"/CODIS_Data", os.ModePerm)
+	f, _ := os.Create(auxiliary.ReadPath() + "/CODIS_Data/Random_CODIS_Data.csv")
+	w := csv.NewWriter(f)
+	w.Write(cod.Decode2String())
+	w.Flush()
+	f.Close()
+	EncAndSaveCODIS_Trivium()
+
+	// The plaintext CODIS is not used by the rotation, the ciphertext is decrypted instead
+	os.Remove(auxiliary.ReadPath() + "/CODIS_Data/Random_CODIS_Data.csv")
+	return people, want, cod
+}
+
+// Check that the segments and the CODIS of Alice decrypt to the plaintext with the current keys
+func checkRotationData(t *testing.T, people auxiliary.People, want map[int]int, cod applications.CODIS) {
+	t.Helper()
+	sample := DecryptSegmentsToSample(people)
+	if len(sample.RsID) != len(want) {
+		t.Fatalf("%d variants, want %d", len(sample.RsID), len(want))
+	}
+	for i, rsid := range sample.RsID {
+		if want[rsid] != sample.Genotype[i] {
+			t.Fatalf("rs%d: got %s, want %s", rsid, auxiliary.Genotype_i2s(sample.Genotype[i]), auxiliary.Genotype_i2s(want[rsid]))
+		}
+	}
+
+	keys := LoadRawKeys(people)
+	enc_cod, keyhashes := ReadCODISData(0, auxiliary.ReadPath())
+	for k := range keyhashes[0] {
+		if !auxiliary.HashEqual(keyhashes[0][k], keys.Keyhashes[k]) {
+			t.Fatalf("key hash %d of the CODIS row is not the one of the key", k+1)
+		}
+	}
+	if got := Decode_CODIS([]CODIS{XOR_CODIS(Encode_Single_CODIS(enc_cod[0]), keys)})[0]; got != cod {
+		t.Fatalf("CODIS: got %v, want %v", got, cod)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	people, want, cod := newRotationRoot(t)
+	old := ReadRawKeyhash(people, 2)
+	RotateKey(people, 2)
+	if auxiliary.HashEqual(old, ReadRawKeyhash(people, 2)) {
+		t.Fatal("the key is not replaced")
+	}
+	checkRotationData(t, people, want, cod)
+}
+
+// A crash before the journal is written keeps the old keys and data
+func TestRecoverRotationRollBack(t *testing.T) {
+	people, want, cod := newRotationRoot(t)
+	old := ReadRawKeyhash(people, 2)
+	stageRotation(people, 2)
+
+	RecoverRotation(people)
+	if !auxiliary.HashEqual(old, ReadRawKeyhash(people, 2)) {
+		t.Fatal("the staged key is committed")
+	}
+	for _, path := range []string{KeyFilePath(people, 2) + ".new", SegmentFilePath(people, ".bin.new")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is not removed", path)
+		}
+	}
+	checkRotationData(t, people, want, cod)
+}
+
+// A crash after the journal is written finishes the rotation
+func TestRecoverRotationRollForward(t *testing.T) {
+	people, want, cod := newRotationRoot(t)
+	rotation := stageRotation(people, 2)
+	writeRotation(people, rotation)
+	// the crash happens after the key is moved in place
+	CommitRawKey(people, 2)
+
+	RecoverRotation(people)
+	if _, err := os.Stat(RotationPath(people)); !os.IsNotExist(err) {
+		t.Error("the journal is not removed")
+	}
+	checkRotationData(t, people, want, cod)
+}
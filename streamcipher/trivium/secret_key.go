@@ -0,0 +1,229 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Sealed secret key file, all integers are little endian
+// header: magic, version, fingerprint of the TFHE parameters (with a uint16 length), scrypt salt
+// body: the secret key sealed with the key derived from the passphrase, the header is the associated data
+// the passphrase is read from the environment variable GOVERNOME_SK_PASSPHRASE
+// a toy secret key can also be exported unsealed for demos, with the same header and no salt
+const (
+	SecretKey_Magic      = "GVSK"
+	SecretKey_DemoMagic  = "GVSD"
+	SecretKey_Version    = 1
+	SK_Passphrase_EnvVar = "GOVERNOME_SK_PASSPHRASE"
+	secretKeyFileMode    = 0600
+)
+
+// Get the path of the secret key, ext is ".sealed" for the sealed key, ".demo" for the demo export, "" for the legacy raw key
+func SecretKeyPath(ext string) string {
+	return auxiliary.ReadPath() + "/Key_Information/Trivium_SecretKey" + ext
+}
+
+// Get the passphrase of the secret key from the environment
+func SKPassphrase() string {
+	passphrase := os.Getenv(SK_Passphrase_EnvVar)
+	if passphrase == "" {
+		log.Fatalf("Please set the passphrase of the secret key in %s", SK_Passphrase_EnvVar)
+	}
+	return passphrase
+}
+
+// Header of a secret key file
+func secretKeyHeader(magic string, params tfhe.Parameters[uint32], salt []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	binary.Write(&buf, binary.LittleEndian, uint16(SecretKey_Version))
+	writeBytes16(&buf, ParamsFingerprint(params))
+	buf.Write(salt)
+	return buf.Bytes()
+}
+
+// Check the header of a secret key file against params, return the rest of the file
+func checkSecretKeyHeader(file []byte, magic string, params tfhe.Parameters[uint32]) ([]byte, error) {
+	if len(file) < len(magic) || string(file[:len(magic)]) != magic {
+		return nil, errors.New("not a secret key file")
+	}
+	r := bytes.NewReader(file[len(magic):])
+	var version uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != SecretKey_Version {
+		return nil, fmt.Errorf("unsupported secret key version %d", version)
+	}
+	fingerprint, err := readBytes16(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fingerprint, ParamsFingerprint(params)) {
+		return nil, errors.New("the secret key is generated under other TFHE parameters than the given ones")
+	}
+	return file[len(file)-r.Len():], nil
+}
+
+// Encode the secret key of enc
+func secretKeyBytes(enc *tfhe.BinaryEncryptor) []byte {
+	var buf bytes.Buffer
+	enc.BaseEncryptor.SecretKey.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// Load an encoded secret key into enc, its size must match the parameters of enc
+func loadSecretKey(enc *tfhe.BinaryEncryptor, data []byte) error {
+	if len(data) != enc.BaseEncryptor.SecretKey.ByteSize() {
+		return errors.New("the secret key does not match the given parameters")
+	}
+	_, err := enc.BaseEncryptor.SecretKey.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// Write a secret key file with mode 0600, replacing any older file
+func writeSecretKeyFile(path string, data []byte) {
+	os.MkdirAll(auxiliary.ReadPath()+"/Key_Information/", os.ModePerm)
+	if err := os.WriteFile(path+".tmp", data, secretKeyFileMode); err != nil {
+		log.Fatalf("can not write %s, err is %+v", path, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Fatalf("can not write %s, err is %+v", path, err)
+	}
+}
+
+// Save the secret key of tfheb sealed with the passphrase
+func Save_SK(enc *tfhe.BinaryEncryptor, passphrase string) {
+	if passphrase == "" {
+		log.Fatalf("Please give a passphrase to seal the secret key")
+	}
+	salt := auxiliary.RandomBytes(auxiliary.Seal_SaltSize)
+	header := secretKeyHeader(SecretKey_Magic, enc.Parameters, salt)
+	sealed := auxiliary.Seal(auxiliary.DeriveSealKey(passphrase, salt), secretKeyBytes(enc), header)
+	writeSecretKeyFile(SecretKeyPath(".sealed"), append(header, sealed...))
+}
+
+// Read the sealed secret key of tfheb into enc, the key must be generated under the parameters of enc
+func ReadSK(enc *tfhe.BinaryEncryptor, passphrase string) {
+	if passphrase == "" {
+		log.Fatalf("Please give the passphrase of the secret key")
+	}
+	path := SecretKeyPath(".sealed")
+	file, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(SecretKeyPath("")); err == nil {
+			log.Fatalf("The secret key in %s is not sealed, please seal it with -convert", SecretKeyPath(""))
+		}
+	}
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+
+	body, err := checkSecretKeyHeader(file, SecretKey_Magic, enc.Parameters)
+	if err != nil || len(body) < auxiliary.Seal_SaltSize {
+		log.Fatalf("can not read %s, err is %+v", path, err)
+	}
+	salt := body[:auxiliary.Seal_SaltSize]
+	header := file[:len(file)-len(body)+auxiliary.Seal_SaltSize]
+	data, err := auxiliary.Unseal(auxiliary.DeriveSealKey(passphrase, salt), body[auxiliary.Seal_SaltSize:], header)
+	if err != nil {
+		log.Fatalf("Wrong passphrase of the secret key")
+	}
+	if err := loadSecretKey(enc, data); err != nil {
+		log.Fatalf("can not read %s, err is %+v", path, err)
+	}
+}
+
+// Whether params are the toy parameters, only a toy secret key may leave the sealed file
+func isToyParams(params tfhe.Parameters[uint32]) bool {
+	return bytes.Equal(ParamsFingerprint(params), ParamsFingerprint(auxiliary.ParamsToyBoolean.Compile()))
+}
+
+// Export the secret key of enc unsealed for demos, only for the toy parameters
+func ExportDemoSK(enc *tfhe.BinaryEncryptor) {
+	if !isToyParams(enc.Parameters) {
+		log.Fatalf("Only a secret key of the toy parameters can be exported for demos")
+	}
+	header := secretKeyHeader(SecretKey_DemoMagic, enc.Parameters, nil)
+	writeSecretKeyFile(SecretKeyPath(".demo"), append(header, secretKeyBytes(enc)...))
+}
+
+// Read the demo export of the secret key into enc, only for the toy parameters
+func ReadDemoSK(enc *tfhe.BinaryEncryptor) {
+	if !isToyParams(enc.Parameters) {
+		log.Fatalf("Only a secret key of the toy parameters can be read from the demo export")
+	}
+	path := SecretKeyPath(".demo")
+	file, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	body, err := checkSecretKeyHeader(file, SecretKey_DemoMagic, enc.Parameters)
+	if err == nil {
+		err = loadSecretKey(enc, body)
+	}
+	if err != nil {
+		log.Fatalf("can not read %s, err is %+v", path, err)
+	}
+}
+
+// Seal the legacy raw secret key generated under params with the passphrase, the raw key is removed
+func SealLegacySK(params tfhe.Parameters[uint32], passphrase string) {
+	path := SecretKeyPath("")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	enc := tfhe.NewBinaryEncryptor(params)
+	if err := loadSecretKey(enc, data); err != nil {
+		log.Fatalf("can not read %s, err is %+v", path, err)
+	}
+	Save_SK(enc, passphrase)
+	if err := os.Remove(path); err != nil {
+		log.Fatalf("can not remove %s, err is %+v", path, err)
+	}
+}
+
+// Read the secret key for a query, the sealed key if the passphrase is set, else the demo export of a toy key
+func LoadSK(enc *tfhe.BinaryEncryptor) {
+	if os.Getenv(SK_Passphrase_EnvVar) == "" && isToyParams(enc.Parameters) {
+		if _, err := os.Stat(SecretKeyPath(".demo")); err == nil {
+			ReadDemoSK(enc)
+			return
+		}
+	}
+	ReadSK(enc, SKPassphrase())
+}
@@ -0,0 +1,814 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Binary segment container of an individual, all integers are little endian
+// header: magic, version, seg_num, minimal blocksize, key bits, genotype bits, name, uint16 number of key holders,
+// the keyhash of each key holder, merkle root (name, keyhashes and root each with a uint16 length)
+// index: seg_num entries of (offset of the segment in the file uint64, variants amount uint32, IV version uint32)
+// tree: all nodes of the Merkle tree over the segments, level by level from the leaves, each in a hash block
+// a leaf commits the segment ID, the IV version and the packed data
+// data: the variants of each segment, bit-packed as Rsid bits then Genotype bits, padded to whole bytes per segment
+const (
+	SegmentFile_Magic   = "GVSG"
+	SegmentFile_Version = 5
+	segIndexSize        = 16
+)
+
+// Header of a segment container
+type SegmentHeader struct {
+	Name              string
+	Seg_num           int
+	Minimal_Blocksize int
+	Key_Bits          int
+	Genotype_Bits     int
+	Keyhashes         [][]byte
+	Root              []byte
+}
+
+// An opened segment container, segments are read by ReadSegment with random access
+type SegmentFile struct {
+	Header     SegmentHeader
+	file       *os.File
+	indexStart int64
+	treeStart  int64
+	levelStart []int64
+}
+
+// Get the path of the segment file of an individual, ext is ".bin" for the container, ".csv" for the legacy format
+func SegmentFilePath(people auxiliary.People, ext string) string {
+	return segmentFilePathWith(people, people.Name+"_Segments"+ext)
+}
+
+// Get the path of the segment file of an individual written in precomputed mode before the key modes were recorded
+func legacyHostedSegmentFilePath(people auxiliary.People, ext string) string {
+	return segmentFilePathWith(people, people.Name+"_Hosted_Segments"+ext)
+}
+
+// Get the path of a file in the segment folder of an individual
+func segmentFilePathWith(people auxiliary.People, file_name string) string {
+	file_path := auxiliary.ReadPath() + "/Segments_Enc_Data/" + auxiliary.MappingPeopletoFolder(people) + "/" + file_name
+	path, _ := filepath.Abs(file_path)
+	return path
+}
+
+// Size of the header in bytes, where the index starts
+func (h *SegmentHeader) size() int64 {
+	size := len(SegmentFile_Magic) + 2 + 4 + 2 + 2 + 2 + 2 + len(h.Name) + 2 + 2 + len(h.Root)
+	for _, keyhash := range h.Keyhashes {
+		size += 2 + len(keyhash)
+	}
+	return int64(size)
+}
+
+// Index of the first node of each level in the stored tree
+func levelStarts(seg_num int) (starts []int64, total int64) {
+	for _, size := range auxiliary.MerkleLevelSizes(seg_num) {
+		starts = append(starts, total)
+		total += int64(size)
+	}
+	return
+}
+
+// Pack the variants into bytes
+func packVariants(Variants []Variant) []byte {
+	res := make([]byte, (len(Variants)*(auxiliary.Key_Bits+auxiliary.Genotype_Bits)+7)/8)
+	pos := 0
+	for i := 0; i < len(Variants); i++ {
+		for j := 0; j < auxiliary.Key_Bits; j++ {
+			res[pos/8] |= byte(Variants[i].Rsid[j]) << (pos % 8)
+			pos++
+		}
+		for j := 0; j < auxiliary.Genotype_Bits; j++ {
+			res[pos/8] |= byte(Variants[i].Genotype[j]) << (pos % 8)
+			pos++
+		}
+	}
+	return res
+}
+
+// Unpack the bytes into seg_len variants
+func unpackVariants(data []byte, seg_len int) []Variant {
+	Variants := make([]Variant, seg_len)
+	pos := 0
+	for i := 0; i < seg_len; i++ {
+		for j := 0; j < auxiliary.Key_Bits; j++ {
+			Variants[i].Rsid[j] = int(data[pos/8] >> (pos % 8) & 1)
+			pos++
+		}
+		for j := 0; j < auxiliary.Genotype_Bits; j++ {
+			Variants[i].Genotype[j] = int(data[pos/8] >> (pos % 8) & 1)
+			pos++
+		}
+	}
+	return Variants
+}
+
+// Write a byte string with a uint16 length
+func writeBytes16(w io.Writer, b []byte) {
+	binary.Write(w, binary.LittleEndian, uint16(len(b)))
+	w.Write(b)
+}
+
+// Read a byte string with a uint16 length
+func readBytes16(r io.Reader) ([]byte, error) {
+	var l uint16
+	if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+		return nil, err
+	}
+	b := make([]byte, l)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// Leaf of a segment in the Merkle tree
+func segmentLeaf(segID int, version int, packed []byte) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(version))
+	return auxiliary.MerkleLeaf(segID, append(data, packed...), auxiliary.Mimchashcurve)
+}
+
+// Save the encrypted segments of an individual to a segment container, return the Merkle root over the segments
+// versions holds the IV version of each segment, nil for all 0
+func SaveSegmentFile(path string, header SegmentHeader, Segments [][]Variant, versions []int) []byte {
+	if versions == nil {
+		versions = make([]int, len(Segments))
+	}
+	packed := make([][]byte, len(Segments))
+	leaves := make([][]byte, len(Segments))
+	for i := 0; i < len(Segments); i++ {
+		packed[i] = packVariants(Segments[i])
+		leaves[i] = segmentLeaf(i, versions[i], packed[i])
+	}
+	tree := auxiliary.BuildMerkleTree(leaves, auxiliary.Mimchashcurve)
+	header.Seg_num = len(Segments)
+	header.Root = tree[len(tree)-1][0]
+	writeSegmentFile(path, header, packed, versions, tree)
+	return header.Root
+}
+
+// Write a segment container from the packed segments and the Merkle tree
+func writeSegmentFile(path string, header SegmentHeader, packed [][]byte, versions []int, tree [][][]byte) {
+	blocksize := auxiliary.Mimchashcurve.Size()
+	_, nodes := levelStarts(len(packed))
+
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	// write to a temporary file first, so a failed write never damages the container
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		log.Fatalf("can not create %s, err is %+v", path, err)
+	}
+	w := bufio.NewWriter(f)
+
+	w.WriteString(SegmentFile_Magic)
+	binary.Write(w, binary.LittleEndian, uint16(SegmentFile_Version))
+	binary.Write(w, binary.LittleEndian, uint32(len(packed)))
+	binary.Write(w, binary.LittleEndian, uint16(header.Minimal_Blocksize))
+	binary.Write(w, binary.LittleEndian, uint16(auxiliary.Key_Bits))
+	binary.Write(w, binary.LittleEndian, uint16(auxiliary.Genotype_Bits))
+	writeBytes16(w, []byte(header.Name))
+	binary.Write(w, binary.LittleEndian, uint16(len(header.Keyhashes)))
+	for _, keyhash := range header.Keyhashes {
+		writeBytes16(w, keyhash)
+	}
+	writeBytes16(w, header.Root)
+
+	bits := auxiliary.Key_Bits + auxiliary.Genotype_Bits
+	offset := header.size() + int64(len(packed))*segIndexSize + nodes*int64(blocksize)
+	for i := 0; i < len(packed); i++ {
+		binary.Write(w, binary.LittleEndian, uint64(offset))
+		binary.Write(w, binary.LittleEndian, uint32(len(packed[i])*8/bits))
+		binary.Write(w, binary.LittleEndian, uint32(versions[i]))
+		offset += int64(len(packed[i]))
+	}
+	for _, level := range tree {
+		for _, node := range level {
+			w.Write(auxiliary.PadBytes(node, blocksize))
+		}
+	}
+	for i := 0; i < len(packed); i++ {
+		w.Write(packed[i])
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Fatalf("can not write %s, err is %+v", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		log.Fatalf("can not write %s, err is %+v", path, err)
+	}
+	f.Close()
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Fatalf("can not write %s, err is %+v", path, err)
+	}
+}
+
+// Open a segment container and read its header
+func OpenSegmentFile(path string) (*SegmentFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sf := &SegmentFile{file: f}
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(SegmentFile_Magic))
+	var version, blocksize, key_bits, genotype_bits uint16
+	var seg_num uint32
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != SegmentFile_Magic {
+		f.Close()
+		return nil, errors.New(path + " is not a segment container")
+	}
+	binary.Read(r, binary.LittleEndian, &version)
+	binary.Read(r, binary.LittleEndian, &seg_num)
+	binary.Read(r, binary.LittleEndian, &blocksize)
+	binary.Read(r, binary.LittleEndian, &key_bits)
+	if err := binary.Read(r, binary.LittleEndian, &genotype_bits); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if version != SegmentFile_Version || int(key_bits) != auxiliary.Key_Bits || int(genotype_bits) != auxiliary.Genotype_Bits {
+		f.Close()
+		return nil, errors.New(path + " is written with another version or variant encoding, please encrypt the data again")
+	}
+	name, _ := readBytes16(r)
+	var holders uint16
+	binary.Read(r, binary.LittleEndian, &holders)
+	keyhashes := make([][]byte, holders)
+	for k := 0; k < int(holders); k++ {
+		keyhashes[k], _ = readBytes16(r)
+	}
+	root, err := readBytes16(r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	sf.Header = SegmentHeader{Name: string(name), Seg_num: int(seg_num), Minimal_Blocksize: int(blocksize), Key_Bits: int(key_bits), Genotype_Bits: int(genotype_bits), Keyhashes: keyhashes, Root: root}
+	sf.indexStart = sf.Header.size()
+	sf.treeStart = sf.indexStart + int64(seg_num)*segIndexSize
+	sf.levelStart, _ = levelStarts(int(seg_num))
+	return sf, nil
+}
+
+// Open the segment container of an individual, the container must be built with the segmentation parameters of the dataset
+func openSegmentFile(people auxiliary.People) *SegmentFile {
+	sf, err := OpenSegmentFile(SegmentFilePath(people, ".bin"))
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	params := auxiliary.ReadSegParams()
+	if sf.Header.Seg_num != params.Seg_num || sf.Header.Minimal_Blocksize != params.Minimal_Blocksize {
+		log.Fatalf("Segments of %s are built with %d segments and blocksize %d, but the dataset uses %+v", people.Name, sf.Header.Seg_num, sf.Header.Minimal_Blocksize, params)
+	}
+	return sf
+}
+
+// Read the index entry of a segment: offset, variants amount and IV version
+func (sf *SegmentFile) readEntry(segID int) (offset int64, seg_len int, version int, err error) {
+	if segID < 0 || segID >= sf.Header.Seg_num {
+		return 0, 0, 0, errors.New("segment " + strconv.Itoa(segID) + " out of range")
+	}
+	entry := make([]byte, segIndexSize)
+	if _, err = sf.file.ReadAt(entry, sf.indexStart+int64(segID)*segIndexSize); err != nil {
+		return
+	}
+	offset = int64(binary.LittleEndian.Uint64(entry[0:8]))
+	seg_len = int(binary.LittleEndian.Uint32(entry[8:12]))
+	version = int(binary.LittleEndian.Uint32(entry[12:16]))
+	return
+}
+
+// Read the packed data of a segment without verification
+func (sf *SegmentFile) readRaw(segID int) (data []byte, seg_len int, version int, err error) {
+	offset, seg_len, version, err := sf.readEntry(segID)
+	if err != nil {
+		return
+	}
+	data = make([]byte, (seg_len*(auxiliary.Key_Bits+auxiliary.Genotype_Bits)+7)/8)
+	_, err = sf.file.ReadAt(data, offset)
+	return
+}
+
+// Read the packed data of a segment, verified against the Merkle root
+func (sf *SegmentFile) readPacked(segID int) (data []byte, seg_len int, version int, err error) {
+	data, seg_len, version, err = sf.readRaw(segID)
+	if err != nil {
+		return
+	}
+	err = sf.verifySegment(segID, version, data)
+	return
+}
+
+// Read a segment by its ID with O(1) reads, together with its IV version
+func (sf *SegmentFile) ReadSegment(segID int) ([]Variant, int, error) {
+	data, seg_len, version, err := sf.readPacked(segID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return unpackVariants(data, seg_len), version, nil
+}
+
+// Read the IV version of a segment
+func (sf *SegmentFile) SegmentVersion(segID int) (int, error) {
+	_, _, version, err := sf.readEntry(segID)
+	return version, err
+}
+
+// Read the stored Merkle tree, the inner nodes are computed again from the stored leaves and checked against the root
+func (sf *SegmentFile) readTree() ([][][]byte, error) {
+	blocksize := int64(auxiliary.Mimchashcurve.Size())
+	sizes := auxiliary.MerkleLevelSizes(sf.Header.Seg_num)
+	_, nodes := levelStarts(sf.Header.Seg_num)
+	buf := make([]byte, nodes*blocksize)
+	if _, err := sf.file.ReadAt(buf, sf.treeStart); err != nil {
+		return nil, err
+	}
+	tree := make([][][]byte, len(sizes))
+	for l, size := range sizes {
+		tree[l] = make([][]byte, size)
+		for i := 0; i < size; i++ {
+			pos := (sf.levelStart[l] + int64(i)) * blocksize
+			tree[l][i] = buf[pos : pos+blocksize]
+		}
+	}
+	tree = auxiliary.BuildMerkleTree(tree[0], auxiliary.Mimchashcurve)
+	if !auxiliary.HashEqual(tree[len(tree)-1][0], sf.Header.Root) {
+		return nil, errors.New("Merkle tree of " + sf.Header.Name + " fails the integrity check")
+	}
+	return tree, nil
+}
+
+// Verify the packed data of a segment against the Merkle root in the header, with the path stored in the tree
+func (sf *SegmentFile) verifySegment(segID int, version int, data []byte) error {
+	blocksize := int64(auxiliary.Mimchashcurve.Size())
+	sibling := func(level, i int) ([]byte, error) {
+		node := make([]byte, blocksize)
+		_, err := sf.file.ReadAt(node, sf.treeStart+(sf.levelStart[level]+int64(i))*blocksize)
+		return node, err
+	}
+	leaf := segmentLeaf(segID, version, data)
+	root, err := auxiliary.MerkleRootFromPath(leaf, segID, sf.Header.Seg_num, sibling, auxiliary.Mimchashcurve)
+	if err != nil {
+		return err
+	}
+	if !auxiliary.HashEqual(root, sf.Header.Root) {
+		return errors.New("segment " + strconv.Itoa(segID) + " of " + sf.Header.Name + " fails the integrity check")
+	}
+	return nil
+}
+
+// Close the segment container
+func (sf *SegmentFile) Close() error {
+	return sf.file.Close()
+}
+
+// Legacy csv segments, written before the segment container by two key holders with keys of LegacyRawKey
+// the first row is the name and the key hashes ("Key hash1: N"), then two rows per segment, "Segment<ID>" with
+// "Variants amount: L", and L cells "rs<R> <G>" of the ciphertext of a variant: the 32 bits of the rsID in R and
+// the 4 bits of the genotype in G, written as a|b with a the upper and b the lower 2 bits
+// segment ID i is encrypted by Trivium with the IV of GenIVHostedMode(i), the keystream gives the rsID bits first
+const (
+	legacy_Rsid_Bits     = 32
+	legacy_Genotype_Bits = 4
+	legacy_Key_Bits      = 80
+)
+
+// A ciphertext variant of the legacy csv format
+type legacyVariant struct {
+	Rsid     int
+	Genotype int
+}
+
+// Parse a cell "rs<R> a|b" of the legacy csv format
+func parseLegacyVariant(cell string) (v legacyVariant, err error) {
+	fields := strings.Split(cell, " ")
+	if len(fields) != 2 || !strings.HasPrefix(fields[0], "rs") {
+		return v, fmt.Errorf("malformed variant %q", cell)
+	}
+	rsid, err := strconv.ParseUint(fields[0][2:], 10, legacy_Rsid_Bits)
+	if err != nil {
+		return v, fmt.Errorf("malformed variant %q", cell)
+	}
+	gt := fields[1]
+	if len(gt) != 3 || gt[1] != '|' || gt[0] < '0' || gt[0] > '3' || gt[2] < '0' || gt[2] > '3' {
+		return v, fmt.Errorf("malformed variant %q", cell)
+	}
+	v.Rsid = int(rsid)
+	v.Genotype = int(gt[0]-'0')<<2 | int(gt[2]-'0')
+	return v, nil
+}
+
+// Read the legacy csv segments at path, Segments[i] is segment ID i
+func ReadSegmentsCSV(path string) (name string, keyhashes [][]byte, Segments [][]legacyVariant, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	row, err := r.Read()
+	if err != nil {
+		return
+	}
+	if len(row) != 3 {
+		return name, nil, nil, errors.New("the first row is not the name and two key hashes")
+	}
+	name = row[0]
+	for _, col := range row[1:] {
+		kv := strings.SplitN(col, ": ", 2)
+		kval, ok := new(big.Int).SetString(kv[len(kv)-1], 10)
+		if len(kv) != 2 || !ok {
+			return name, nil, nil, fmt.Errorf("malformed key hash %q", col)
+		}
+		keyhashes = append(keyhashes, kval.Bytes())
+	}
+
+	for {
+		row, err = r.Read()
+		if err == io.EOF {
+			return name, keyhashes, Segments, nil
+		}
+		if err != nil {
+			return
+		}
+		if len(row) != 2 || row[0] != "Segment"+strconv.Itoa(len(Segments)) || !strings.HasPrefix(row[1], "Variants amount: ") {
+			return name, nil, nil, fmt.Errorf("row %v is not the header of segment %d", row, len(Segments))
+		}
+		seg_len, err := strconv.Atoi(strings.TrimPrefix(row[1], "Variants amount: "))
+		if err != nil || seg_len < 0 {
+			return name, nil, nil, fmt.Errorf("malformed segment header %v", row)
+		}
+		seg := make([]legacyVariant, seg_len)
+		// an empty segment is an empty line, which the csv reader skips
+		if seg_len > 0 {
+			if row, err = r.Read(); err != nil {
+				return name, nil, nil, fmt.Errorf("segment %d: %v", len(Segments), err)
+			}
+			if len(row) != seg_len {
+				return name, nil, nil, fmt.Errorf("segment %d has %d variants, the header says %d", len(Segments), len(row), seg_len)
+			}
+			for j := range seg {
+				if seg[j], err = parseLegacyVariant(row[j]); err != nil {
+					return name, nil, nil, fmt.Errorf("segment %d: %v", len(Segments), err)
+				}
+			}
+		}
+		Segments = append(Segments, seg)
+	}
+}
+
+// Decrypt the legacy csv segments of an individual, the files of precomputed mode are the "_Hosted" ones
+// padding variants are left out
+func decryptSegmentsCSV(people auxiliary.People, path string, precomputed bool) (RSIDs, GTs []int) {
+	name, keyhashes, Segments, err := ReadSegmentsCSV(path)
+	if err != nil {
+		log.Fatalf("can not read %s, err is %+v", path, err)
+	}
+	if name != people.Name {
+		log.Fatalf("%s holds the segments of %s, not %s", path, name, people.Name)
+	}
+	keyinfos := make([][]byte, 2)
+	for k := 1; k <= 2; k++ {
+		var keyhash []byte
+		keyinfos[k-1], keyhash = LegacyRawKey(people, k)
+		if !auxiliary.HashEqual(keyhash, keyhashes[k-1]) {
+			log.Fatalf("Key hash %d of %s does not match the legacy key", k, path)
+		}
+	}
+	modes := auxiliary.DefaultKeyModes(2, precomputed)
+
+	for i, seg := range Segments {
+		key := make([]int, legacy_Key_Bits)
+		for k := range keyinfos {
+			share := ModeShare(modes[k], keyinfos[k], i, legacy_Key_Bits, 1)
+			for j := range key {
+				key[j] ^= share[j]
+			}
+		}
+		triv := NewStreamCipher(auxiliary.Cipher_Trivium)
+		triv.Init(key, GenIVHostedMode(i, legacy_Key_Bits))
+		for _, v := range seg {
+			rsid, gt := v.Rsid, v.Genotype
+			for b := 0; b < legacy_Rsid_Bits; b++ {
+				rsid ^= triv.Genbit() << b
+			}
+			for b := 0; b < legacy_Genotype_Bits; b++ {
+				gt ^= triv.Genbit() << b
+			}
+			if rsid == 0 && gt == 0 {
+				continue
+			}
+			RSIDs = append(RSIDs, rsid)
+			GTs = append(GTs, auxiliary.Genotype_s2i(strconv.Itoa(gt>>2)+"|"+strconv.Itoa(gt&3)))
+		}
+	}
+	return
+}
+
+// Convert the legacy csv segments of an individual to a segment container, the csv file is kept
+// the legacy keys are derived from public strings, so the segments are decrypted and encrypted again with the keys of
+// the keystore, under the cipher, key modes and segment parameters of the dataset
+func ConvertSegmentsCSV(people auxiliary.People) {
+	path, precomputed := SegmentFilePath(people, ".csv"), false
+	if _, err := os.Stat(path); err != nil {
+		path, precomputed = legacyHostedSegmentFilePath(people, ".csv"), true
+	}
+	RSIDs, GTs := decryptSegmentsCSV(people, path, precomputed)
+	EncryptAndSaveSegments(people, DivideVariantsIntoSegments(people, RSIDs, GTs))
+}
+
+// Convert the legacy csv segments of all individuals to segment containers
+func ConvertAllSegmentsCSV() {
+	Indivs := auxiliary.ReadIndividuals()
+	for i := 0; i < len(Indivs); i++ {
+		ConvertSegmentsCSV(Indivs[i])
+	}
+}
+
+// Move the files written in precomputed mode before the key modes were recorded, the "_Hosted" segment files and
+// EncStrHosted.csv, to the paths of the dataset, the dataset must be recorded with DefaultKeyModes(holders, true)
+// a file of the other mode at those paths belongs to another dataset, so nothing is moved if any of them exists,
+// and a file is never moved over another one, the data hashes recorded as "Hosted" stay valid
+func MigrateLegacyHostedFiles() {
+	modes := auxiliary.ReadKeyModes()
+	if !modes.Equal(auxiliary.DefaultKeyModes(auxiliary.ReadKeySharing().Holders, true)) {
+		log.Fatalf("The dataset is recorded with key modes %s, the files of precomputed mode do not belong to it", modes)
+	}
+
+	dicpath := auxiliary.ReadPath()
+	moves := [][2]string{{dicpath + "/EncSTR/EncStrHosted.csv", codisFilePath(dicpath)}}
+	for _, people := range auxiliary.ReadAllIndividuals() {
+		for _, ext := range []string{".bin", ".csv"} {
+			moves = append(moves, [2]string{legacyHostedSegmentFilePath(people, ext), SegmentFilePath(people, ext)})
+		}
+	}
+
+	var pending [][2]string
+	for _, move := range moves {
+		if _, err := os.Stat(move[0]); err != nil {
+			continue
+		}
+		if _, err := os.Stat(move[1]); err == nil {
+			log.Fatalf("%s and %s both exist, the data of both modes can not be kept under the same root folder, move one of them to another root folder", move[0], move[1])
+		}
+		pending = append(pending, move)
+	}
+
+	for _, move := range pending {
+		// a hard link fails if the target exists, unlike a rename
+		if err := os.Link(move[0], move[1]); err != nil {
+			log.Fatalf("can not move %s, err is %+v", move[0], err)
+		}
+		if err := os.Remove(move[0]); err != nil {
+			log.Fatalf("can not move %s, err is %+v", move[0], err)
+		}
+	}
+	fmt.Println("Moved " + strconv.Itoa(len(pending)) + " files of precomputed mode")
+}
+
+// Lock of the data hash registry, individuals are encrypted in parallel
+var datahashLock sync.Mutex
+
+// Get the path of the data hash registry
+func DataHashPath() string {
+	return auxiliary.ReadPath() + "/Segments_Enc_Data/DataHash.csv"
+}
+
+// Whether a row of the data hash registry belongs to the key modes of the dataset, the rows written before the key modes
+// were recorded name the mode "Default" or "Hosted" (precomputed), those of the matching DefaultKeyModes are accepted
+func datahashModeMatch(name string, modes auxiliary.KeyModes) bool {
+	switch name {
+	case modes.String():
+		return true
+	case "Default":
+		return modes.Equal(auxiliary.DefaultKeyModes(len(modes), false))
+	case "Hosted":
+		return modes.Equal(auxiliary.DefaultKeyModes(len(modes), true))
+	}
+	return false
+}
+
+// Signature domain of the data hash registry
+const dataHashDomain = "data hash"
+
+// Message signed by a row of the data hash registry, the name, the key modes and the root
+func dataHashMessage(row []string) []byte {
+	return []byte(row[0] + "\x00" + row[1] + "\x00" + row[2])
+}
+
+// Append the Merkle root of an individual to the data hash registry, the last record of an individual is valid
+// the root is the datahash to be stored on chain by storeGenome, the row is signed by the signer of the dataset
+func SaveDataHash(people auxiliary.People, root []byte) {
+	appendDataHash(signedDataHash(people, root))
+}
+
+// Signed row of the data hash registry
+func signedDataHash(people auxiliary.People, root []byte) []string {
+	row := []string{people.Name, auxiliary.ReadKeyModes().String(), new(big.Int).SetBytes(root).String()}
+	return append(row, hex.EncodeToString(auxiliary.Sign(dataHashDomain, dataHashMessage(row))))
+}
+
+// Append a signed row to the data hash registry
+func appendDataHash(row []string) {
+	datahashLock.Lock()
+	defer datahashLock.Unlock()
+
+	f, err := os.OpenFile(DataHashPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write(row)
+	w.Flush()
+	f.Close()
+}
+
+// Read the data hash registry of the key modes of the dataset, map the name to the Merkle root
+// a row of the dataset that is not signed by the signer is fatal, so the registry can not be rewritten along with the data
+func ReadDataHash() map[string][]byte {
+	res, err := readDataHash()
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	return res
+}
+
+// Read and verify the data hash registry
+func readDataHash() (map[string][]byte, error) {
+	modes := auxiliary.ReadKeyModes()
+	res := make(map[string][]byte)
+	file, err := os.Open(DataHashPath())
+	if err != nil {
+		return res, nil
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) < 3 {
+			return nil, fmt.Errorf("data hash row %v is damaged", row)
+		}
+		if !datahashModeMatch(row[1], modes) {
+			continue
+		}
+		if len(row) < 4 {
+			return nil, fmt.Errorf("data hash of %s is not signed, sign the registry with -convert", row[0])
+		}
+		sig, err := hex.DecodeString(row[3])
+		if err != nil || !auxiliary.VerifySignature(dataHashDomain, dataHashMessage(row), sig) {
+			return nil, fmt.Errorf("data hash of %s is not signed by the signer", row[0])
+		}
+		val, ok := new(big.Int).SetString(row[2], 10)
+		if !ok {
+			return nil, fmt.Errorf("data hash of %s is damaged", row[0])
+		}
+		res[row[0]] = val.Bytes()
+	}
+	return res, nil
+}
+
+// Sign the rows of the data hash registry written before the rows were signed, the registry is replaced atomically
+// the signer vouches for the unsigned rows, so the data should be checked against the datahash on chain before
+func SignLegacyDataHash() {
+	datahashLock.Lock()
+	defer datahashLock.Unlock()
+
+	file, err := os.Open(DataHashPath())
+	if err != nil {
+		return
+	}
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	file.Close()
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	signed := 0
+	for i, row := range rows {
+		if len(row) == 3 {
+			rows[i] = append(row, hex.EncodeToString(auxiliary.Sign(dataHashDomain, dataHashMessage(row))))
+			signed++
+		}
+	}
+	if signed == 0 {
+		return
+	}
+	tmp := DataHashPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	w := csv.NewWriter(f)
+	w.WriteAll(rows)
+	if err := f.Close(); err != nil || w.Error() != nil {
+		log.Fatalf("can not write, err is %+v %+v", err, w.Error())
+	}
+	if err := os.Rename(tmp, DataHashPath()); err != nil {
+		log.Fatalf("can not write, err is %+v", err)
+	}
+	fmt.Println("Signed " + strconv.Itoa(signed) + " data hashes")
+}
+
+// Read a ciphertext segment whose container matches the registered data hash, the segment is verified against the root
+func ReadSegmentDataVerified(people auxiliary.People, segID int, datahash map[string][]byte) []Variant {
+	sf := openSegmentFile(people)
+	defer sf.Close()
+
+	if !auxiliary.HashEqual(sf.Header.Root, datahash[people.Name]) {
+		log.Fatalf("Segments of %s do not match the registered data hash", people.Name)
+	}
+	Variants, _, err := sf.ReadSegment(segID)
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	return Variants
+}
+
+// Replace some segments of a container, the other segments are copied without decryption and only the paths
+// of the replaced segments in the Merkle tree are computed again, return the new root
+// a copied segment that has been modified keeps its old leaf, so it still fails the integrity check afterwards
+func UpdateSegmentFile(path string, root []byte, updates map[int][]Variant, versions map[int]int) []byte {
+	sf, err := OpenSegmentFile(path)
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+	header := sf.Header
+	if !auxiliary.HashEqual(header.Root, root) {
+		log.Fatalf("Segments of %s do not match the registered data hash", header.Name)
+	}
+	tree, err := sf.readTree()
+	if err != nil {
+		log.Fatalf("can not read, err is %+v", err)
+	}
+
+	packed := make([][]byte, header.Seg_num)
+	all_versions := make([]int, header.Seg_num)
+	for i := 0; i < header.Seg_num; i++ {
+		if seg, ok := updates[i]; ok {
+			packed[i] = packVariants(seg)
+			all_versions[i] = versions[i]
+			auxiliary.UpdateMerkleLeaf(tree, i, segmentLeaf(i, versions[i], packed[i]), auxiliary.Mimchashcurve)
+			continue
+		}
+		packed[i], _, all_versions[i], err = sf.readRaw(i)
+		if err != nil {
+			log.Fatalf("can not read, err is %+v", err)
+		}
+	}
+	sf.Close()
+
+	header.Root = tree[len(tree)-1][0]
+	writeSegmentFile(path, header, packed, all_versions, tree)
+	return header.Root
+}
@@ -0,0 +1,195 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"crypto/ed25519"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/legacy holds the csv segments of Alice written by the baseline EncryptAndSaveData with Seg_num 8 and
+// Minimal_Blocksize 4, in default (_Segments) and precomputed (_Hosted_Segments) mode, from Alice_Plaintext.csv
+func TestConvertSegmentsCSV(t *testing.T) {
+	legacy, _ := filepath.Abs("testdata/legacy")
+	for _, file := range []string{"Alice_Segments.csv", "Alice_Hosted_Segments.csv"} {
+		t.Run(file, func(t *testing.T) {
+			people := newTestRoot(t, "Alice")[0]
+			data, err := os.ReadFile(filepath.Join(legacy, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make(map[int]int)
+			RSIDs, GTs := auxiliary.ReadGenotypeCSV(filepath.Join(legacy, "Alice_Plaintext.csv"))
+			for i := range RSIDs {
+				want[RSIDs[i]] = GTs[i]
+			}
+
+			path := segmentFilePathWith(people, file)
+			os.MkdirAll(filepath.Dir(path), os.ModePerm)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			ConvertSegmentsCSV(people)
+
+			sample := DecryptSegmentsToSample(people)
+			if len(sample.RsID) != len(want) {
+				t.Fatalf("%d variants after the conversion, want %d", len(sample.RsID), len(want))
+			}
+			for i, rsid := range sample.RsID {
+				if gt, ok := want[rsid]; !ok || gt != sample.Genotype[i] {
+					t.Errorf("rs%d: got %s, want %s", rsid, auxiliary.Genotype_i2s(sample.Genotype[i]), auxiliary.Genotype_i2s(gt))
+				}
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("the csv file is not kept: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadSegmentsCSVMalformed(t *testing.T) {
+	path := t.TempDir() + "/bad.csv"
+	for _, data := range []string{
+		"Alice,Key hash1: 1,Key hash2: 2\nSegment0,Variants amount: 2\nrs1 0|1\n",
+		"Alice,Key hash1: 1,Key hash2: 2\nSegment0,Variants amount: 1\nrs1 0|4\n",
+		"Alice,Key hash1: 1,Key hash2: 2\nSegment1,Variants amount: 1\nrs1 0|1\n",
+		"Alice,Key hash1: x,Key hash2: 2\n",
+	} {
+		os.WriteFile(path, []byte(data), 0644)
+		if _, _, _, err := ReadSegmentsCSV(path); err == nil {
+			t.Errorf("no error for %q", data)
+		}
+	}
+}
+
+func TestDataHashSignature(t *testing.T) {
+	people := newTestRoot(t, "Alice")[0]
+	os.MkdirAll(filepath.Dir(DataHashPath()), os.ModePerm)
+	SaveDataHash(people, []byte{1, 2, 3})
+	datahash, err := readDataHash()
+	if err != nil || !auxiliary.HashEqual(datahash[people.Name], []byte{1, 2, 3}) {
+		t.Fatalf("got %v, %v", datahash, err)
+	}
+
+	signed, _ := os.ReadFile(DataHashPath())
+	modes := auxiliary.ReadKeyModes().String()
+	for name, data := range map[string]string{
+		"rewritten root": strings.Replace(string(signed), ","+new(big.Int).SetBytes([]byte{1, 2, 3}).String()+",", ",7,", 1),
+		"unsigned row":   string(signed) + people.Name + ",\"" + modes + "\",7\n",
+	} {
+		os.WriteFile(DataHashPath(), []byte(data), 0644)
+		if _, err := readDataHash(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// A row signed by another key fails against the pinned public key
+	t.Setenv(auxiliary.Signer_PublicKey_EnvVar, hex.EncodeToString(auxiliary.SignerPublicKey()))
+	_, other, _ := ed25519.GenerateKey(nil)
+	msg := []byte("Governome " + dataHashDomain + "\x00" + string(dataHashMessage([]string{people.Name, modes, "7"})))
+	forged := people.Name + ",\"" + modes + "\",7," + hex.EncodeToString(ed25519.Sign(other, msg)) + "\n"
+	os.WriteFile(DataHashPath(), append(signed, forged...), 0644)
+	if _, err := readDataHash(); err == nil {
+		t.Error("forged row: no error")
+	}
+}
+
+// Files of precomputed mode are moved to the paths of the dataset, but never over the files of the default mode
+func TestMigrateLegacyHostedFiles(t *testing.T) {
+	peoples := newTestRoot(t, "Alice", "Bob")
+	os.Remove(auxiliary.KeyModesPath())
+	auxiliary.SaveKeyModes(auxiliary.DefaultKeyModes(auxiliary.Default_KeySharing.Holders, true))
+	write := func(path, data string) {
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, people := range peoples {
+		write(legacyHostedSegmentFilePath(people, ".bin"), "hosted "+people.Name)
+	}
+	write(SegmentFilePath(peoples[1], ".bin"), "default Bob")
+
+	if os.Getenv("GOVERNOME_TEST_MIGRATE") == "1" {
+		MigrateLegacyHostedFiles()
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMigrateLegacyHostedFiles$")
+	cmd.Env = append(os.Environ(), "GOVERNOME_TEST_MIGRATE=1")
+	if err := cmd.Run(); err == nil {
+		t.Fatal("the migration does not stop when both files exist")
+	}
+	for _, people := range peoples {
+		if _, err := os.Stat(legacyHostedSegmentFilePath(people, ".bin")); err != nil {
+			t.Errorf("%s: a file is moved although the migration stopped", people.Name)
+		}
+	}
+	if data, _ := os.ReadFile(SegmentFilePath(peoples[1], ".bin")); string(data) != "default Bob" {
+		t.Errorf("the file of the default mode is replaced by %q", data)
+	}
+
+	os.Remove(SegmentFilePath(peoples[1], ".bin"))
+	MigrateLegacyHostedFiles()
+	for _, people := range peoples {
+		if data, _ := os.ReadFile(SegmentFilePath(people, ".bin")); string(data) != "hosted "+people.Name {
+			t.Errorf("%s: got %q after the migration", people.Name, data)
+		}
+		if _, err := os.Stat(legacyHostedSegmentFilePath(people, ".bin")); err == nil {
+			t.Errorf("%s: the file of precomputed mode is kept", people.Name)
+		}
+	}
+}
+
+// Seg_Dec decrypts a stored segment with the versioned IV
+func TestSegDec(t *testing.T) {
+	people, want, _ := newRotationRoot(t)
+	keys := LoadRawKeys(people)
+	found := 0
+	for segID := 0; segID < auxiliary.ReadSegParams().Seg_num; segID++ {
+		data, _ := ReadSegmentData(people, segID, 1)
+		for _, v := range Seg_Dec(data, keys, segID, ReadSegmentVersion(people, segID)) {
+			rsid, gt := Decode_rsID(v.Rsid), Decode_Genotype(v.Genotype)
+			if rsid == 0 && gt == 0 {
+				continue
+			}
+			if w, ok := want[rsid]; !ok || w != gt {
+				t.Fatalf("segment %d: rs%d decrypts to %d", segID, rsid, gt)
+			}
+			found++
+		}
+	}
+	if found != len(want) {
+		t.Fatalf("%d variants, want %d", found, len(want))
+	}
+}
@@ -28,17 +28,88 @@ package trivium
 
 import (
 	"Governome/auxiliary"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
 
 	"github.com/sp301415/tfhe-go/tfhe"
 )
 
+// A stream cipher in plaintext, the key and the IV have the bits of its auxiliary.Cipher
+type StreamCipher interface {
+	Init(key []int, iv []int)
+	Genbit() int
+}
+
+// A stream cipher in TFHE ciphertext, the key is encrypted and the IV is public
+type HomomorphicStreamCipher interface {
+	Init(key []tfhe.LWECiphertext[uint32], eval *tfhe.BinaryEvaluator, iv []int)
+	Genbit(eval *tfhe.BinaryEvaluator) tfhe.LWECiphertext[uint32]
+}
+
+// A stream cipher in TFHE ciphertext whose state after Init can be written and read back, see InitCachedStreamCipher
+// FiLIP has no warm-up, so it does not implement it
+type WarmupState interface {
+	WriteState(w io.Writer) error
+	ReadState(r io.Reader) error
+}
+
+// New plaintext stream cipher of a cipher kind, Trivium64 for Trivium
+func NewStreamCipher(c auxiliary.Cipher) StreamCipher {
+	switch c {
+	case auxiliary.Cipher_Kreyvium:
+		return &Kreyvium{}
+	case auxiliary.Cipher_FiLIP:
+		return &FiLIP{Params: FiLIP_Dataset}
+	}
+	return &Trivium64{}
+}
+
+// New stream cipher in TFHE ciphertext of a cipher kind, Trivium evaluates a batch with workers copies of the evaluator,
+// 1 inside a pool of goroutines and runtime.NumCPU() for a single stream
+func NewHomomorphicStreamCipher(c auxiliary.Cipher, workers int) HomomorphicStreamCipher {
+	switch c {
+	case auxiliary.Cipher_Kreyvium:
+		return &Kreyvium_TFHE{}
+	case auxiliary.Cipher_FiLIP:
+		return &FiLIP_TFHE{Params: FiLIP_Dataset}
+	}
+	return &Trivium_TFHE{Workers: workers}
+}
+
+// Number of bootstrapped gates of a cipher in TFHE ciphertext, to initialize it and per stream bit, a NOT is free
+func GateCount(c auxiliary.Cipher) (init int, per_bit int) {
+	switch c {
+	case auxiliary.Cipher_Kreyvium:
+		// Trivium and the key bit added to t3
+		return 1152 * 15, 15
+	case auxiliary.Cipher_FiLIP:
+		and, xor := FiLIP_Dataset.Gates()
+		return 0, and + xor
+	}
+	// 11 XOR and 3 AND, see Trivium_TFHE.step
+	return 1152 * 14, 14
+}
+
 type Trivium struct {
 	L [288]int
 }
 
+// Number of ciphertexts in a register ring of Trivium_TFHE, more than the 111 + 64 bits a batch reads and writes
+const trivium_ring = 256
+
+// Trivium in TFHE ciphertext. Each register is a ring of the bits shifted into it, the bit of step T at T % trivium_ring,
+// so a step writes three ciphertexts instead of moving 288. As in Trivium64, the 64 steps of a batch are independent and
+// are evaluated in parallel on copies of the evaluator
 type Trivium_TFHE struct {
-	L [288]tfhe.LWECiphertext[uint32]
+	A, B, C [trivium_ring]tfhe.LWECiphertext[uint32]
+	T       int // steps taken, from trivium_ring / 2 so that the bits of the initial state have no negative step
+	Workers int // copies of the evaluator of a batch, in goroutines if more than 1, 1 if 0
+	stream  [64]tfhe.LWECiphertext[uint32]
+	left    int
+	base    *tfhe.BinaryEvaluator
+	evals   []*tfhe.BinaryEvaluator
 }
 
 // Generate a stream bit
@@ -63,43 +134,149 @@ func (triv *Trivium) Genbit() int {
 	return z
 }
 
-// Generate a stream bit in TFHE ciphertext
-func (triv *Trivium_TFHE) Genbit(eval *tfhe.BinaryEvaluator) tfhe.LWECiphertext[uint32] {
+// Evaluate step T of Trivium in TFHE ciphertext, the steps of a batch only read bits shifted in 66 steps ago or earlier
+func (triv *Trivium_TFHE) step(T int, eval *tfhe.BinaryEvaluator) tfhe.LWECiphertext[uint32] {
+	tap := func(r *[trivium_ring]tfhe.LWECiphertext[uint32], d int) tfhe.LWECiphertext[uint32] {
+		return r[(T-d)%trivium_ring]
+	}
 
-	t1 := eval.XOR(triv.L[65], triv.L[92])
-	t2 := eval.XOR(triv.L[161], triv.L[176])
-	t3 := eval.XOR(triv.L[242], triv.L[287])
+	t1 := eval.XOR(tap(&triv.A, 66), tap(&triv.A, 93))
+	t2 := eval.XOR(tap(&triv.B, 69), tap(&triv.B, 84))
+	t3 := eval.XOR(tap(&triv.C, 66), tap(&triv.C, 111))
 	z := eval.XOR(t1, t2)
 	z = eval.XOR(z, t3)
 
-	temp1 := eval.AND(triv.L[90], triv.L[91])
+	temp1 := eval.AND(tap(&triv.A, 91), tap(&triv.A, 92))
 	t1 = eval.XOR(t1, temp1)
-	t1 = eval.XOR(t1, triv.L[170])
+	t1 = eval.XOR(t1, tap(&triv.B, 78))
 
-	temp2 := eval.AND(triv.L[174], triv.L[175])
+	temp2 := eval.AND(tap(&triv.B, 82), tap(&triv.B, 83))
 	t2 = eval.XOR(t2, temp2)
-	t2 = eval.XOR(t2, triv.L[263])
+	t2 = eval.XOR(t2, tap(&triv.C, 87))
 
-	temp3 := eval.AND(triv.L[285], triv.L[286])
+	temp3 := eval.AND(tap(&triv.C, 109), tap(&triv.C, 110))
 	t3 = eval.XOR(t3, temp3)
-	t3 = eval.XOR(t3, triv.L[68])
+	t3 = eval.XOR(t3, tap(&triv.A, 69))
 
-	for i := 287; i > 0; i-- {
-		triv.L[i] = triv.L[i-1]
+	triv.A[T%trivium_ring] = t3
+	triv.B[T%trivium_ring] = t1
+	triv.C[T%trivium_ring] = t2
+
+	return z
+}
+
+// Evaluate the next 64 steps in parallel, each copy of the evaluator takes every Workers-th step
+func (triv *Trivium_TFHE) batch(eval *tfhe.BinaryEvaluator) {
+	if triv.base != eval || len(triv.evals) == 0 {
+		workers := triv.Workers
+		if workers <= 0 {
+			workers = 1
+		}
+		if workers > 64 {
+			workers = 64
+		}
+		triv.base = eval
+		triv.evals = make([]*tfhe.BinaryEvaluator, workers)
+		for w := range triv.evals {
+			triv.evals[w] = eval.ShallowCopy()
+		}
 	}
 
-	triv.L[0] = t3
-	triv.L[93] = t1
-	triv.L[177] = t2
+	// a single copy runs in the calling goroutine, e.g. one of the pool of Data_Recover
+	if len(triv.evals) == 1 {
+		for b := 0; b < 64; b++ {
+			triv.stream[b] = triv.step(triv.T+b, triv.evals[0])
+		}
+	} else {
+		var wg sync.WaitGroup
+		wg.Add(len(triv.evals))
+		for w := range triv.evals {
+			w := w
+			go func() {
+				for b := w; b < 64; b += len(triv.evals) {
+					triv.stream[b] = triv.step(triv.T+b, triv.evals[w])
+				}
+				wg.Done()
+			}()
+		}
+		wg.Wait()
+	}
+
+	triv.T += 64
+	triv.left = 64
+}
 
+// Generate a stream bit in TFHE ciphertext, from a batch of 64
+func (triv *Trivium_TFHE) Genbit(eval *tfhe.BinaryEvaluator) tfhe.LWECiphertext[uint32] {
+	if triv.left == 0 {
+		triv.batch(eval)
+	}
+	z := triv.stream[64-triv.left]
+	triv.left--
 	return z
 }
 
+// Load the state laid out as in Trivium.Init into the rings, L[i] of a register was shifted in i + 1 steps ago
+func (triv *Trivium_TFHE) load(L [288]tfhe.LWECiphertext[uint32]) {
+	triv.T = trivium_ring / 2
+	for i := 0; i < 93; i++ {
+		triv.A[triv.T-i-1] = L[i]
+	}
+	for i := 0; i < 84; i++ {
+		triv.B[triv.T-i-1] = L[93+i]
+	}
+	for i := 0; i < 111; i++ {
+		triv.C[triv.T-i-1] = L[177+i]
+	}
+	triv.left = 0
+}
+
+// The state laid out as in Trivium.Init, the inverse of load
+func (triv *Trivium_TFHE) state() (L [288]tfhe.LWECiphertext[uint32]) {
+	for i := 0; i < 93; i++ {
+		L[i] = triv.A[(triv.T-i-1)%trivium_ring]
+	}
+	for i := 0; i < 84; i++ {
+		L[93+i] = triv.B[(triv.T-i-1)%trivium_ring]
+	}
+	for i := 0; i < 111; i++ {
+		L[177+i] = triv.C[(triv.T-i-1)%trivium_ring]
+	}
+	return
+}
+
+// Write the 288 ciphertexts of the state, only between batches as after Init
+func (triv *Trivium_TFHE) WriteState(w io.Writer) error {
+	if triv.left != 0 {
+		return errors.New("the state of Trivium can only be written between batches")
+	}
+	for _, ct := range triv.state() {
+		if _, err := ct.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read a state written by WriteState, the next stream bit is the one after the written state
+func (triv *Trivium_TFHE) ReadState(r io.Reader) error {
+	var L [288]tfhe.LWECiphertext[uint32]
+	for i := 0; i < 288; i++ {
+		if _, err := L[i].ReadFrom(r); err != nil {
+			return err
+		}
+	}
+	triv.load(L)
+	return nil
+}
+
 // Set params from plaintext trivium
 func (triv_tfheb *Trivium_TFHE) Set(pk auxiliary.PublicKey_tfheb, triv Trivium) {
+	var L [288]tfhe.LWECiphertext[uint32]
 	for i := 0; i < 288; i++ {
-		triv_tfheb.L[i] = auxiliary.EncWithPublicKey_tfheb(uint32(triv.L[i]), pk)
+		L[i] = auxiliary.EncWithPublicKey_tfheb(uint32(triv.L[i]), pk)
 	}
+	triv_tfheb.load(L)
 }
 
 // Init the key for Trivium
@@ -121,26 +298,29 @@ func (triv *Trivium) Init(key []int, iv []int) {
 	}
 }
 
-// Init in ciphertext
-func (triv *Trivium_TFHE) Init(key1, key2 []tfhe.LWECiphertext[uint32], eval *tfhe.BinaryEvaluator, iv []int) {
-	if len(key1) != 80 || len(key2) != 80 || len(iv) != 80 {
+// Init in ciphertext, the key is recovered from the key shares by SegKeys.Combine
+func (triv *Trivium_TFHE) Init(key []tfhe.LWECiphertext[uint32], eval *tfhe.BinaryEvaluator, iv []int) {
+	if len(key) != 80 || len(iv) != 80 {
 		fmt.Println("Invalid Input!")
 		return
 	}
+	var L [288]tfhe.LWECiphertext[uint32]
 	for i := 0; i < 288; i++ {
-		triv.L[i] = tfhe.NewLWECiphertext[uint32](eval.Parameters)
-		triv.L[i].Value[0] += auxiliary.ScaleConstant_tfheb(0)
+		L[i] = tfhe.NewLWECiphertext[uint32](eval.Parameters)
+		L[i].Value[0] += auxiliary.ScaleConstant_tfheb(0)
 	}
 
 	for i := 0; i < 80; i++ {
-		triv.L[i] = eval.XOR(key1[i], key2[i])
-		triv.L[i+93] = NewTFHECiphertext(iv[i], eval.Parameters)
+		L[i] = key[i].Copy()
+		L[i+93] = NewTFHECiphertext(iv[i], eval.Parameters)
 	}
-	triv.L[285] = NewTFHECiphertext(1, eval.Parameters)
-	triv.L[286] = NewTFHECiphertext(1, eval.Parameters)
-	triv.L[287] = NewTFHECiphertext(1, eval.Parameters)
+	L[285] = NewTFHECiphertext(1, eval.Parameters)
+	L[286] = NewTFHECiphertext(1, eval.Parameters)
+	L[287] = NewTFHECiphertext(1, eval.Parameters)
+	triv.load(L)
 
-	for i := 0; i < 1152; i++ {
-		triv.Genbit(eval)
+	for i := 0; i < 1152/64; i++ {
+		triv.batch(eval)
 	}
+	triv.left = 0
 }
@@ -0,0 +1,77 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// The rings of Trivium_TFHE give the stream of Trivium bit for bit, over several batches, with one copy of the
+// evaluator and with several, and after the state is written and read back
+func TestTriviumTFHE(t *testing.T) {
+	params := testParams()
+	enc := tfhe.NewBinaryEncryptor(params)
+	eval := tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+
+	key, iv := make([]int, 80), make([]int, 80)
+	key_ct := make([]tfhe.LWECiphertext[uint32], len(key))
+	for i := range key {
+		key[i], iv[i] = rand.Intn(2), rand.Intn(2)
+		key_ct[i] = enc.EncryptLWEBool(key[i] == 1)
+	}
+
+	for _, workers := range []int{1, 4} {
+		var ref Trivium
+		ref.Init(key, iv)
+		triv := NewHomomorphicStreamCipher(auxiliary.Cipher_Trivium, workers).(*Trivium_TFHE)
+		triv.Init(key_ct, eval, iv)
+
+		var buf bytes.Buffer
+		if err := triv.WriteState(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var read Trivium_TFHE
+		if err := read.ReadState(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3*64; i++ {
+			z := ref.Genbit()
+			if enc.DecryptLWEBool(triv.Genbit(eval)) != (z == 1) {
+				t.Fatalf("Trivium_TFHE with %d workers differs from Trivium at bit %d", workers, i)
+			}
+			if enc.DecryptLWEBool(read.Genbit(eval)) != (z == 1) {
+				t.Fatalf("Trivium_TFHE read back differs from Trivium at bit %d", i)
+			}
+		}
+	}
+}
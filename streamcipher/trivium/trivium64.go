@@ -0,0 +1,172 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"fmt"
+)
+
+// Trivium producing 64 stream bits per step, output-identical to Trivium. Each register keeps the last 128 bits
+// shifted into it, bit i of the second word the bit shifted in 64 - i steps ago, since every tap is at least 65 steps
+// behind the input, 64 steps are computed at once
+type Trivium64 struct {
+	A, B, C [2]uint64
+	stream  uint64
+	left    int
+}
+
+// 64 bits shifted into a register from d steps ago, bit i shifted in i steps later
+func triviumTap(r [2]uint64, d int) uint64 {
+	p := 128 - d
+	return r[0]>>p | r[1]<<(64-p)
+}
+
+// Generate 64 stream bits, bit i the i-th stream bit
+func (triv *Trivium64) Genword() uint64 {
+	t1 := triviumTap(triv.A, 66) ^ triviumTap(triv.A, 93)
+	t2 := triviumTap(triv.B, 69) ^ triviumTap(triv.B, 84)
+	t3 := triviumTap(triv.C, 66) ^ triviumTap(triv.C, 111)
+	z := t1 ^ t2 ^ t3
+
+	t1 ^= triviumTap(triv.A, 91)&triviumTap(triv.A, 92) ^ triviumTap(triv.B, 78)
+	t2 ^= triviumTap(triv.B, 82)&triviumTap(triv.B, 83) ^ triviumTap(triv.C, 87)
+	t3 ^= triviumTap(triv.C, 109)&triviumTap(triv.C, 110) ^ triviumTap(triv.A, 69)
+
+	triv.A = [2]uint64{triv.A[1], t3}
+	triv.B = [2]uint64{triv.B[1], t1}
+	triv.C = [2]uint64{triv.C[1], t2}
+
+	return z
+}
+
+// Generate a stream bit
+func (triv *Trivium64) Genbit() int {
+	if triv.left == 0 {
+		triv.stream = triv.Genword()
+		triv.left = 64
+	}
+	z := int(triv.stream & 1)
+	triv.stream >>= 1
+	triv.left--
+	return z
+}
+
+// Init the key for Trivium64, the state is laid out as in Trivium.Init
+func (triv *Trivium64) Init(key []int, iv []int) {
+	if len(key) != 80 || len(iv) != 80 {
+		fmt.Println("Invalid Input!")
+		return
+	}
+	var L [288]int
+	for i := 0; i < 80; i++ {
+		L[i] = key[i]
+		L[i+93] = iv[i]
+	}
+	L[285] = 1
+	L[286] = 1
+	L[287] = 1
+
+	// L[i] of a register was shifted in i + 1 steps ago
+	load := func(bits []int) (r [2]uint64) {
+		for i, b := range bits {
+			p := 127 - i
+			r[p/64] |= uint64(b) << (p % 64)
+		}
+		return
+	}
+	triv.A = load(L[:93])
+	triv.B = load(L[93:177])
+	triv.C = load(L[177:])
+	triv.left = 0
+
+	for i := 0; i < 1152/64; i++ {
+		triv.Genword()
+	}
+}
+
+// Number of steps Trivium_Sliced takes before it moves its state back to the end of the buffer
+const sliced_window = 1024
+
+// Trivium of 64 instances bit-sliced into words, lane l of a word belongs to instance l, output-identical to Trivium
+// for each instance. The state L[i] is buf[head + i], so a step moves head instead of the 288 words
+type Trivium_Sliced struct {
+	buf  [288 + sliced_window]uint64
+	head int
+}
+
+// Init up to 64 instances with their keys and IVs, the lanes of missing instances are zero
+func (triv *Trivium_Sliced) Init(keys [][]int, ivs [][]int) {
+	if len(keys) > 64 || len(keys) != len(ivs) {
+		fmt.Println("Invalid Input!")
+		return
+	}
+	triv.buf = [288 + sliced_window]uint64{}
+	triv.head = sliced_window
+	L := triv.buf[triv.head:]
+	for l := range keys {
+		if len(keys[l]) != 80 || len(ivs[l]) != 80 {
+			fmt.Println("Invalid Input!")
+			return
+		}
+		for i := 0; i < 80; i++ {
+			L[i] |= uint64(keys[l][i]) << l
+			L[i+93] |= uint64(ivs[l][i]) << l
+		}
+		L[285] |= 1 << l
+		L[286] |= 1 << l
+		L[287] |= 1 << l
+	}
+
+	for i := 0; i < 1152; i++ {
+		triv.Genword()
+	}
+}
+
+// Generate a stream bit of each instance, in its lane
+func (triv *Trivium_Sliced) Genword() uint64 {
+	L := triv.buf[triv.head : triv.head+288]
+	t1 := L[65] ^ L[92]
+	t2 := L[161] ^ L[176]
+	t3 := L[242] ^ L[287]
+	z := t1 ^ t2 ^ t3
+
+	t1 ^= L[90]&L[91] ^ L[170]
+	t2 ^= L[174]&L[175] ^ L[263]
+	t3 ^= L[285]&L[286] ^ L[68]
+
+	if triv.head == 0 {
+		copy(triv.buf[sliced_window:], triv.buf[:287])
+		triv.head = sliced_window
+	}
+	triv.head--
+	L = triv.buf[triv.head : triv.head+288]
+	L[0] = t3
+	L[93] = t1
+	L[177] = t2
+
+	return z
+}
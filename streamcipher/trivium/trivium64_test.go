@@ -0,0 +1,119 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+// Test vectors of the eSTREAM submission of Trivium (verified.test-vectors of the eSTREAM project), the first 128
+// stream bits of Set 1 vector 0, Set 2 vector 0 and Set 6 vector 0, key, IV and stream in the hex of the listing
+var trivium_vectors = []struct {
+	name, key, iv, stream string
+}{
+	{"Set 1, vector 0", "80000000000000000000", "00000000000000000000", "38EB86FF730D7A9CAF8DF13A4420540D"},
+	{"Set 2, vector 0", "00000000000000000000", "00000000000000000000", "FBE0BF265859051B517A2E4E239FC97F"},
+	{"Set 6, vector 0", "0053A6F94C9FF24598EB", "0D74DB42A91077DE45AC", "F4CD954A717F26A7D6930830C4E7CF08"},
+}
+
+// The bits of a key or an IV of the listing, the reference code loads the bytes from the last one, each from its most
+// significant bit
+func estreamKeyBits(t *testing.T, s string) []int {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 10 {
+		t.Fatalf("invalid vector %q", s)
+	}
+	bits := make([]int, 80)
+	for i := range bits {
+		bits[i] = int(b[9-i/8]>>(7-i%8)) & 1
+	}
+	return bits
+}
+
+// The stream bits of the listing, bit i of byte j the stream bit 8 * j + i
+func estreamStreamBits(t *testing.T, s string) []int {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid vector %q", s)
+	}
+	bits := make([]int, 8*len(b))
+	for i := range bits {
+		bits[i] = int(b[i/8]>>(i%8)) & 1
+	}
+	return bits
+}
+
+// Trivium, Trivium64 and Trivium_Sliced on the test vectors, and Trivium64 and Trivium_Sliced against Trivium on
+// random keys and IVs in the other slices
+func TestFastTrivium(t *testing.T) {
+	keys := make([][]int, 64)
+	ivs := make([][]int, 64)
+	for l := range keys {
+		if l < len(trivium_vectors) {
+			keys[l], ivs[l] = estreamKeyBits(t, trivium_vectors[l].key), estreamKeyBits(t, trivium_vectors[l].iv)
+			continue
+		}
+		keys[l] = make([]int, 80)
+		ivs[l] = make([]int, 80)
+		for i := 0; i < 80; i++ {
+			keys[l][i] = rand.Intn(2)
+			ivs[l][i] = rand.Intn(2)
+		}
+	}
+
+	var sliced Trivium_Sliced
+	sliced.Init(keys, ivs)
+	sliced_stream := make([]uint64, 2*sliced_window)
+	for i := range sliced_stream {
+		sliced_stream[i] = sliced.Genword()
+	}
+
+	for l := range keys {
+		var want []int
+		if l < len(trivium_vectors) {
+			want = estreamStreamBits(t, trivium_vectors[l].stream)
+		}
+		var ref Trivium
+		ref.Init(keys[l], ivs[l])
+		var fast Trivium64
+		fast.Init(keys[l], ivs[l])
+		for i := range sliced_stream {
+			z := ref.Genbit()
+			if i < len(want) && want[i] != z {
+				t.Fatalf("Trivium differs from %s at bit %d", trivium_vectors[l].name, i)
+			}
+			if fast.Genbit() != z {
+				t.Fatalf("Trivium64 differs from Trivium on key %d at bit %d", l, i)
+			}
+			if int(sliced_stream[i]>>l)&1 != z {
+				t.Fatalf("Trivium_Sliced differs from Trivium on key %d at bit %d", l, i)
+			}
+		}
+	}
+}
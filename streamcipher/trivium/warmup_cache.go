@@ -0,0 +1,218 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/auxiliary"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Cache of the states of the stream ciphers in TFHE ciphertext after Init, one file per individual and application ID,
+// so that later queries of the same stored key shares skip SegKeys.Combine and the 1152 warm-up rounds
+// header: magic, version, the sha256 digest of the parameters, cipher, ID, IV and key shares the state was computed from
+// body: the state written by WarmupState.WriteState
+// the states are encrypted under the same TFHE key as the SegKeys, a file of other key shares or IV is computed again
+const (
+	WarmupCache_Magic   = "GVWC"
+	WarmupCache_Version = 1
+	warmupCacheFileMode = 0600
+)
+
+// Bound of the size of all cached states under the root folder, the least recently used states are removed to stay in it
+var WarmupCache_MaxBytes int64 = 1 << 30
+
+// Lock of the cache, the individuals of a query write their states in parallel
+var warmupCacheLock sync.Mutex
+
+// Get the folder of all cached states
+func warmupCacheRoot() string {
+	return auxiliary.ReadPath() + "/Warmup_Cache"
+}
+
+// Get the folder of the cached states of an individual
+func WarmupCacheFolder(people auxiliary.People) string {
+	return warmupCacheRoot() + "/" + auxiliary.MappingPeopletoFolder(people) + "/" + people.Name
+}
+
+// Get the path of the cached state of an individual for an application ID
+func WarmupCachePath(people auxiliary.People, id int) string {
+	return WarmupCacheFolder(people) + "/ID_" + strconv.Itoa(id) + ".bin"
+}
+
+// Remove the cached states of an individual, after the key shares are rotated or the individual is erased
+func RemoveWarmupCache(people auxiliary.People) {
+	os.RemoveAll(WarmupCacheFolder(people))
+}
+
+// Digest of everything the state after Init depends on
+func warmupDigest(cipher auxiliary.Cipher, id int, iv []int, segkeys SegKeys, params tfhe.Parameters[uint32]) []byte {
+	h := sha256.New()
+	h.Write([]byte(WarmupCache_Magic))
+	h.Write(ParamsFingerprint(params))
+	writeBytes16(h, []byte(cipher.String()))
+	binary.Write(h, binary.LittleEndian, int64(id))
+	for _, b := range iv {
+		h.Write([]byte{byte(b)})
+	}
+	binary.Write(h, binary.LittleEndian, [2]int64{int64(segkeys.Sharing.Holders), int64(segkeys.Sharing.Threshold)})
+
+	holders := make([]int, 0, len(segkeys.Keys))
+	for k := range segkeys.Keys {
+		holders = append(holders, k)
+	}
+	sort.Ints(holders)
+	for _, k := range holders {
+		binary.Write(h, binary.LittleEndian, [2]int64{int64(k), int64(len(segkeys.Keys[k]))})
+		for _, ct := range segkeys.Keys[k] {
+			ct.WriteTo(h)
+		}
+	}
+	return h.Sum(nil)
+}
+
+// Read a cached state into ws, the digest in the header must match
+func readWarmupCache(path string, digest []byte, ws WarmupState) error {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	magic := len(WarmupCache_Magic)
+	if len(file) < magic+2+len(digest) || string(file[:magic]) != WarmupCache_Magic {
+		return errors.New("not a warm-up cache file")
+	}
+	if version := binary.LittleEndian.Uint16(file[magic:]); version != WarmupCache_Version {
+		return fmt.Errorf("unsupported warm-up cache version %d", version)
+	}
+	if !bytes.Equal(file[magic+2:magic+2+len(digest)], digest) {
+		return errors.New("the cached state is of other key shares or IV")
+	}
+	r := bytes.NewReader(file[magic+2+len(digest):])
+	if err := ws.ReadState(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	// the modification time orders the states for pruneWarmupCache
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return nil
+}
+
+// Remove the least recently used states until a new state of size bytes fits in WarmupCache_MaxBytes, false if it does
+// not fit even in an empty cache
+func pruneWarmupCache(size int64) bool {
+	if size > WarmupCache_MaxBytes {
+		return false
+	}
+	type cached struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []cached
+	var total int64
+	filepath.Walk(warmupCacheRoot(), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, cached{path, info.Size(), info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+	for _, f := range files {
+		if total+size <= WarmupCache_MaxBytes {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+	return total+size <= WarmupCache_MaxBytes
+}
+
+// Write the state of ws to the cache, a failure is reported and the query goes on without the cache
+func writeWarmupCache(path string, digest []byte, ws WarmupState) {
+	var buf bytes.Buffer
+	buf.WriteString(WarmupCache_Magic)
+	binary.Write(&buf, binary.LittleEndian, uint16(WarmupCache_Version))
+	buf.Write(digest)
+	err := ws.WriteState(&buf)
+	warmupCacheLock.Lock()
+	defer warmupCacheLock.Unlock()
+	if err == nil && !pruneWarmupCache(int64(buf.Len())) {
+		err = fmt.Errorf("the state does not fit in %d bytes", WarmupCache_MaxBytes)
+	}
+	if err == nil {
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		err = os.WriteFile(path+".tmp", buf.Bytes(), warmupCacheFileMode)
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		fmt.Printf("can not cache the warm-up state in %s, err is %+v\n", path, err)
+	}
+}
+
+// Init a stream cipher in TFHE ciphertext for an application ID of an individual, the state is read from the cache if it
+// was computed from the same key shares and IV, otherwise the key shares are combined and the state is cached if the
+// SegKeys are stored, fresh SegKeys never match again. A segment ID is not cached, workers as in NewHomomorphicStreamCipher
+func InitCachedStreamCipher(people auxiliary.People, id int, cipher auxiliary.Cipher, segkeys SegKeys, iv []int, eval *tfhe.BinaryEvaluator, workers int) HomomorphicStreamCipher {
+	triv := NewHomomorphicStreamCipher(cipher, workers)
+	ws, ok := triv.(WarmupState)
+	if !ok || id < auxiliary.App_Domain {
+		triv.Init(segkeys.Combine(eval), eval, iv)
+		return triv
+	}
+
+	path := WarmupCachePath(people, id)
+	digest := warmupDigest(cipher, id, iv, segkeys, eval.Parameters)
+	if readWarmupCache(path, digest, ws) == nil {
+		return triv
+	}
+
+	triv = NewHomomorphicStreamCipher(cipher, workers)
+	triv.Init(segkeys.Combine(eval), eval, iv)
+	if segkeys.Stored {
+		writeWarmupCache(path, digest, triv.(WarmupState))
+	}
+	return triv
+}
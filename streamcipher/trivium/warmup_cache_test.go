@@ -0,0 +1,104 @@
+// Copyright 2024 The University of Hong Kong, Department of Computer Science
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+// 3. Neither the name of the copyright holder nor the
+//    names of its contributors may be used to endorse or promote products
+//    derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package trivium
+
+import (
+	"Governome/applications"
+	"Governome/auxiliary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sp301415/tfhe-go/tfhe"
+)
+
+// Only the states of stored SegKeys of an application are cached, and a later query reads them
+func TestInitCachedStreamCipher(t *testing.T) {
+	people := newTestRoot(t, "Alice")[0]
+	params := testParams()
+	enc := tfhe.NewBinaryEncryptor(params)
+	eval := tfhe.NewBinaryEvaluator(params, enc.GenEvaluationKeyParallel())
+
+	segkeys := NewSegKeys(auxiliary.Default_KeySharing)
+	for k := 1; k <= auxiliary.Default_KeySharing.Holders; k++ {
+		for i := 0; i < 80; i++ {
+			segkeys.Keys[k] = append(segkeys.Keys[k], enc.EncryptLWEBool(rand.Intn(2) == 1))
+		}
+	}
+	appid := applications.App_id_SearchPerson()
+	iv := GenIVHostedMode(appid, 80)
+	first := func(triv HomomorphicStreamCipher) bool { return enc.DecryptLWEBool(triv.Genbit(eval)) }
+
+	want := first(InitCachedStreamCipher(people, appid, auxiliary.Cipher_Trivium, segkeys, iv, eval, 1))
+	if _, err := os.Stat(WarmupCachePath(people, appid)); err == nil {
+		t.Fatal("the state of fresh SegKeys is cached")
+	}
+	InitCachedStreamCipher(people, 3, auxiliary.Cipher_Trivium, segkeys, SegmentIV(auxiliary.Cipher_Trivium, 3, 0), eval, 1)
+	if _, err := os.Stat(WarmupCachePath(people, 3)); err == nil {
+		t.Fatal("the state of a segment is cached")
+	}
+
+	segkeys.Stored = true
+	InitCachedStreamCipher(people, appid, auxiliary.Cipher_Trivium, segkeys, iv, eval, 1)
+	var read Trivium_TFHE
+	if err := readWarmupCache(WarmupCachePath(people, appid), warmupDigest(auxiliary.Cipher_Trivium, appid, iv, segkeys, params), &read); err != nil {
+		t.Fatalf("the state of stored SegKeys is not cached: %v", err)
+	}
+	if first(InitCachedStreamCipher(people, appid, auxiliary.Cipher_Trivium, segkeys, iv, eval, 1)) != want {
+		t.Error("the cached state gives another stream")
+	}
+}
+
+// The least recently used states are removed to keep the cache in WarmupCache_MaxBytes
+func TestPruneWarmupCache(t *testing.T) {
+	newTestRoot(t, "Alice")
+	max := WarmupCache_MaxBytes
+	WarmupCache_MaxBytes = 250
+	t.Cleanup(func() { WarmupCache_MaxBytes = max })
+
+	paths := make([]string, 3)
+	for i := range paths {
+		paths[i] = filepath.Join(warmupCacheRoot(), "a", string(rune('0'+i))+".bin")
+		os.MkdirAll(filepath.Dir(paths[i]), os.ModePerm)
+		os.WriteFile(paths[i], make([]byte, 100), 0600)
+		used := time.Now().Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(paths[i], used, used)
+	}
+	if pruneWarmupCache(300) {
+		t.Error("a state larger than the cache fits")
+	}
+	if !pruneWarmupCache(100) {
+		t.Fatal("a state does not fit after the pruning")
+	}
+	for i, path := range paths {
+		if _, err := os.Stat(path); (err == nil) != (i == 2) {
+			t.Errorf("state %d: removed %v, want %v", i, err != nil, i != 2)
+		}
+	}
+}